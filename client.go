@@ -112,6 +112,7 @@ type Client struct {
 	AuthVersion     AuthVersionType //  v1 or v4 signature,default is v1
 
 	accessKeyLock       sync.RWMutex
+	httpClientOnce      sync.Once
 	credentialsProvider CredentialsProvider
 	// User defined common headers.
 	// When conflict with sdk pre-defined headers, the value will
@@ -120,17 +121,20 @@ type Client struct {
 	InnerHeaders  map[string]string
 }
 
-// repeated calls only create one http client
+// initHttpClient sets the default timeouts and http client on the first request,
+// it is called by the requests sent concurrently, so it only runs once
 func (c *Client) initHttpClient() {
-	if c.RequestTimeOut == 0 {
-		c.RequestTimeOut = defaultRequestTimeout
-	}
-	if c.RetryTimeOut == 0 {
-		c.RetryTimeOut = defaultRetryTimeout
-	}
-	if c.HTTPClient == nil {
-		c.HTTPClient = newDefaultHTTPClient(c.RequestTimeOut)
-	}
+	c.httpClientOnce.Do(func() {
+		if c.RequestTimeOut == 0 {
+			c.RequestTimeOut = defaultRequestTimeout
+		}
+		if c.RetryTimeOut == 0 {
+			c.RetryTimeOut = defaultRetryTimeout
+		}
+		if c.HTTPClient == nil {
+			c.HTTPClient = newDefaultHTTPClient(c.RequestTimeOut)
+		}
+	})
 }
 
 func convert(c *Client, projName string) *LogProject {
@@ -1,11 +1,15 @@
 package sls_test
 
 import (
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
 	"github.com/stretchr/testify/require"
 
 	sls "github.com/aliyun/aliyun-log-go-sdk"
@@ -107,3 +111,81 @@ func TestPutLogsMockServerError(t *testing.T) {
 	require.Equal(t, "LogStoreNotExist", slsErr.Code)
 	require.Equal(t, int32(404), slsErr.HTTPCode)
 }
+
+// TestPostLogStoreLogsRawMock checks that an encoded LogGroup is sent as is,
+// and the body read by net/http doesn't share memory with it.
+func TestPostLogStoreLogsRawMock(t *testing.T) {
+	const (
+		project  = "my-project"
+		logstore = "my-store"
+	)
+
+	lg := &sls.LogGroup{
+		Topic: proto.String("topic"),
+		Logs: []*sls.Log{
+			{
+				Time: proto.Uint32(1700000000),
+				Contents: []*sls.LogContent{
+					{Key: proto.String("k"), Value: proto.String("v")},
+				},
+			},
+		},
+	}
+	raw, err := proto.Marshal(lg)
+	require.NoError(t, err)
+
+	for _, compressType := range []int{sls.Compress_LZ4, sls.Compress_ZSTD, sls.Compress_None} {
+		transport := testutil.NewMockTransport()
+		client := clienthelper.NewMockedClient(transport)
+
+		var body []byte
+		var captured *http.Request
+		transport.RegisterResponder("POST",
+			"=~^http://"+project+"\\."+clienthelper.MockEndpoint+"/logstores/"+logstore+"/shards/route",
+			func(req *http.Request) (*http.Response, error) {
+				captured = req
+				body, _ = io.ReadAll(req.Body)
+				return &http.Response{
+					Status:     "200 OK",
+					StatusCode: 200,
+					Body:       http.NoBody,
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			},
+		)
+
+		input := append([]byte(nil), raw...)
+		req := &sls.PostLogStoreLogsRequest{
+			RawLogGroup:  input,
+			HashKey:      proto.String("00000000000000000000000000000000"),
+			CompressType: compressType,
+		}
+		require.NoError(t, client.PostLogStoreLogsV2(project, logstore, req))
+		require.NotNil(t, captured)
+		// the caller may reuse RawLogGroup once the call returns
+		for i := range input {
+			input[i] = 0
+		}
+		reader, err := captured.GetBody()
+		require.NoError(t, err)
+		sent, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, body, sent)
+		require.Equal(t, strconv.Itoa(len(raw)), captured.Header.Get("x-log-bodyrawsize"))
+
+		switch compressType {
+		case sls.Compress_LZ4:
+			decoded := make([]byte, len(raw))
+			n, err := lz4.UncompressBlock(body, decoded)
+			require.NoError(t, err)
+			body = decoded[:n]
+		case sls.Compress_ZSTD:
+			decoder, err := zstd.NewReader(nil)
+			require.NoError(t, err)
+			body, err = decoder.DecodeAll(body, nil)
+			require.NoError(t, err)
+		}
+		require.Equal(t, raw, body)
+	}
+}
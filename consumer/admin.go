@@ -0,0 +1,168 @@
+package consumerLibrary
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+// ShardCheckpoint is the checkpoint of a shard in a consumer group
+type ShardCheckpoint struct {
+	ShardId    int
+	Status     string    // readwrite or readonly
+	CheckPoint string    // empty if no checkpoint is saved
+	Consumer   string    // the consumer which saved the checkpoint last time
+	UpdateTime time.Time // zero if no checkpoint is saved
+}
+
+// ConsumerGroupDescription describes a consumer group
+type ConsumerGroupDescription struct {
+	ConsumerGroup sls.ConsumerGroup
+	Shards        []ShardCheckpoint // sorted by shard id
+	// the shards of each consumer, by the consumers which saved the checkpoints. A stopped consumer is listed
+	// until its shards are taken over: consumers can't be removed from a consumer group, they expire once
+	// their heartbeats time out, and the server reassigns their shards to the other consumers.
+	Consumers map[string][]int
+}
+
+// CheckpointUpdate is a checkpoint written, or to be written in dry-run mode
+type CheckpointUpdate struct {
+	ShardId    int
+	CheckPoint string
+}
+
+// ConsumerGroupAdmin operates the consumer group of a logstore, eg. resetting the checkpoints.
+// Stop the consumers before changing the checkpoints, otherwise they may be overwritten by the consumers.
+type ConsumerGroupAdmin struct {
+	client        sls.ClientInterface
+	project       string
+	logstore      string
+	consumerGroup string
+
+	// DryRun prints the checkpoints to be written to Output instead of writing them
+	DryRun bool
+	// Output is where the checkpoints are printed in dry-run mode, default is os.Stdout
+	Output io.Writer
+}
+
+func NewConsumerGroupAdmin(client sls.ClientInterface, project, logstore, consumerGroup string) *ConsumerGroupAdmin {
+	return &ConsumerGroupAdmin{
+		client:        client,
+		project:       project,
+		logstore:      logstore,
+		consumerGroup: consumerGroup,
+		Output:        os.Stdout,
+	}
+}
+
+// Describe returns the consumer group with the checkpoint of each shard of the logstore
+func (admin *ConsumerGroupAdmin) Describe() (*ConsumerGroupDescription, error) {
+	groups, err := admin.client.ListConsumerGroup(admin.project, admin.logstore)
+	if err != nil {
+		return nil, err
+	}
+	description := &ConsumerGroupDescription{Consumers: make(map[string][]int)}
+	found := false
+	for _, group := range groups {
+		if group.ConsumerGroupName == admin.consumerGroup {
+			description.ConsumerGroup = *group
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("consumer group %s does not exist in logstore %s", admin.consumerGroup, admin.logstore)
+	}
+
+	shards, err := admin.client.ListShards(admin.project, admin.logstore)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints, err := admin.client.GetCheckpoint(admin.project, admin.logstore, admin.consumerGroup)
+	if err != nil {
+		return nil, err
+	}
+	checkpointOfShard := make(map[int]*sls.ConsumerGroupCheckPoint)
+	for _, checkpoint := range checkpoints {
+		checkpointOfShard[checkpoint.ShardID] = checkpoint
+	}
+	for _, shard := range shards {
+		shardCheckpoint := ShardCheckpoint{ShardId: shard.ShardID, Status: shard.Status}
+		if checkpoint, ok := checkpointOfShard[shard.ShardID]; ok {
+			shardCheckpoint.CheckPoint = checkpoint.CheckPoint
+			shardCheckpoint.Consumer = checkpoint.Consumer
+			if checkpoint.UpdateTime > 0 {
+				// the update time is in microseconds
+				shardCheckpoint.UpdateTime = time.UnixMicro(checkpoint.UpdateTime)
+			}
+		}
+		description.Shards = append(description.Shards, shardCheckpoint)
+	}
+	sort.Slice(description.Shards, func(i, j int) bool { return description.Shards[i].ShardId < description.Shards[j].ShardId })
+	for _, shard := range description.Shards {
+		if shard.Consumer != "" {
+			description.Consumers[shard.Consumer] = append(description.Consumers[shard.Consumer], shard.ShardId)
+		}
+	}
+	return description, nil
+}
+
+// ResetCheckpoints resets the checkpoints of the shards to the cursors got from "from",
+// which is "begin", "end" or a unix timestamp in seconds, the same as the parameter of GetCursor.
+// All the shards of the logstore are reset if no shard id is specified.
+func (admin *ConsumerGroupAdmin) ResetCheckpoints(from string, shardIds ...int) ([]CheckpointUpdate, error) {
+	if len(shardIds) == 0 {
+		shards, err := admin.client.ListShards(admin.project, admin.logstore)
+		if err != nil {
+			return nil, err
+		}
+		for _, shard := range shards {
+			shardIds = append(shardIds, shard.ShardID)
+		}
+		sort.Ints(shardIds)
+	}
+	var updates []CheckpointUpdate
+	for _, shardId := range shardIds {
+		cursor, err := admin.client.GetCursor(admin.project, admin.logstore, shardId, from)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cursor of shard %d from %s: %w", shardId, from, err)
+		}
+		updates = append(updates, CheckpointUpdate{ShardId: shardId, CheckPoint: cursor})
+	}
+	return updates, admin.writeCheckpoints(admin.consumerGroup, updates)
+}
+
+// CopyCheckpointsTo copies the checkpoints of the consumer group to another consumer group of the logstore,
+// which must be created before.
+func (admin *ConsumerGroupAdmin) CopyCheckpointsTo(consumerGroup string) ([]CheckpointUpdate, error) {
+	checkpoints, err := admin.client.GetCheckpoint(admin.project, admin.logstore, admin.consumerGroup)
+	if err != nil {
+		return nil, err
+	}
+	var updates []CheckpointUpdate
+	for _, checkpoint := range checkpoints {
+		if checkpoint.CheckPoint != "" {
+			updates = append(updates, CheckpointUpdate{ShardId: checkpoint.ShardID, CheckPoint: checkpoint.CheckPoint})
+		}
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].ShardId < updates[j].ShardId })
+	return updates, admin.writeCheckpoints(consumerGroup, updates)
+}
+
+// writeCheckpoints saves the checkpoints without consumer, or prints them in dry-run mode
+func (admin *ConsumerGroupAdmin) writeCheckpoints(consumerGroup string, updates []CheckpointUpdate) error {
+	for _, update := range updates {
+		if admin.DryRun {
+			fmt.Fprintf(admin.Output, "[dry-run] consumer group: %s, shard: %d, checkpoint: %s\n", consumerGroup, update.ShardId, update.CheckPoint)
+			continue
+		}
+		err := admin.client.UpdateCheckpoint(admin.project, admin.logstore, consumerGroup, "", update.ShardId, update.CheckPoint, true)
+		if err != nil {
+			return fmt.Errorf("failed to update checkpoint of shard %d: %w", update.ShardId, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package consumerLibrary
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-log-go-sdk/internal/testutil"
+	"github.com/aliyun/aliyun-log-go-sdk/internal/testutil/clienthelper"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestConsumerGroupAdmin(t *testing.T) (*ConsumerGroupAdmin, *fakeConsumerGroup, *fakeConsumerGroup) {
+	transport := httpmock.NewMockTransport()
+	logstore := registerFakeLogstore(transport)
+	logstore.setShard(0, "readonly", 5)
+	logstore.setShard(1, "readwrite", 3)
+	logstore.setShard(2, "readwrite", 3)
+	testutil.RegisterJSON(t, transport, "GET", `=~/logstores/l/consumergroups$`, 200, []map[string]interface{}{
+		{"name": "cg", "timeout": 60, "order": false},
+		{"name": "cg2", "timeout": 60, "order": false},
+	})
+	group := registerFakeConsumerGroup(transport, "cg")
+	group2 := registerFakeConsumerGroup(transport, "cg2")
+	return NewConsumerGroupAdmin(clienthelper.NewMockedClient(transport), "p", "l", "cg"), group, group2
+}
+
+func TestConsumerGroupAdminDescribe(t *testing.T) {
+	admin, group, _ := newTestConsumerGroupAdmin(t)
+	updateTime := time.UnixMicro(time.Now().UnixMicro())
+	group.setConsumerCheckpoint(0, "0-5", "c1", updateTime)
+	group.setConsumerCheckpoint(2, "2-1", "c1", updateTime)
+
+	description, err := admin.Describe()
+	require.NoError(t, err)
+	assert.Equal(t, "cg", description.ConsumerGroup.ConsumerGroupName)
+	assert.Equal(t, 60, description.ConsumerGroup.Timeout)
+	assert.Equal(t, []ShardCheckpoint{
+		{ShardId: 0, Status: "readonly", CheckPoint: "0-5", Consumer: "c1", UpdateTime: updateTime},
+		{ShardId: 1, Status: "readwrite"},
+		{ShardId: 2, Status: "readwrite", CheckPoint: "2-1", Consumer: "c1", UpdateTime: updateTime},
+	}, description.Shards)
+	assert.Equal(t, map[string][]int{"c1": {0, 2}}, description.Consumers)
+
+	_, err = NewConsumerGroupAdmin(admin.client, "p", "l", "cg3").Describe()
+	assert.Error(t, err)
+}
+
+func TestConsumerGroupAdminResetCheckpoints(t *testing.T) {
+	admin, group, _ := newTestConsumerGroupAdmin(t)
+	updates, err := admin.ResetCheckpoints("end")
+	require.NoError(t, err)
+	assert.Equal(t, []CheckpointUpdate{{0, "0-5"}, {1, "1-3"}, {2, "2-3"}}, updates)
+	assert.Equal(t, "1-3", group.getCheckpoint(1))
+	assert.Equal(t, "", group.get(1).Consumer)
+
+	updates, err = admin.ResetCheckpoints("1", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []CheckpointUpdate{{2, "2-1"}}, updates)
+	assert.Equal(t, "2-1", group.getCheckpoint(2))
+	assert.Equal(t, "1-3", group.getCheckpoint(1))
+}
+
+func TestConsumerGroupAdminDryRun(t *testing.T) {
+	admin, group, group2 := newTestConsumerGroupAdmin(t)
+	output := &bytes.Buffer{}
+	admin.DryRun = true
+	admin.Output = output
+	group.setCheckpoint(1, "1-2")
+
+	updates, err := admin.ResetCheckpoints("begin", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []CheckpointUpdate{{0, "0-0"}}, updates)
+	_, err = admin.CopyCheckpointsTo("cg2")
+	require.NoError(t, err)
+	assert.Equal(t, "[dry-run] consumer group: cg, shard: 0, checkpoint: 0-0\n"+
+		"[dry-run] consumer group: cg2, shard: 1, checkpoint: 1-2\n", output.String())
+	assert.Nil(t, group.get(0))
+	assert.Nil(t, group2.get(1))
+}
+
+func TestConsumerGroupAdminCopyCheckpoints(t *testing.T) {
+	admin, group, group2 := newTestConsumerGroupAdmin(t)
+	group.setCheckpoint(0, "0-4")
+	group.setCheckpoint(2, "2-2")
+
+	updates, err := admin.CopyCheckpointsTo("cg2")
+	require.NoError(t, err)
+	assert.Equal(t, []CheckpointUpdate{{0, "0-4"}, {2, "2-2"}}, updates)
+	assert.Equal(t, "0-4", group2.getCheckpoint(0))
+	assert.Equal(t, "2-2", group2.getCheckpoint(2))
+	assert.Nil(t, group2.get(1))
+}
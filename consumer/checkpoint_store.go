@@ -0,0 +1,105 @@
+package consumerLibrary
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CheckpointStore is where the checkpoints of the shards are stored, it is set by LogHubConfig.CheckpointStore.
+// The shards are always assigned by the heartbeats of the consumer group, no matter where the checkpoints are stored.
+type CheckpointStore interface {
+	// GetCheckpoint returns the checkpoint of the shard, or empty if no checkpoint is saved
+	GetCheckpoint(shardId int) (string, error)
+	// SaveCheckpoint saves the checkpoint of the shard
+	SaveCheckpoint(shardId int, checkpoint string) error
+}
+
+// serverCheckpointStore stores the checkpoints in the consumer group of SLS, it is the default CheckpointStore
+type serverCheckpointStore struct {
+	client *ConsumerClient
+}
+
+func (s *serverCheckpointStore) GetCheckpoint(shardId int) (string, error) {
+	return s.client.getCheckPoint(shardId)
+}
+
+func (s *serverCheckpointStore) SaveCheckpoint(shardId int, checkpoint string) error {
+	return s.client.updateCheckPoint(shardId, checkpoint, true)
+}
+
+// FileCheckpointStore stores the checkpoint of each shard in a file of the directory,
+// the file is replaced atomically when the checkpoint is saved.
+// Don't share the directory between consumer groups or logstores.
+// The checkpoints are not fenced: a consumer which lost a shard may still overwrite the checkpoint saved by the new owner,
+// and the consumers on other hosts don't see the files at all, so use it only if the consumer group has a single consumer.
+type FileCheckpointStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// NewFileCheckpointStore creates the directory if it doesn't exist
+func NewFileCheckpointStore(dir string) (*FileCheckpointStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileCheckpointStore{dir: dir}, nil
+}
+
+func (s *FileCheckpointStore) path(shardId int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("shard-%d.checkpoint", shardId))
+}
+
+func (s *FileCheckpointStore) GetCheckpoint(shardId int) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	data, err := os.ReadFile(s.path(shardId))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *FileCheckpointStore) SaveCheckpoint(shardId int, checkpoint string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	file, err := os.CreateTemp(s.dir, fmt.Sprintf("shard-%d.*.tmp", shardId))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err = file.WriteString(checkpoint); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.path(shardId))
+}
+
+// TransactionalCheckpointStore is used when the processor commits its output and the checkpoint atomically,
+// eg. in the same transaction of a database, so that each log is outputted exactly once.
+// The processor commits CheckPointTracker.GetNextCursor() with the output of the batch, then calls SaveCheckPoint as usual,
+// which saves nothing to the store since the checkpoint is already committed.
+// It can't be used with LogHubConfig.ShardProcessConcurrency greater than 1, the worker panics when it is created then.
+type TransactionalCheckpointStore struct {
+	// LoadCheckpoint returns the checkpoint committed by the processor, or empty if nothing is committed,
+	// it is called when the shard is assigned to the consumer
+	LoadCheckpoint func(shardId int) (string, error)
+}
+
+func (s *TransactionalCheckpointStore) GetCheckpoint(shardId int) (string, error) {
+	return s.LoadCheckpoint(shardId)
+}
+
+func (s *TransactionalCheckpointStore) SaveCheckpoint(shardId int, checkpoint string) error {
+	return nil
+}
@@ -0,0 +1,87 @@
+package consumerLibrary
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aliyun/aliyun-log-go-sdk/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryCheckpointStore map[int]string
+
+func (s memoryCheckpointStore) GetCheckpoint(shardId int) (string, error) {
+	return s[shardId], nil
+}
+
+func (s memoryCheckpointStore) SaveCheckpoint(shardId int, checkpoint string) error {
+	s[shardId] = checkpoint
+	return nil
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "checkpoints")
+	store, err := NewFileCheckpointStore(dir)
+	require.NoError(t, err)
+
+	checkpoint, err := store.GetCheckpoint(0)
+	require.NoError(t, err)
+	assert.Equal(t, "", checkpoint)
+
+	require.NoError(t, store.SaveCheckpoint(0, "c1"))
+	require.NoError(t, store.SaveCheckpoint(0, "c2"))
+	require.NoError(t, store.SaveCheckpoint(1, "d1"))
+
+	store, err = NewFileCheckpointStore(dir)
+	require.NoError(t, err)
+	checkpoint, err = store.GetCheckpoint(0)
+	require.NoError(t, err)
+	assert.Equal(t, "c2", checkpoint)
+	checkpoint, err = store.GetCheckpoint(1)
+	require.NoError(t, err)
+	assert.Equal(t, "d1", checkpoint)
+
+	// no temporary files are left
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestTransactionalCheckpointStore(t *testing.T) {
+	committed := map[int]string{2: "c2"}
+	store := &TransactionalCheckpointStore{LoadCheckpoint: func(shardId int) (string, error) {
+		return committed[shardId], nil
+	}}
+	require.NoError(t, store.SaveCheckpoint(2, "c3"))
+	checkpoint, err := store.GetCheckpoint(2)
+	require.NoError(t, err)
+	assert.Equal(t, "c2", checkpoint)
+
+	option := LogHubConfig{CheckpointStore: store, ShardProcessConcurrency: 2}
+	assert.Panics(t, func() { InitConsumerWorkerWithContextProcessor(option, ContextProcessFunc(nil)) })
+}
+
+func TestCustomCheckpointStore(t *testing.T) {
+	transport := testutil.NewMockTransport()
+	client := newMockedConsumerClient(transport)
+	store := memoryCheckpointStore{0: "c1"}
+	client.option.CheckpointStore = store
+	worker := newTestShardConsumerWorker(context.Background(), client, ContextProcessFunc(nil))
+
+	cursor, endCursor, err := worker.consumerInitializeTask()
+	require.NoError(t, err)
+	assert.Equal(t, "c1", cursor)
+	assert.Equal(t, "", endCursor)
+
+	tracker := worker.consumerCheckPointTracker
+	assert.Equal(t, "c1", tracker.GetCheckPoint())
+	tracker.setNextCursor("c2")
+	require.NoError(t, tracker.SaveCheckPoint(true))
+	assert.Equal(t, "c2", store[0])
+	assert.Equal(t, "c2", tracker.GetCheckPoint())
+	// the consumer group is only used to assign shards
+	assert.Equal(t, 0, transport.GetTotalCallCount())
+}
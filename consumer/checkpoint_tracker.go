@@ -1,14 +1,21 @@
 package consumerLibrary
 
 import (
+	"errors"
 	"strings"
+	"sync"
 	"time"
 
 	sls "github.com/aliyun/aliyun-log-go-sdk"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"go.uber.org/atomic"
 )
 
+// ErrShardRevoked is returned when a checkpoint is saved after the shard is revoked from the consumer,
+// the shard may be consumed by another consumer, so the checkpoint can't be saved anymore.
+var ErrShardRevoked = errors.New("shard has been revoked from the consumer")
+
 // CheckPointTracker
 // Generally, you just need SaveCheckPoint, if you use more funcs, make sure you understand these
 type CheckPointTracker interface {
@@ -24,8 +31,13 @@ type CheckPointTracker interface {
 	GetShardId() int
 }
 
+// DefaultCheckPointTracker is thread safe, the cursors are tracked by the shard worker,
+// and the checkpoints may be saved by the batches processed concurrently.
 type DefaultCheckPointTracker struct {
+	mutex             sync.Mutex // protects the cursors and checkpoints
+	flushMutex        sync.Mutex // serializes the updates of the checkpoint on server
 	client            *ConsumerClient
+	store             CheckpointStore
 	heartBeat         *ConsumerHeartBeat
 	nextCursor        string // cursor for already pulled data
 	currentCursor     string // cursor for data processed, but may not be saved to server
@@ -33,24 +45,35 @@ type DefaultCheckPointTracker struct {
 	savedCheckPoint   string // already saved
 	shardId           int
 	logger            log.Logger
+	revoked           *atomic.Bool
+	onRevoked         func() // called once when the shard is revoked
 }
 
 func initConsumerCheckpointTracker(shardId int, consumerClient *ConsumerClient, consumerHeatBeat *ConsumerHeartBeat, logger log.Logger) *DefaultCheckPointTracker {
 	checkpointTracker := &DefaultCheckPointTracker{
 		client:    consumerClient,
+		store:     consumerClient.checkpointStore(),
 		heartBeat: consumerHeatBeat,
 		shardId:   shardId,
 		logger:    logger,
+		revoked:   atomic.NewBool(false),
 	}
 	return checkpointTracker
 }
 
 func (tracker *DefaultCheckPointTracker) initCheckPoint(cursor string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
 	tracker.savedCheckPoint = cursor
 }
 
 func (tracker *DefaultCheckPointTracker) SaveCheckPoint(force bool) error {
+	if tracker.isRevoked() {
+		return ErrShardRevoked
+	}
+	tracker.mutex.Lock()
 	tracker.pendingCheckPoint = tracker.nextCursor
+	tracker.mutex.Unlock()
 	if force {
 		return tracker.flushCheckPoint()
 	}
@@ -59,35 +82,73 @@ func (tracker *DefaultCheckPointTracker) SaveCheckPoint(force bool) error {
 }
 
 func (tracker *DefaultCheckPointTracker) GetCheckPoint() string {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
 	return tracker.savedCheckPoint
 }
 
 func (tracker *DefaultCheckPointTracker) GetCurrentCursor() string {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
 	return tracker.currentCursor
 }
 
 func (tracker *DefaultCheckPointTracker) setCurrentCursor(cursor string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
 	tracker.currentCursor = cursor
 }
 
 func (tracker *DefaultCheckPointTracker) GetNextCursor() string {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
 	return tracker.nextCursor
 }
 
 func (tracker *DefaultCheckPointTracker) setNextCursor(cursor string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.nextCursor = cursor
+}
+
+// advanceWatermark sets both the next cursor and the pending checkpoint to the cursor,
+// below which all the batches processed concurrently are completed
+func (tracker *DefaultCheckPointTracker) advanceWatermark(cursor string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
 	tracker.nextCursor = cursor
+	tracker.pendingCheckPoint = cursor
 }
 
 func (tracker *DefaultCheckPointTracker) GetShardId() int {
 	return tracker.shardId
 }
 
+// revoke refuses to save checkpoints afterwards, it is called when the shard is no longer held by the consumer
+func (tracker *DefaultCheckPointTracker) revoke() {
+	if tracker.revoked.CompareAndSwap(false, true) && tracker.onRevoked != nil {
+		tracker.onRevoked()
+	}
+}
+
+func (tracker *DefaultCheckPointTracker) isRevoked() bool {
+	return tracker.revoked.Load()
+}
+
 func (tracker *DefaultCheckPointTracker) flushCheckPoint() error {
-	if tracker.pendingCheckPoint == "" || tracker.pendingCheckPoint == tracker.savedCheckPoint {
+	if tracker.isRevoked() {
+		return ErrShardRevoked
+	}
+	tracker.flushMutex.Lock()
+	defer tracker.flushMutex.Unlock()
+	tracker.mutex.Lock()
+	pendingCheckPoint, savedCheckPoint := tracker.pendingCheckPoint, tracker.savedCheckPoint
+	tracker.mutex.Unlock()
+	if pendingCheckPoint == "" || pendingCheckPoint == savedCheckPoint {
 		return nil
 	}
 	for i := 0; ; i++ {
-		err := tracker.client.updateCheckPoint(tracker.shardId, tracker.pendingCheckPoint, true)
+		err := tracker.store.SaveCheckpoint(tracker.shardId, pendingCheckPoint)
 		if err == nil {
 			break
 		}
@@ -96,11 +157,13 @@ func (tracker *DefaultCheckPointTracker) flushCheckPoint() error {
 			if strings.EqualFold(slsErr.Code, "ConsumerNotExsit") || strings.EqualFold(slsErr.Code, "ConsumerNotMatch") {
 				tracker.heartBeat.removeHeartShard(tracker.shardId)
 				level.Warn(tracker.logger).Log("msg", "consumer has been removed or shard has been reassigned", "shard", tracker.shardId, "err", slsErr)
-				break
+				tracker.revoke()
+				return ErrShardRevoked
 			} else if strings.EqualFold(slsErr.Code, "ShardNotExsit") {
 				tracker.heartBeat.removeHeartShard(tracker.shardId)
 				level.Warn(tracker.logger).Log("msg", "shard does not exist", "shard", tracker.shardId)
-				break
+				tracker.revoke()
+				return ErrShardRevoked
 			}
 		}
 		if i >= 2 {
@@ -108,13 +171,15 @@ func (tracker *DefaultCheckPointTracker) flushCheckPoint() error {
 				"msg", "failed to save checkpoint",
 				"consumer", tracker.client.option.ConsumerName,
 				"shard", tracker.shardId,
-				"checkpoint", tracker.pendingCheckPoint,
+				"checkpoint", pendingCheckPoint,
 			)
 			return err
 		}
 		time.Sleep(100 * time.Millisecond)
 	}
 
-	tracker.savedCheckPoint = tracker.pendingCheckPoint
+	tracker.mutex.Lock()
+	tracker.savedCheckPoint = pendingCheckPoint
+	tracker.mutex.Unlock()
 	return nil
 }
@@ -32,6 +32,7 @@ type LogHubConfig struct {
 	//:param MaxFetchLogGroupCount: default 1000, fetch size in each request, normally use default. maximum is 1000, could be lower. the lower the size the memory efficiency might be better.
 	//:param CursorStartTime: Will be used when cursor_position is SPECIAL_TIMER_CURSOR, it's log receiving time. The unit of parameter is seconds.
 	//:param CursorEndTime: Consumer will keep consuming data until CursorEndTime reached. If set to zero, the consumer keeps consuming and never stops. It's log receiving time. The unit of parameter is seconds.
+	//	  The shards are still held after CursorEndTime reached, use ConsumerWorker.Run or ConsumerWorker.Done to wait until all the shards are consumed.
 	//:param InOrder:
 	// 	default False, during consuption, when shard is splitted,
 	// 	if need to consume the newly splitted shard after its parent shard (read-only) is finished consumption or not.
@@ -54,41 +55,61 @@ type LogHubConfig struct {
 	//:param AuthVersion: signature algorithm version, default is sls.AuthV1
 	//:param Region: region of sls endpoint, eg. cn-hangzhou, region must be set if AuthVersion is sls.AuthV4
 	//:param DisableRuntimeMetrics: disable runtime metrics, runtime metrics prints to local log.
+	//:param ReportLagInRuntimeMetrics: include lagSeconds and bytesBehind of each shard in the runtime metrics, default is false.
+	//	  Computing the lag takes up to 3 requests per shard each minute, sent by the goroutine assigning the shards.
 	//::param MaxIoWorkers: max io workers, default is 50. Smaller io workers will reduce memory usage, but may reduce throughput.
-	Endpoint                  string
-	AccessKeyID               string
-	AccessKeySecret           string
-	CredentialsProvider       sls.CredentialsProvider
-	Project                   string
-	Logstore                  string
-	Query                     string
-	Processor                 string
-	ConsumerGroupName         string
-	ConsumerName              string
-	CursorPosition            string
-	HeartbeatIntervalInSecond int
-	HeartbeatTimeoutInSecond  int
-	DataFetchIntervalInMs     int64
-	MaxFetchLogGroupCount     int
-	CursorStartTime           int64 // Unix time stamp; Units are seconds.
-	CursorEndTime             int64 // Unix time stamp; Units are seconds. If is zero, no end time.
-	InOrder                   bool
-	Logger                    log.Logger
-	AllowLogLevel             string
-	LogFileName               string
-	IsJsonType                bool
-	LogMaxSize                int
-	LogMaxBackups             int
-	LogCompass                bool
-	CompressType              int
-	HTTPClient                *http.Client
-	SecurityToken             string
-	AutoCommitDisabled        bool
-	AutoCommitIntervalInMS    int64
-	AuthVersion               sls.AuthVersionType
-	Region                    string
-	DisableRuntimeMetrics     bool
-	MaxIoWorkers              int
+	//:param ShardProcessConcurrency: max batches fetched from a shard processed concurrently, default is 1, means the batches are processed one by one.
+	//	  If it is greater than 1, the checkpoint only advances to the highest cursor below which all the batches are completed (SaveCheckPoint is called),
+	//	  and the fetching is blocked once 16 * ShardProcessConcurrency batches are dispatched after it, so SaveCheckPoint must be called for every batch.
+	//:param CheckpointStore: where the checkpoints are stored, default is nil, means the checkpoints are stored in the consumer group.
+	//	  The shards are still assigned by the consumer group, see FileCheckpointStore and TransactionalCheckpointStore.
+	//	  TransactionalCheckpointStore can't be used with ShardProcessConcurrency greater than 1.
+	//:param PrefetchDepth: max batches fetched ahead of the processing of a shard, default is 0, means the next batch is fetched after the current one is processed.
+	//	  If the processor returns a rollback checkpoint, the batches fetched ahead are dropped and fetched again from the checkpoint.
+	//:param PrefetchMemoryLimitInBytes: the soft limit of the raw size of the batches prefetched but not processed by all the shards of a worker, default is 256MB.
+	//	  Only used when PrefetchDepth is greater than 0, no more batches are prefetched once the limit is reached.
+	//:param FailurePolicy: how the batches failed to be processed are retried, and whether they are skipped, sent to a DeadLetterSink or stop the worker after the retries.
+	//	  Default is retrying every 50ms until the batch is processed, which blocks the shard if the batch always fails.
+	Endpoint                   string
+	AccessKeyID                string
+	AccessKeySecret            string
+	CredentialsProvider        sls.CredentialsProvider
+	Project                    string
+	Logstore                   string
+	Query                      string
+	Processor                  string
+	ConsumerGroupName          string
+	ConsumerName               string
+	CursorPosition             string
+	HeartbeatIntervalInSecond  int
+	HeartbeatTimeoutInSecond   int
+	DataFetchIntervalInMs      int64
+	MaxFetchLogGroupCount      int
+	CursorStartTime            int64 // Unix time stamp; Units are seconds.
+	CursorEndTime              int64 // Unix time stamp; Units are seconds. If is zero, no end time.
+	InOrder                    bool
+	Logger                     log.Logger
+	AllowLogLevel              string
+	LogFileName                string
+	IsJsonType                 bool
+	LogMaxSize                 int
+	LogMaxBackups              int
+	LogCompass                 bool
+	CompressType               int
+	HTTPClient                 *http.Client
+	SecurityToken              string
+	AutoCommitDisabled         bool
+	AutoCommitIntervalInMS     int64
+	AuthVersion                sls.AuthVersionType
+	Region                     string
+	DisableRuntimeMetrics      bool
+	ReportLagInRuntimeMetrics  bool
+	MaxIoWorkers               int
+	ShardProcessConcurrency    int
+	CheckpointStore            CheckpointStore
+	PrefetchDepth              int
+	PrefetchMemoryLimitInBytes int64
+	FailurePolicy              FailurePolicy
 }
 
 const (
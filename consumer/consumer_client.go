@@ -134,6 +134,18 @@ func (consumer *ConsumerClient) getCheckPoint(shardId int) (checkpoint string, e
 	return "", err
 }
 
+// checkpointStore returns the CheckpointStore configured, or the consumer group if not set
+func (consumer *ConsumerClient) checkpointStore() CheckpointStore {
+	if consumer.option.CheckpointStore != nil {
+		return consumer.option.CheckpointStore
+	}
+	return &serverCheckpointStore{client: consumer}
+}
+
+func (consumer *ConsumerClient) listShards() ([]*sls.Shard, error) {
+	return consumer.client.ListShards(consumer.option.Project, consumer.option.Logstore)
+}
+
 func (consumer *ConsumerClient) getCursor(shardId int, from string) (string, error) {
 	cursor, err := consumer.client.GetCursor(consumer.option.Project, consumer.option.Logstore, shardId, from)
 	return cursor, err
@@ -0,0 +1,79 @@
+// Package deadletter provides the dead letter sinks of the consumer library which depend on the producer,
+// so that the consumer library itself doesn't import the producer.
+package deadletter
+
+import (
+	"fmt"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	consumerLibrary "github.com/aliyun/aliyun-log-go-sdk/consumer"
+	"github.com/aliyun/aliyun-log-go-sdk/producer"
+	"github.com/gogo/protobuf/proto"
+)
+
+// Sender is the part of the producer used by LogstoreSink, it is implemented by *producer.Producer
+type Sender interface {
+	SendLogGroupWithCallBack(project, logstore, shardHash string, logGroup *sls.LogGroup, callback producer.CallBack) error
+}
+
+// LogstoreSink sends the log groups of the dead letters to a logstore by the producer,
+// the topic, source and tags of the log groups are kept, and the shard and the error are added as tags.
+// Send waits until the producer sends the log groups or gives up on them, so the checkpoint isn't advanced
+// over the dead letters lost by the producer. The producer is started and closed by the caller,
+// it should be closed after the consumer worker is stopped, otherwise Send fails until the shard is revoked.
+type LogstoreSink struct {
+	sender   Sender
+	project  string
+	logstore string
+}
+
+var _ consumerLibrary.DeadLetterSink = (*LogstoreSink)(nil)
+
+func NewLogstoreSink(sender Sender, project, logstore string) *LogstoreSink {
+	return &LogstoreSink{sender: sender, project: project, logstore: logstore}
+}
+
+func (s *LogstoreSink) Send(letter *consumerLibrary.DeadLetter) error {
+	tags := []*sls.LogTag{{Key: proto.String("__dead_letter_shard__"), Value: proto.String(fmt.Sprint(letter.ShardId))}}
+	if letter.Err != nil {
+		tags = append(tags, &sls.LogTag{Key: proto.String("__dead_letter_error__"), Value: proto.String(letter.Err.Error())})
+	}
+	// the log groups accepted by the producer before an error are still waited for
+	var results []chan *producer.Result
+	var err error
+	for _, logGroup := range letter.LogGroupList.LogGroups {
+		if len(logGroup.Logs) == 0 {
+			continue
+		}
+		// the log group may be retained by the processor, so it isn't modified
+		deadLetter := &sls.LogGroup{
+			Logs:    logGroup.Logs,
+			Topic:   logGroup.Topic,
+			Source:  logGroup.Source,
+			LogTags: append(append([]*sls.LogTag{}, logGroup.LogTags...), tags...),
+		}
+		callback := make(resultCallback, 1)
+		if err = s.sender.SendLogGroupWithCallBack(s.project, s.logstore, "", deadLetter, callback); err != nil {
+			break
+		}
+		results = append(results, callback)
+	}
+	for _, result := range results {
+		if r := <-result; !r.IsSuccessful() && err == nil {
+			err = fmt.Errorf("failed to send the dead letter to %s/%s, errorCode: %s, errorMessage: %s",
+				s.project, s.logstore, r.GetErrorCode(), r.GetErrorMessage())
+		}
+	}
+	return err
+}
+
+// resultCallback receives the result of a log group sent by the producer
+type resultCallback chan *producer.Result
+
+func (c resultCallback) Success(result *producer.Result) {
+	c <- result
+}
+
+func (c resultCallback) Fail(result *producer.Result) {
+	c <- result
+}
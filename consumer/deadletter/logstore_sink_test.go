@@ -0,0 +1,69 @@
+package deadletter
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	consumerLibrary "github.com/aliyun/aliyun-log-go-sdk/consumer"
+	"github.com/aliyun/aliyun-log-go-sdk/internal/testutil"
+	"github.com/aliyun/aliyun-log-go-sdk/producer"
+	"github.com/go-kit/kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestProducer(t *testing.T, transport *httpmock.MockTransport) *producer.Producer {
+	config := producer.GetDefaultProducerConfig()
+	config.Endpoint = "cn-mock.example.com"
+	config.AccessKeyID, config.AccessKeySecret = "ak", "sk"
+	config.HTTPClient = &http.Client{Transport: transport}
+	config.Retries = 0
+	config.DisableRuntimeMetrics = true
+	config.Logger = log.NewNopLogger()
+	p, err := producer.NewProducer(config)
+	require.NoError(t, err)
+	p.Start()
+	t.Cleanup(p.SafeClose)
+	return p
+}
+
+func newTestLetter() *consumerLibrary.DeadLetter {
+	log := &sls.Log{Time: proto.Uint32(1), Contents: []*sls.LogContent{{Key: proto.String("k"), Value: proto.String("v")}}}
+	return &consumerLibrary.DeadLetter{
+		ShardId: 1,
+		Err:     errors.New("poison batch"),
+		LogGroupList: &sls.LogGroupList{LogGroups: []*sls.LogGroup{
+			{Logs: []*sls.Log{log}},
+			{},
+			{Logs: []*sls.Log{log}, LogTags: []*sls.LogTag{{Key: proto.String("tenant"), Value: proto.String("a")}}},
+		}},
+	}
+}
+
+func TestLogstoreSinkSend(t *testing.T) {
+	transport := testutil.NewMockTransport()
+	testutil.RegisterJSON(t, transport, "POST", `=~/logstores/dead-letter$`, 200, map[string]string{})
+	letter := newTestLetter()
+	sink := NewLogstoreSink(newTestProducer(t, transport), "p", "dead-letter")
+
+	require.NoError(t, sink.Send(letter))
+	// Send returns after the log groups are sent, the empty one is skipped
+	assert.Equal(t, 2, transport.GetTotalCallCount())
+	// the log groups of the letter are not modified
+	assert.Len(t, letter.LogGroupList.LogGroups[2].LogTags, 1)
+}
+
+func TestLogstoreSinkSendFailed(t *testing.T) {
+	transport := testutil.NewMockTransport()
+	testutil.RegisterError(t, transport, "POST", `=~/logstores/dead-letter$`, 404, "LogStoreNotExist", "logstore dead-letter does not exist")
+	sink := NewLogstoreSink(newTestProducer(t, transport), "p", "dead-letter")
+
+	err := sink.Send(newTestLetter())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "LogStoreNotExist")
+	assert.Equal(t, 2, transport.GetTotalCallCount())
+}
@@ -0,0 +1,254 @@
+package consumerLibrary
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log/level"
+)
+
+// FailureAction is what to do with a batch the processor keeps failing to process
+type FailureAction int
+
+const (
+	// FailureActionRetry retries the batch until it succeeds, the shard is blocked until then. It is the default.
+	FailureActionRetry FailureAction = iota
+	// FailureActionSkip skips the batch and advances the checkpoint over it
+	FailureActionSkip
+	// FailureActionDeadLetter sends the batch to the DeadLetterSink, then skips it
+	FailureActionDeadLetter
+	// FailureActionStop stops the worker, the error is returned by ConsumerWorker.Err and ConsumerWorker.Run
+	FailureActionStop
+)
+
+// FailurePolicy decides how the batches failed to be processed are retried, and what to do after the retries are exhausted.
+// A rollback checkpoint returned by the processor is not a failure, the batch is fetched again from the checkpoint.
+type FailurePolicy struct {
+	// MaxRetries is the retries after the first failure before Action is taken, it is ignored by FailureActionRetry
+	MaxRetries int
+	// InitialBackoff is the sleep time before the first retry, default is 50ms
+	InitialBackoff time.Duration
+	// MaxBackoff is the max sleep time between retries, the sleep time is doubled after each retry until it is reached.
+	// Default is InitialBackoff, means the sleep time is not increased.
+	MaxBackoff time.Duration
+	Action     FailureAction
+	// DeadLetterSink is where the batches are sent by FailureActionDeadLetter, it is required by FailureActionDeadLetter
+	DeadLetterSink DeadLetterSink
+}
+
+func (policy *FailurePolicy) initialBackoff() time.Duration {
+	if policy.InitialBackoff > 0 {
+		return policy.InitialBackoff
+	}
+	return processFailedSleepTime
+}
+
+// nextBackoff returns the sleep time after the backoff
+func (policy *FailurePolicy) nextBackoff(backoff time.Duration) time.Duration {
+	if backoff *= 2; backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+	if initial := policy.initialBackoff(); backoff < initial {
+		backoff = initial
+	}
+	return backoff
+}
+
+func (policy *FailurePolicy) exhausted(retries int) bool {
+	return policy.Action != FailureActionRetry && retries >= policy.MaxRetries
+}
+
+// DeadLetter is a batch the processor failed to process
+type DeadLetter struct {
+	ShardId      int
+	Cursor       string // the cursor the batch is fetched from
+	NextCursor   string // the cursor after the batch
+	Err          error  // the error returned by the processor on the last retry
+	LogGroupList *sls.LogGroupList
+}
+
+// DeadLetterSink receives the batches skipped by FailureActionDeadLetter, it may be called by the shards concurrently.
+// If Send returns an error, it is retried until it succeeds or the shard is revoked, the checkpoint isn't advanced until then.
+type DeadLetterSink interface {
+	Send(letter *DeadLetter) error
+}
+
+// FileDeadLetterSink appends the dead letters of each shard to a file of the directory, one JSON object per line
+type FileDeadLetterSink struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// NewFileDeadLetterSink creates the directory if it doesn't exist
+func NewFileDeadLetterSink(dir string) (*FileDeadLetterSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileDeadLetterSink{dir: dir}, nil
+}
+
+type deadLetterRecord struct {
+	Time       int64           `json:"time"`
+	ShardId    int             `json:"shard"`
+	Cursor     string          `json:"cursor"`
+	NextCursor string          `json:"nextCursor"`
+	Error      string          `json:"error"`
+	LogGroups  []*sls.LogGroup `json:"logGroups"`
+}
+
+func (s *FileDeadLetterSink) Send(letter *DeadLetter) error {
+	record := deadLetterRecord{
+		Time:       time.Now().Unix(),
+		ShardId:    letter.ShardId,
+		Cursor:     letter.Cursor,
+		NextCursor: letter.NextCursor,
+		LogGroups:  letter.LogGroupList.LogGroups,
+	}
+	if letter.Err != nil {
+		record.Error = letter.Err.Error()
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	file, err := os.OpenFile(filepath.Join(s.dir, fmt.Sprintf("shard-%d.deadletter", letter.ShardId)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(append(data, '\n')); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// processWithRetry processes the batch until it succeeds, the processor returns a rollback checkpoint,
+// the worker is shutting down, or the retries of the FailurePolicy are exhausted, it returns the rollback checkpoint
+func (c *ShardConsumerWorker) processWithRetry(logGroupList *sls.LogGroupList, tracker CheckPointTracker) string {
+	policy := &c.client.option.FailurePolicy
+	backoff := policy.initialBackoff()
+	for retries := 0; ; retries++ {
+		start := time.Now()
+		rollBackCheckpoint, err := c.processInternal(logGroupList, tracker)
+		c.monitor.RecordProcess(err, start)
+
+		// the checkpoints of the batches processed concurrently are saved by the loop dispatching them
+		if c.parallel == nil {
+			c.saveCheckPointIfNeeded()
+		}
+		if err != nil {
+			level.Error(c.logger).Log("msg", "process func returns an error", "err", err, "cursor", tracker.GetCurrentCursor(), "retries", retries)
+		}
+		if rollBackCheckpoint != "" {
+			level.Warn(c.logger).Log("msg", "Rollback checkpoint by user",
+				"rollBackCheckpoint", rollBackCheckpoint)
+			return rollBackCheckpoint
+		}
+		if err == nil {
+			return ""
+		}
+		// if process failed and shutting down, just quit
+		if c.shutDownFlag.Load() {
+			level.Warn(c.logger).Log("msg", "shutting down and last process failed, just quit")
+			return ""
+		}
+		if policy.exhausted(retries) {
+			c.handleProcessFailure(policy, logGroupList, tracker, err, retries)
+			return ""
+		}
+		c.monitor.RecordRetry()
+		sleepWithContext(c.ctx, backoff)
+		backoff = policy.nextBackoff(backoff)
+	}
+}
+
+// handleProcessFailure takes the action of the policy after the retries are exhausted
+func (c *ShardConsumerWorker) handleProcessFailure(policy *FailurePolicy, logGroupList *sls.LogGroupList, tracker CheckPointTracker, err error, retries int) {
+	err = fmt.Errorf("failed to process the batch of shard %d from cursor %s after %d retries: %w", c.shardId, tracker.GetCurrentCursor(), retries, err)
+	switch policy.Action {
+	case FailureActionSkip:
+		level.Warn(c.logger).Log("msg", "skip the batch failed to be processed", "err", err)
+		c.monitor.RecordSkip()
+		c.skip(tracker)
+	case FailureActionDeadLetter:
+		if policy.DeadLetterSink == nil {
+			c.fail(fmt.Errorf("DeadLetterSink is not set for FailureActionDeadLetter, %w", err))
+			return
+		}
+		letter := &DeadLetter{
+			ShardId:      c.shardId,
+			Cursor:       tracker.GetCurrentCursor(),
+			NextCursor:   tracker.GetNextCursor(),
+			Err:          err,
+			LogGroupList: logGroupList,
+		}
+		for {
+			sendErr := policy.DeadLetterSink.Send(letter)
+			if sendErr == nil {
+				break
+			}
+			level.Error(c.logger).Log("msg", "failed to send the batch to the dead letter sink", "err", sendErr, "cursor", letter.Cursor)
+			// the batch is processed again by the consumer taking over the shard
+			if c.shutDownFlag.Load() {
+				return
+			}
+			sleepWithContext(c.ctx, policy.initialBackoff())
+		}
+		level.Warn(c.logger).Log("msg", "send the batch failed to be processed to the dead letter sink", "err", err)
+		c.monitor.RecordDeadLetter()
+		c.skip(tracker)
+	default:
+		c.fail(err)
+	}
+}
+
+// skip advances the checkpoint over the batch
+func (c *ShardConsumerWorker) skip(tracker CheckPointTracker) {
+	if err := tracker.SaveCheckPoint(false); err != nil {
+		level.Warn(c.logger).Log("msg", "failed to save the checkpoint of the batch skipped", "err", err)
+	}
+}
+
+// fail stops the worker with the error, the ConsumerWorker stops after it finds the shard stopped
+func (c *ShardConsumerWorker) fail(err error) {
+	level.Error(c.logger).Log("msg", "stop the consumer because of the batch failed to be processed", "err", err)
+	c.failure.Store(err)
+	c.shutdown()
+}
+
+// getFailure returns the error the shard is stopped with by FailureActionStop, nil otherwise
+func (c *ShardConsumerWorker) getFailure() error {
+	return c.failure.Load()
+}
+
+// Err returns the error the worker is stopped with by FailureActionStop, nil otherwise
+func (consumerWorker *ConsumerWorker) Err() error {
+	return consumerWorker.failure.Load()
+}
+
+// Failed returns a channel closed when the worker is stopped by FailureActionStop,
+// the worker stops by itself, and StopAndWait can still be called to wait for it.
+func (consumerWorker *ConsumerWorker) Failed() <-chan struct{} {
+	return consumerWorker.failed
+}
+
+// fail stops the worker after the processing of a shard is stopped by FailureActionStop
+func (consumerWorker *ConsumerWorker) fail(err error) {
+	consumerWorker.failOnce.Do(func() {
+		level.Error(consumerWorker.Logger).Log("msg", "consumer worker is stopped because of the batch failed to be processed", "err", err)
+		consumerWorker.failure.Store(err)
+		consumerWorker.workerShutDownFlag.Store(true)
+		consumerWorker.cancel()
+		consumerWorker.consumerHeatBeat.shutDownHeart()
+		close(consumerWorker.failed)
+	})
+}
@@ -0,0 +1,159 @@
+package consumerLibrary
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+var errPoison = errors.New("poison batch")
+
+// poisonProcessor fails to process the first batch of shard 0, and saves the checkpoints of the others
+func poisonProcessor(attempts *atomic.Int32) ContextProcessFunc {
+	return func(ctx context.Context, shard int, logGroupList *sls.LogGroupList, tracker CheckPointTracker) (string, error) {
+		if tracker.GetCurrentCursor() == "0-0" {
+			attempts.Inc()
+			return "", errPoison
+		}
+		return "", tracker.SaveCheckPoint(false)
+	}
+}
+
+func TestFailurePolicyBackoff(t *testing.T) {
+	policy := &FailurePolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	var backoffs []time.Duration
+	for backoff := policy.initialBackoff(); len(backoffs) < 5; backoff = policy.nextBackoff(backoff) {
+		backoffs = append(backoffs, backoff)
+	}
+	ms := time.Millisecond
+	assert.Equal(t, []time.Duration{10 * ms, 20 * ms, 40 * ms, 50 * ms, 50 * ms}, backoffs)
+
+	// the sleep time is not increased by default
+	policy = &FailurePolicy{}
+	assert.Equal(t, processFailedSleepTime, policy.nextBackoff(policy.initialBackoff()))
+	assert.False(t, policy.exhausted(100))
+}
+
+func TestFailurePolicySkip(t *testing.T) {
+	for _, concurrency := range []int{1, 2} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			transport := httpmock.NewMockTransport()
+			logstore := registerFakeLogstore(transport)
+			logstore.setShard(0, "readwrite", 3)
+			group := registerFakeConsumerGroup(transport, "cg")
+
+			client := newReplayTestClient(transport)
+			client.option.ShardProcessConcurrency = concurrency
+			client.option.FailurePolicy = FailurePolicy{MaxRetries: 2, InitialBackoff: time.Millisecond, Action: FailureActionSkip}
+			var attempts atomic.Int32
+			worker := newTestShardConsumerWorker(context.Background(), client, poisonProcessor(&attempts))
+			worker.ensureStarted()
+			assert.Eventually(t, worker.isEndReached, 5*time.Second, 10*time.Millisecond)
+			assert.Equal(t, "0-2", group.getCheckpoint(0))
+			assert.Equal(t, int32(3), attempts.Load())
+
+			metrics := worker.monitor.metrics.Load().(*MonitorMetrics)
+			assert.Equal(t, int64(2), metrics.processRetryCount.Load())
+			assert.Equal(t, int64(1), metrics.processSkippedCount.Load())
+			assert.Equal(t, int64(0), metrics.deadLetterCount.Load())
+
+			worker.shutdown()
+			assert.Eventually(t, worker.isStopped, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+// failingSink fails to send the first letters
+type failingSink struct {
+	failures atomic.Int32
+	sink     DeadLetterSink
+}
+
+func (s *failingSink) Send(letter *DeadLetter) error {
+	if s.failures.Dec() >= 0 {
+		return errors.New("sink unavailable")
+	}
+	return s.sink.Send(letter)
+}
+
+func TestFailurePolicyDeadLetter(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	logstore := registerFakeLogstore(transport)
+	logstore.setShard(0, "readwrite", 3)
+	group := registerFakeConsumerGroup(transport, "cg")
+
+	dir := t.TempDir()
+	fileSink, err := NewFileDeadLetterSink(dir)
+	require.NoError(t, err)
+	sink := &failingSink{sink: fileSink}
+	sink.failures.Store(2)
+
+	client := newReplayTestClient(transport)
+	client.option.FailurePolicy = FailurePolicy{
+		MaxRetries:     1,
+		InitialBackoff: time.Millisecond,
+		Action:         FailureActionDeadLetter,
+		DeadLetterSink: sink,
+	}
+	var attempts atomic.Int32
+	worker := newTestShardConsumerWorker(context.Background(), client, poisonProcessor(&attempts))
+	worker.ensureStarted()
+	assert.Eventually(t, worker.isEndReached, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "0-2", group.getCheckpoint(0))
+	assert.Equal(t, int32(2), attempts.Load())
+	metrics := worker.monitor.metrics.Load().(*MonitorMetrics)
+	assert.Equal(t, int64(1), metrics.deadLetterCount.Load())
+
+	worker.shutdown()
+	assert.Eventually(t, worker.isStopped, 5*time.Second, 10*time.Millisecond)
+
+	data, err := os.ReadFile(filepath.Join(dir, "shard-0.deadletter"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+	var record deadLetterRecord
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, 0, record.ShardId)
+	assert.Equal(t, "0-0", record.Cursor)
+	assert.Equal(t, "0-1", record.NextCursor)
+	assert.Contains(t, record.Error, errPoison.Error())
+}
+
+func TestFailurePolicyStop(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	logstore := registerFakeLogstore(transport)
+	logstore.setShard(0, "readwrite", 3)
+	group := registerFakeConsumerGroup(transport, "cg")
+
+	worker := newReplayTestWorker(transport)
+	worker.client.option.FailurePolicy = FailurePolicy{MaxRetries: 1, InitialBackoff: time.Millisecond, Action: FailureActionStop}
+	var attempts atomic.Int32
+	worker.processor = poisonProcessor(&attempts)
+	consumer := worker.getShardConsumer(0)
+	consumer.ensureStarted()
+	assert.Eventually(t, consumer.isStopped, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), attempts.Load())
+	assert.Equal(t, "", group.getCheckpoint(0))
+	assert.NoError(t, worker.Err())
+
+	worker.cleanShardConsumer([]int{0})
+	assert.ErrorIs(t, worker.Err(), errPoison)
+	assert.True(t, worker.workerShutDownFlag.Load())
+	select {
+	case <-worker.Failed():
+	default:
+		t.Fatal("the worker is stopped by the failure policy")
+	}
+}
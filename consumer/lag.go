@@ -0,0 +1,170 @@
+package consumerLibrary
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+// ShardLag is how far the consumption of a shard is behind the data written to it
+type ShardLag struct {
+	ShardId        int
+	Consumer       string    // the consumer which saved the checkpoint last time, empty if no checkpoint is saved
+	CheckPoint     string    // empty if no checkpoint is saved, the lag is computed from the beginning of the shard
+	CheckPointTime time.Time // the time of the checkpoint cursor
+	EndTime        time.Time // the time of the end cursor
+	LagSeconds     int64     // EndTime - CheckPointTime, 0 if all the data is consumed
+}
+
+// GetConsumerGroupLag computes the lag of each shard of the logstore consumed by the consumer group,
+// by comparing the time of the checkpoint cursor with the time of the end cursor.
+// It can be used for any consumer group, no matter whether it is consumed by this library.
+func GetConsumerGroupLag(client sls.ClientInterface, project, logstore, consumerGroup string) ([]ShardLag, error) {
+	shards, err := client.ListShards(project, logstore)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints, err := client.GetCheckpoint(project, logstore, consumerGroup)
+	if err != nil {
+		return nil, err
+	}
+	checkpointOfShard := make(map[int]*sls.ConsumerGroupCheckPoint)
+	for _, checkpoint := range checkpoints {
+		checkpointOfShard[checkpoint.ShardID] = checkpoint
+	}
+
+	lags := make([]ShardLag, 0, len(shards))
+	for _, shard := range shards {
+		lag := ShardLag{ShardId: shard.ShardID}
+		if checkpoint, ok := checkpointOfShard[shard.ShardID]; ok {
+			lag.Consumer = checkpoint.Consumer
+			lag.CheckPoint = checkpoint.CheckPoint
+		}
+		if err := computeShardLag(client, project, logstore, &lag); err != nil {
+			return nil, err
+		}
+		lags = append(lags, lag)
+	}
+	sort.Slice(lags, func(i, j int) bool { return lags[i].ShardId < lags[j].ShardId })
+	return lags, nil
+}
+
+// computeShardLag fills the times and the lag of the checkpoint
+func computeShardLag(client sls.ClientInterface, project, logstore string, lag *ShardLag) error {
+	endCursor, err := client.GetCursor(project, logstore, lag.ShardId, "end")
+	if err != nil {
+		return err
+	}
+	cursor := lag.CheckPoint
+	if cursor == "" {
+		if cursor, err = client.GetCursor(project, logstore, lag.ShardId, "begin"); err != nil {
+			return err
+		}
+	}
+	if lag.EndTime, err = client.GetCursorTime(project, logstore, lag.ShardId, endCursor); err != nil {
+		return err
+	}
+	if cursor == endCursor {
+		lag.CheckPointTime = lag.EndTime
+		lag.LagSeconds = 0
+		return nil
+	}
+	if lag.CheckPointTime, err = client.GetCursorTime(project, logstore, lag.ShardId, cursor); err != nil {
+		return err
+	}
+	lag.LagSeconds = int64(lag.EndTime.Sub(lag.CheckPointTime) / time.Second)
+	if lag.LagSeconds < 0 {
+		lag.LagSeconds = 0
+	}
+	return nil
+}
+
+// ShardStatus is the status of a shard held by the consumer
+type ShardStatus struct {
+	ShardId         int
+	State           string // INITIALIZING, PULLING, PROCESSING, SHUTTING_DOWN or SHUTDOWN_COMPLETE
+	CheckPoint      string // the checkpoint saved, or the cursor consumed if no checkpoint is saved
+	LastFetchTime   time.Time
+	LastProcessTime time.Time
+	LagSeconds      int64 // how far the checkpoint is behind the end of the shard, in seconds of log receiving time
+	// BytesBehind is estimated by the raw size of the logs fetched per second of receiving time, -1 if unknown yet
+	BytesBehind int64
+	Err         error // the error when computing the lag, the lag is 0 if it is not nil
+}
+
+// Status returns the status of the shards held by the worker, sorted by shard id.
+// The lag is computed by requests to the server, don't call it too frequently.
+func (consumerWorker *ConsumerWorker) Status() []ShardStatus {
+	var status []ShardStatus
+	consumerWorker.shardConsumer.Range(func(key, value interface{}) bool {
+		status = append(status, value.(*ShardConsumerWorker).status(true))
+		return true
+	})
+	sort.Slice(status, func(i, j int) bool { return status[i].ShardId < status[j].ShardId })
+	return status
+}
+
+// lagEstimator estimates the bytes behind by the raw size of the logs fetched,
+// between the times of the checkpoints of two status computations
+type lagEstimator struct {
+	mutex          sync.Mutex
+	checkPointTime time.Time
+	fetchedBytes   int64
+	bytesPerSecond float64 // 0 if unknown
+}
+
+func (e *lagEstimator) estimate(checkPointTime time.Time, fetchedBytes int64, lagSeconds int64) int64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if elapsed := checkPointTime.Sub(e.checkPointTime).Seconds(); !e.checkPointTime.IsZero() && elapsed > 0 {
+		e.bytesPerSecond = float64(fetchedBytes-e.fetchedBytes) / elapsed
+	}
+	e.checkPointTime = checkPointTime
+	e.fetchedBytes = fetchedBytes
+	if lagSeconds == 0 {
+		return 0
+	}
+	if e.bytesPerSecond <= 0 {
+		return -1
+	}
+	return int64(e.bytesPerSecond * float64(lagSeconds))
+}
+
+// status returns the status of the shard, the lag is computed by requests to the server only if computeLag is true,
+// otherwise LagSeconds is 0 and BytesBehind is -1 unless the consumer has caught up
+func (c *ShardConsumerWorker) status(computeLag bool) ShardStatus {
+	tracker := c.consumerCheckPointTracker
+	status := ShardStatus{
+		ShardId:         c.shardId,
+		State:           c.state.Load(),
+		CheckPoint:      tracker.GetCheckPoint(),
+		LastFetchTime:   c.monitor.getLastFetchTime(),
+		LastProcessTime: c.monitor.getLastProcessTime(),
+		BytesBehind:     -1,
+	}
+	if status.CheckPoint == "" {
+		status.CheckPoint = tracker.GetCurrentCursor()
+	}
+	if status.CheckPoint == "" {
+		return status
+	}
+	// all the data fetched is consumed, and there is no more data on the last fetch
+	if c.caughtUp.Load() && status.CheckPoint == tracker.GetNextCursor() {
+		status.BytesBehind = 0
+		return status
+	}
+	if !computeLag {
+		return status
+	}
+
+	lag := ShardLag{ShardId: c.shardId, CheckPoint: status.CheckPoint}
+	option := c.client.option
+	if status.Err = computeShardLag(c.client.client, option.Project, option.Logstore, &lag); status.Err != nil {
+		return status
+	}
+	status.LagSeconds = lag.LagSeconds
+	status.BytesBehind = c.lagEstimator.estimate(lag.CheckPointTime, c.fetchedBytes.Load(), lag.LagSeconds)
+	return status
+}
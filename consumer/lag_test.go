@@ -0,0 +1,90 @@
+package consumerLibrary
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-log-go-sdk/internal/testutil/clienthelper"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetConsumerGroupLag(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	logstore := registerFakeLogstore(transport)
+	logstore.setShard(0, "readwrite", 5)
+	logstore.setShard(1, "readwrite", 3)
+	logstore.setShard(2, "readwrite", 2)
+	group := registerFakeConsumerGroup(transport, "cg")
+	group.setCheckpoint(0, "0-2")
+	group.setCheckpoint(1, "1-3")
+
+	lags, err := GetConsumerGroupLag(clienthelper.NewMockedClient(transport), "p", "l", "cg")
+	require.NoError(t, err)
+	assert.Equal(t, []ShardLag{
+		{ShardId: 0, Consumer: "c", CheckPoint: "0-2", CheckPointTime: time.Unix(20, 0), EndTime: time.Unix(50, 0), LagSeconds: 30},
+		{ShardId: 1, Consumer: "c", CheckPoint: "1-3", CheckPointTime: time.Unix(30, 0), EndTime: time.Unix(30, 0), LagSeconds: 0},
+		{ShardId: 2, CheckPointTime: time.Unix(0, 0), EndTime: time.Unix(20, 0), LagSeconds: 20},
+	}, lags)
+}
+
+func TestShardConsumerWorkerStatus(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	logstore := registerFakeLogstore(transport)
+	logstore.setShard(0, "readwrite", 5)
+	worker := newTestShardConsumerWorker(context.Background(), newMockedConsumerClient(transport), ContextProcessFunc(nil))
+	tracker := worker.consumerCheckPointTracker
+
+	status := worker.status(true)
+	assert.Equal(t, ShardStatus{ShardId: 0, State: INITIALIZING, BytesBehind: -1}, status)
+
+	// the bytes behind is unknown until the checkpoint advances
+	tracker.initCheckPoint("0-2")
+	status = worker.status(true)
+	require.NoError(t, status.Err)
+	assert.Equal(t, "0-2", status.CheckPoint)
+	assert.Equal(t, int64(30), status.LagSeconds)
+	assert.Equal(t, int64(-1), status.BytesBehind)
+
+	// 100 bytes are fetched in 10 seconds of receiving time
+	worker.fetchedBytes.Add(100)
+	tracker.initCheckPoint("0-3")
+	status = worker.status(true)
+	assert.Equal(t, int64(20), status.LagSeconds)
+	assert.Equal(t, int64(200), status.BytesBehind)
+
+	// the lag is not computed for the runtime metrics by default
+	calls := transport.GetTotalCallCount()
+	status = worker.status(false)
+	assert.Equal(t, "0-3", status.CheckPoint)
+	assert.Equal(t, int64(0), status.LagSeconds)
+	assert.Equal(t, int64(-1), status.BytesBehind)
+	assert.Equal(t, calls, transport.GetTotalCallCount())
+
+	// caught up without requests to the server
+	tracker.setNextCursor("0-3")
+	worker.caughtUp.Store(true)
+	status = worker.status(true)
+	assert.Equal(t, int64(0), status.LagSeconds)
+	assert.Equal(t, int64(0), status.BytesBehind)
+	assert.Equal(t, calls, transport.GetTotalCallCount())
+}
+
+func TestConsumerWorkerStatus(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	registerFakeLogstore(transport)
+	worker := newReplayTestWorker(transport)
+	worker.getShardConsumer(2)
+	worker.getShardConsumer(1).monitor.RecordFetchRequest(nil, assert.AnError, time.Now())
+	worker.getShardConsumer(1).monitor.RecordProcess(nil, time.Now())
+
+	status := worker.Status()
+	require.Len(t, status, 2)
+	assert.Equal(t, 1, status[0].ShardId)
+	assert.Equal(t, 2, status[1].ShardId)
+	assert.Equal(t, INITIALIZING, status[0].State)
+	assert.True(t, status[0].LastFetchTime.IsZero())
+	assert.False(t, status[0].LastProcessTime.IsZero())
+}
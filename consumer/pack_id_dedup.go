@@ -0,0 +1,132 @@
+package consumerLibrary
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+const packIdTagKey = "__pack_id__"
+
+// the max count of producers (pack id prefixes) tracked, the least recently seen one is forgotten beyond it
+const maxTrackedPackIdPrefixes = 4096
+
+// PackIdDeduplicator filters LogGroups written more than once by a producer with GeneratePackId enabled,
+// eg. a batch retried after a timeout although the first request had succeeded.
+// It tracks the pack id sequences seen for each prefix within a window: a LogGroup is a duplicate
+// if its sequence is within the window behind the largest one seen and has been seen before.
+// Sequences older than the window are not tracked and always pass, so data is never dropped by mistake.
+// LogGroups without a pack id always pass.
+// It is thread safe, share one instance between the shards of a logstore, as a retried batch may be written to another shard.
+type PackIdDeduplicator struct {
+	window   int64
+	mutex    sync.Mutex
+	clock    int64
+	prefixes map[string]*packIdWindow
+}
+
+type packIdWindow struct {
+	maxSequence int64
+	seen        map[int64]struct{}
+	lastSeen    int64
+}
+
+// NewPackIdDeduplicator creates a PackIdDeduplicator tracking the latest window sequences of each prefix.
+func NewPackIdDeduplicator(window int64) *PackIdDeduplicator {
+	if window <= 0 {
+		window = 10000
+	}
+	return &PackIdDeduplicator{
+		window:   window,
+		prefixes: make(map[string]*packIdWindow),
+	}
+}
+
+// ParsePackId splits a pack id generated by the producer into its prefix and sequence.
+func ParsePackId(packId string) (prefix string, sequence int64, ok bool) {
+	i := strings.LastIndex(packId, "-")
+	if i <= 0 || i == len(packId)-1 {
+		return "", 0, false
+	}
+	sequence, err := strconv.ParseInt(packId[i+1:], 16, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return packId[:i], sequence, true
+}
+
+// GetPackId returns the pack id tag of the LogGroup, or "" if there is none.
+func GetPackId(logGroup *sls.LogGroup) string {
+	for _, tag := range logGroup.GetLogTags() {
+		if tag.GetKey() == packIdTagKey {
+			return tag.GetValue()
+		}
+	}
+	return ""
+}
+
+// IsDuplicate records the pack id of the LogGroup, and returns true if it has been seen before.
+func (d *PackIdDeduplicator) IsDuplicate(logGroup *sls.LogGroup) bool {
+	prefix, sequence, ok := ParsePackId(GetPackId(logGroup))
+	if !ok {
+		return false
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.clock++
+	w, ok := d.prefixes[prefix]
+	if !ok {
+		d.evictIfFull()
+		w = &packIdWindow{maxSequence: sequence, seen: make(map[int64]struct{})}
+		d.prefixes[prefix] = w
+	}
+	w.lastSeen = d.clock
+	if sequence <= w.maxSequence-d.window {
+		return false
+	}
+	if _, seen := w.seen[sequence]; seen {
+		return true
+	}
+	w.seen[sequence] = struct{}{}
+	if sequence > w.maxSequence {
+		w.maxSequence = sequence
+	}
+	if int64(len(w.seen)) > 2*d.window {
+		for s := range w.seen {
+			if s <= w.maxSequence-d.window {
+				delete(w.seen, s)
+			}
+		}
+	}
+	return false
+}
+
+// Filter returns the LogGroups of the list that are not duplicates, the list itself is not modified.
+func (d *PackIdDeduplicator) Filter(logGroupList *sls.LogGroupList) *sls.LogGroupList {
+	if logGroupList == nil {
+		return nil
+	}
+	result := &sls.LogGroupList{LogGroups: make([]*sls.LogGroup, 0, len(logGroupList.LogGroups))}
+	for _, logGroup := range logGroupList.LogGroups {
+		if !d.IsDuplicate(logGroup) {
+			result.LogGroups = append(result.LogGroups, logGroup)
+		}
+	}
+	return result
+}
+
+func (d *PackIdDeduplicator) evictIfFull() {
+	if len(d.prefixes) < maxTrackedPackIdPrefixes {
+		return
+	}
+	var oldest string
+	oldestSeen := d.clock
+	for prefix, w := range d.prefixes {
+		if w.lastSeen < oldestSeen {
+			oldest, oldestSeen = prefix, w.lastSeen
+		}
+	}
+	delete(d.prefixes, oldest)
+}
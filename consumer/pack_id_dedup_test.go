@@ -0,0 +1,76 @@
+package consumerLibrary
+
+import (
+	"fmt"
+	"testing"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPackIdLogGroup(packId string) *sls.LogGroup {
+	logGroup := &sls.LogGroup{}
+	if packId != "" {
+		logGroup.LogTags = []*sls.LogTag{{Key: proto.String(packIdTagKey), Value: proto.String(packId)}}
+	}
+	return logGroup
+}
+
+func TestParsePackId(t *testing.T) {
+	for _, tt := range []struct {
+		packId   string
+		prefix   string
+		sequence int64
+		ok       bool
+	}{
+		{"5FA51423E4A7E4D0-1A", "5FA51423E4A7E4D0", 26, true},
+		{"5FA51423E4A7E4D0-0", "5FA51423E4A7E4D0", 0, true},
+		{"", "", 0, false},
+		{"5FA51423E4A7E4D0", "", 0, false},
+		{"5FA51423E4A7E4D0-", "", 0, false},
+		{"5FA51423E4A7E4D0-XYZ", "", 0, false},
+	} {
+		prefix, sequence, ok := ParsePackId(tt.packId)
+		assert.Equal(t, tt.ok, ok, tt.packId)
+		assert.Equal(t, tt.prefix, prefix, tt.packId)
+		assert.Equal(t, tt.sequence, sequence, tt.packId)
+	}
+}
+
+func TestPackIdDeduplicator(t *testing.T) {
+	d := NewPackIdDeduplicator(3)
+	for _, tt := range []struct {
+		packId    string
+		duplicate bool
+	}{
+		{"A-1", false},
+		{"A-2", false},
+		{"A-1", true}, // retried batch
+		{"B-1", false},
+		{"", false},
+		{"", false},
+		{"A-5", false},
+		{"A-3", false}, // out of order, but within the window
+		{"A-3", true},
+		{"A-2", false}, // too old to tell, passed to avoid data loss
+		{"A-5", true},
+	} {
+		assert.Equal(t, tt.duplicate, d.IsDuplicate(newPackIdLogGroup(tt.packId)), tt.packId)
+	}
+}
+
+func TestPackIdDeduplicatorFilter(t *testing.T) {
+	d := NewPackIdDeduplicator(100)
+	list := &sls.LogGroupList{}
+	for i := 0; i < 10; i++ {
+		list.LogGroups = append(list.LogGroups, newPackIdLogGroup(fmt.Sprintf("A-%X", i)))
+	}
+	assert.Len(t, d.Filter(list).LogGroups, 10)
+
+	redelivered := &sls.LogGroupList{LogGroups: []*sls.LogGroup{list.LogGroups[9], newPackIdLogGroup("A-A")}}
+	filtered := d.Filter(redelivered)
+	assert.Len(t, filtered.LogGroups, 1)
+	assert.Equal(t, "A-A", GetPackId(filtered.LogGroups[0]))
+	assert.Len(t, redelivered.LogGroups, 2)
+}
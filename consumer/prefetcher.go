@@ -0,0 +1,200 @@
+package consumerLibrary
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+const defaultPrefetchMemoryLimit = 256 * 1024 * 1024
+
+// fetchedBatch is the log groups pulled in the background
+type fetchedBatch struct {
+	logGroupList *sls.LogGroupList
+	plm          *sls.PullLogMeta
+	size         int64 // the bytes charged to the memory budget
+}
+
+// memoryBudget bounds the size of the batches prefetched by all the shard consumers of a worker,
+// it is a soft limit, the batches are fetched while the used size is below the limit.
+type memoryBudget struct {
+	limit    int64
+	mutex    sync.Mutex
+	used     int64
+	released chan struct{} // closed and replaced when some memory is released
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	if limit <= 0 {
+		limit = defaultPrefetchMemoryLimit
+	}
+	return &memoryBudget{
+		limit:    limit,
+		released: make(chan struct{}),
+	}
+}
+
+// wait waits until the used size is below the limit, or the ctx is done
+func (b *memoryBudget) wait(ctx context.Context) error {
+	for {
+		b.mutex.Lock()
+		if b.used < b.limit {
+			b.mutex.Unlock()
+			return nil
+		}
+		released := b.released
+		b.mutex.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *memoryBudget) acquire(size int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.used += size
+}
+
+func (b *memoryBudget) release(size int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.used -= size
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+func (b *memoryBudget) usedSize() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.used
+}
+
+// prefetcher pulls the next batches of a shard in the background while the current batch is processed,
+// at most depth batches are fetched ahead of the processing.
+type prefetcher struct {
+	worker    *ShardConsumerWorker
+	budget    *memoryBudget
+	depth     int
+	endCursor string
+
+	next    string // the cursor of the next batch fetched in the background
+	batches chan *fetchedBatch
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func newPrefetcher(worker *ShardConsumerWorker, budget *memoryBudget, depth int) *prefetcher {
+	return &prefetcher{
+		worker: worker,
+		budget: budget,
+		depth:  depth,
+	}
+}
+
+// fetch returns the batch pulled from the cursor, it returns nil if the worker is shutting down.
+// The batches fetched in the background are dropped and fetched again if the cursor is not the expected one,
+// eg. a rollback checkpoint is returned by the processor.
+func (p *prefetcher) fetch(cursor string) *fetchedBatch {
+	if p.worker.ctx.Err() != nil {
+		return nil
+	}
+	if p.batches == nil || cursor != p.next {
+		p.stop()
+		p.start(cursor)
+	}
+	select {
+	case batch := <-p.batches:
+		p.next = batch.plm.NextCursor
+		return batch
+	case <-p.worker.ctx.Done():
+		return nil
+	}
+}
+
+// processed releases the memory of the batch after it is processed
+func (p *prefetcher) processed(batch *fetchedBatch) {
+	p.budget.release(batch.size)
+}
+
+func (p *prefetcher) start(cursor string) {
+	ctx, cancel := context.WithCancel(p.worker.ctx)
+	p.next = cursor
+	// the batch waiting to be sent is also fetched ahead
+	p.batches = make(chan *fetchedBatch, p.depth-1)
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	go p.run(ctx, cursor, p.batches, p.done)
+}
+
+// stop stops fetching in the background, and drops the batches fetched
+func (p *prefetcher) stop() {
+	if p.batches == nil {
+		return
+	}
+	p.cancel()
+	<-p.done
+	for {
+		select {
+		case batch := <-p.batches:
+			p.processed(batch)
+		default:
+			p.batches = nil
+			return
+		}
+	}
+}
+
+func (p *prefetcher) run(ctx context.Context, cursor string, batches chan<- *fetchedBatch, done chan<- struct{}) {
+	c := p.worker
+	defer func() {
+		c.recoverIfPanic("prefetch panic")
+		close(done)
+	}()
+	for ctx.Err() == nil {
+		if p.budget.wait(ctx) != nil {
+			return
+		}
+		lastFetchTime := time.Now()
+		logGroupList, plm, err := c.pullLogs(cursor, p.endCursor)
+		if err != nil {
+			continue
+		}
+		batch := &fetchedBatch{
+			logGroupList: logGroupList,
+			plm:          plm,
+			size:         int64(plm.RawSize),
+		}
+		p.budget.acquire(batch.size)
+		select {
+		case batches <- batch:
+		case <-ctx.Done():
+			p.processed(batch)
+			return
+		}
+
+		// the same as runLoop, sleep longer to reduce meanless data fetching after reaching the endCursor
+		if p.endCursor != "" && plm.NextCursor == p.endCursor {
+			sleepWithContext(ctx, 5*time.Second)
+		} else if cursor == plm.NextCursor { // already reach end of shard
+			sleepWithContext(ctx, noProgressSleepTime)
+		}
+		cursor = plm.NextCursor
+		// the wait is stopped with the prefetcher, so that revoking the shard isn't delayed
+		sleepWithContext(ctx, c.nextFetchDelay(lastFetchTime, plm))
+	}
+}
+
+func sleepWithContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,156 @@
+package consumerLibrary
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// shardCursors serves empty batches, the next cursor of "c<n>" is "c<n+1>"
+type shardCursors struct {
+	mutex   sync.Mutex
+	fetched []string
+}
+
+func (s *shardCursors) register(transport *httpmock.MockTransport) {
+	transport.RegisterResponder("GET", `=~/logstores/l/shards/0`, func(req *http.Request) (*http.Response, error) {
+		cursor := req.URL.Query().Get("cursor")
+		s.mutex.Lock()
+		s.fetched = append(s.fetched, cursor)
+		s.mutex.Unlock()
+		n, _ := strconv.Atoi(strings.TrimPrefix(cursor, "c"))
+		resp := httpmock.NewBytesResponse(200, nil)
+		resp.Header.Set("X-Log-Cursor", fmt.Sprintf("c%d", n+1))
+		resp.Header.Set("X-Log-Count", "0")
+		resp.Header.Set("X-Log-Bodyrawsize", "0")
+		return resp, nil
+	})
+}
+
+func (s *shardCursors) cursors() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.fetched...)
+}
+
+func newPrefetchTestWorker(t *testing.T, shard *shardCursors, depth int, budget *memoryBudget) *ShardConsumerWorker {
+	transport := httpmock.NewMockTransport()
+	shard.register(transport)
+	client := newMockedConsumerClient(transport)
+	client.option.DataFetchIntervalInMs = 0
+	client.option.PrefetchDepth = depth
+	worker := newShardConsumerWorker(context.Background(), 0, client, initConsumerHeatBeat(client, log.NewNopLogger()),
+		ContextProcessFunc(nil), log.NewNopLogger(), newSimpleIoThrottler(1), budget)
+	require.NotNil(t, worker.prefetcher)
+	t.Cleanup(func() {
+		worker.shutdown()
+		worker.prefetcher.stop()
+	})
+	return worker
+}
+
+func TestMemoryBudget(t *testing.T) {
+	budget := newMemoryBudget(10)
+	require.NoError(t, budget.wait(context.Background()))
+	budget.acquire(15)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, budget.wait(ctx), context.DeadlineExceeded)
+
+	waited := make(chan error)
+	go func() { waited <- budget.wait(context.Background()) }()
+	budget.release(4)
+	select {
+	case <-waited:
+		t.Fatal("the used size is still above the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	budget.release(11)
+	assert.NoError(t, <-waited)
+	assert.Equal(t, int64(0), budget.usedSize())
+	assert.Equal(t, int64(defaultPrefetchMemoryLimit), newMemoryBudget(0).limit)
+}
+
+func TestPrefetcherFetchAhead(t *testing.T) {
+	shard := &shardCursors{}
+	worker := newPrefetchTestWorker(t, shard, 2, newMemoryBudget(0))
+
+	batch := worker.prefetcher.fetch("c0")
+	require.NotNil(t, batch)
+	assert.Equal(t, "c1", batch.plm.NextCursor)
+	// the batches from c1 and c2 are fetched while c0 is processed, and no more
+	assert.Eventually(t, func() bool { return len(shard.cursors()) == 3 }, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"c0", "c1", "c2"}, shard.cursors())
+
+	worker.prefetcher.processed(batch)
+	batch = worker.prefetcher.fetch("c1")
+	require.NotNil(t, batch)
+	assert.Equal(t, "c2", batch.plm.NextCursor)
+	assert.Eventually(t, func() bool { return len(shard.cursors()) == 4 }, time.Second, 10*time.Millisecond)
+}
+
+func TestPrefetcherRollback(t *testing.T) {
+	shard := &shardCursors{}
+	worker := newPrefetchTestWorker(t, shard, 2, newMemoryBudget(0))
+
+	batch := worker.prefetcher.fetch("c5")
+	require.NotNil(t, batch)
+	assert.Eventually(t, func() bool { return len(shard.cursors()) == 3 }, time.Second, 10*time.Millisecond)
+
+	// the processor rolls back to c3, the batches fetched ahead are dropped
+	worker.prefetcher.processed(batch)
+	batch = worker.prefetcher.fetch("c3")
+	require.NotNil(t, batch)
+	assert.Equal(t, "c4", batch.plm.NextCursor)
+	assert.Equal(t, []string{"c5", "c6", "c7", "c3"}, shard.cursors()[:4])
+}
+
+func TestPrefetcherMemoryBudget(t *testing.T) {
+	shard := &shardCursors{}
+	budget := newMemoryBudget(1)
+	budget.acquire(1)
+	worker := newPrefetchTestWorker(t, shard, 2, budget)
+
+	fetched := make(chan *fetchedBatch)
+	go func() { fetched <- worker.prefetcher.fetch("c0") }()
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, shard.cursors())
+
+	// the memory is released by the other shards
+	budget.release(1)
+	batch := <-fetched
+	require.NotNil(t, batch)
+	assert.Equal(t, "c1", batch.plm.NextCursor)
+
+	// the fetching is stopped when the worker is shutting down
+	worker.shutdown()
+	assert.Nil(t, worker.prefetcher.fetch("c1"))
+}
+
+func TestPrefetcherStopWhileWaiting(t *testing.T) {
+	shard := &shardCursors{}
+	worker := newPrefetchTestWorker(t, shard, 2, newMemoryBudget(0))
+	// empty batches are fetched every 500ms
+	worker.client.option.DataFetchIntervalInMs = 1000
+
+	batch := worker.prefetcher.fetch("c0")
+	require.NotNil(t, batch)
+	worker.prefetcher.processed(batch)
+
+	// the prefetcher waiting for the next fetch is stopped at once
+	start := time.Now()
+	worker.prefetcher.stop()
+	assert.Less(t, time.Since(start), 250*time.Millisecond)
+}
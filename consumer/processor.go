@@ -1,6 +1,10 @@
 package consumerLibrary
 
-import sls "github.com/aliyun/aliyun-log-go-sdk"
+import (
+	"context"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
 
 type Processor interface {
 	Process(int, *sls.LogGroupList, CheckPointTracker) (string, error)
@@ -17,3 +21,32 @@ func (processor ProcessFunc) Shutdown(checkpointTracker CheckPointTracker) error
 	// Do nothing
 	return nil
 }
+
+// ContextProcessor is a Processor whose context is cancelled when the shard is no longer assigned to the consumer,
+// or the worker is shutting down, so that long-running processing can be interrupted.
+// The checkpoint can still be saved until Shutdown returns, unless the server rejects it because
+// the shard is held by another consumer, the CheckPointTracker refuses to save checkpoints with ErrShardRevoked then.
+type ContextProcessor interface {
+	ProcessWithContext(context.Context, int, *sls.LogGroupList, CheckPointTracker) (string, error)
+	Shutdown(CheckPointTracker) error
+}
+
+type ContextProcessFunc func(context.Context, int, *sls.LogGroupList, CheckPointTracker) (string, error)
+
+func (processor ContextProcessFunc) ProcessWithContext(ctx context.Context, shard int, lgList *sls.LogGroupList, checkpointTracker CheckPointTracker) (string, error) {
+	return processor(ctx, shard, lgList, checkpointTracker)
+}
+
+func (processor ContextProcessFunc) Shutdown(checkpointTracker CheckPointTracker) error {
+	// Do nothing
+	return nil
+}
+
+// processorWithoutContext adapts a Processor to ContextProcessor
+type processorWithoutContext struct {
+	Processor
+}
+
+func (processor processorWithoutContext) ProcessWithContext(_ context.Context, shard int, lgList *sls.LogGroupList, checkpointTracker CheckPointTracker) (string, error) {
+	return processor.Process(shard, lgList, checkpointTracker)
+}
@@ -0,0 +1,357 @@
+package consumerLibrary
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"go.uber.org/atomic"
+)
+
+const defaultShardListIntervalInSecond = 10
+
+// ReaderConfig is the config of ShardReader and LogstoreTailer,
+// which read shards directly without consumer groups and heartbeats.
+type ReaderConfig struct {
+	//:param Project:
+	//:param Logstore:
+	//:param CursorPosition: BEGIN_CURSOR, END_CURSOR or SPECIAL_TIMER_CURSOR, default is BEGIN_CURSOR.
+	//	  The shards created after LogstoreTailer starts (eg. by splitting) are always read from the beginning, after their parents are read to the end.
+	//:param CursorStartTime: Will be used when CursorPosition is SPECIAL_TIMER_CURSOR, it's log receiving time. The unit of parameter is seconds.
+	//:param CursorEndTime: Shards are read until CursorEndTime reached. If set to zero, shards are read until they are readonly (eg. splitted or merged) and all the data is read.
+	//:param Query: SPL query to filter or transform the data, default is empty
+	//:param Processor: name of consume processor, default is empty
+	//:param MaxFetchLogGroupCount: default 1000, fetch size in each request
+	//:param CompressType: the compress type of the data pulled, default 0 standand for lz4
+	//:param ShardListIntervalInSecond: default 10, the interval to list shards to find the new shards and the readonly shards
+	//:param Logger: default nil, means no logs
+	Project                   string
+	Logstore                  string
+	CursorPosition            string
+	CursorStartTime           int64
+	CursorEndTime             int64
+	Query                     string
+	Processor                 string
+	MaxFetchLogGroupCount     int
+	CompressType              int
+	ShardListIntervalInSecond int
+	Logger                    log.Logger
+}
+
+func (config *ReaderConfig) shardListInterval() time.Duration {
+	if config.ShardListIntervalInSecond <= 0 {
+		return defaultShardListIntervalInSecond * time.Second
+	}
+	return time.Duration(config.ShardListIntervalInSecond) * time.Second
+}
+
+func (config *ReaderConfig) logger() log.Logger {
+	if config.Logger == nil {
+		return log.NewNopLogger()
+	}
+	return config.Logger
+}
+
+// ShardReader reads a shard in order, it is not thread safe
+type ShardReader struct {
+	client  sls.ClientInterface
+	config  ReaderConfig
+	shardId int
+
+	from            string // where to read from, the "from" parameter of GetCursor
+	cursor          string // the cursor of the next batch
+	endCursor       string // empty if no end
+	caughtUp        bool   // no more data in the shard on the last read
+	readOnly        bool   // the shard is readonly when it is caught up
+	lastStatusCheck time.Time
+}
+
+// NewShardReader returns a reader of the shard, the cursors are resolved on the first read
+func NewShardReader(client sls.ClientInterface, config ReaderConfig, shardId int) *ShardReader {
+	if config.MaxFetchLogGroupCount == 0 {
+		config.MaxFetchLogGroupCount = 1000
+	}
+	from := "begin"
+	switch config.CursorPosition {
+	case END_CURSOR:
+		from = "end"
+	case SPECIAL_TIMER_CURSOR:
+		from = fmt.Sprintf("%v", config.CursorStartTime)
+	}
+	return &ShardReader{
+		client:  client,
+		config:  config,
+		shardId: shardId,
+		from:    from,
+	}
+}
+
+// ShardId returns the id of the shard read
+func (r *ShardReader) ShardId() int {
+	return r.shardId
+}
+
+// Cursor returns the cursor of the next batch, it can be used to resume reading by SetCursor
+func (r *ShardReader) Cursor() string {
+	return r.cursor
+}
+
+// SetCursor sets the cursor of the next batch
+func (r *ShardReader) SetCursor(cursor string) {
+	r.cursor = cursor
+	r.caughtUp = false
+}
+
+func (r *ShardReader) init() (err error) {
+	if r.cursor == "" {
+		if r.cursor, err = r.client.GetCursor(r.config.Project, r.config.Logstore, r.shardId, r.from); err != nil {
+			return err
+		}
+	}
+	if r.config.CursorEndTime > 0 && r.endCursor == "" {
+		r.endCursor, err = r.client.GetCursor(r.config.Project, r.config.Logstore, r.shardId, fmt.Sprintf("%v", r.config.CursorEndTime))
+	}
+	return err
+}
+
+// Next returns the next batch of the shard, and waits for new data if all the data is read.
+// It returns io.EOF if CursorEndTime is reached, or the shard is readonly and all the data is read.
+// The batch may be empty if the logs are filtered by the query.
+func (r *ShardReader) Next(ctx context.Context) (*sls.LogGroupList, *sls.PullLogMeta, error) {
+	if err := r.init(); err != nil {
+		return nil, nil, err
+	}
+	for {
+		if r.endCursor != "" && r.cursor == r.endCursor {
+			return nil, nil, io.EOF
+		}
+		if r.caughtUp {
+			sleepWithContext(ctx, noProgressSleepTime)
+			// check the status before reading, so that no data written before it is readonly is missed
+			if err := r.checkReadOnly(); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		plr := &sls.PullLogRequest{
+			Project:          r.config.Project,
+			Logstore:         r.config.Logstore,
+			ShardID:          r.shardId,
+			Cursor:           r.cursor,
+			EndCursor:        r.endCursor,
+			Query:            r.config.Query,
+			LogGroupMaxCount: r.config.MaxFetchLogGroupCount,
+			CompressType:     r.config.CompressType,
+			Processor:        r.config.Processor,
+		}
+		logGroupList, plm, err := r.client.PullLogsWithQuery(plr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if plm.NextCursor == r.cursor {
+			if r.readOnly {
+				return nil, nil, io.EOF
+			}
+			r.caughtUp = true
+			continue
+		}
+		r.caughtUp = false
+		r.cursor = plm.NextCursor
+		return logGroupList, plm, nil
+	}
+}
+
+// checkReadOnly checks whether the shard is readonly at most once per ShardListIntervalInSecond,
+// the shard is also readonly if it doesn't exist anymore
+func (r *ShardReader) checkReadOnly() error {
+	if r.readOnly || time.Since(r.lastStatusCheck) < r.config.shardListInterval() {
+		return nil
+	}
+	shards, err := r.client.ListShards(r.config.Project, r.config.Logstore)
+	if err != nil {
+		return err
+	}
+	r.lastStatusCheck = time.Now()
+	r.readOnly = true
+	for _, shard := range shards {
+		if shard.ShardID == r.shardId {
+			// the case of the status differs between the server versions
+			r.readOnly = strings.EqualFold(shard.Status, "readonly")
+		}
+	}
+	return nil
+}
+
+// ReadFunc is called with the batches read from the shard,
+// the batches of a shard are passed in order, and the batches of different shards are passed concurrently.
+type ReadFunc func(shardId int, logGroupList *sls.LogGroupList, plm *sls.PullLogMeta) error
+
+// LogstoreTailer reads all the shards of a logstore concurrently, and follows the shards splitted and merged.
+// A shard created by splitting or merging is read after its parents are read to the end,
+// so that the logs of the same hash key are passed in order.
+type LogstoreTailer struct {
+	client sls.ClientInterface
+	config ReaderConfig
+	logger log.Logger
+}
+
+func NewLogstoreTailer(client sls.ClientInterface, config ReaderConfig) *LogstoreTailer {
+	return &LogstoreTailer{
+		client: client,
+		config: config,
+		logger: config.logger(),
+	}
+}
+
+// Run reads the shards until CursorEndTime is reached, or until ctx is done if CursorEndTime is not set.
+// It stops and returns the error if fn returns an error, or a shard keeps failing to be read.
+func (t *LogstoreTailer) Run(ctx context.Context, fn ReadFunc) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		waitGroup sync.WaitGroup
+		mutex     sync.Mutex
+		firstErr  error
+		running   atomic.Int32
+		idle      = make(chan struct{}, 1)
+		started   = make(map[int]*sls.Shard)
+		finished  = make(map[int]chan struct{}) // closed when the shard is read to the end
+	)
+	defer waitGroup.Wait()
+	stopped := func() error {
+		waitGroup.Wait()
+		mutex.Lock()
+		defer mutex.Unlock()
+		if firstErr != nil {
+			return firstErr
+		}
+		return ctx.Err()
+	}
+
+	for initial := true; ; initial = false {
+		if ctx.Err() != nil {
+			return stopped()
+		}
+		shards, err := t.client.ListShards(t.config.Project, t.config.Logstore)
+		if err != nil {
+			if initial {
+				return err
+			}
+			level.Warn(t.logger).Log("msg", "failed to list shards", "err", err)
+		}
+		// the parents are started first, so that the shards created from them wait for them
+		sort.Slice(shards, func(i, j int) bool { return shards[i].ShardID < shards[j].ShardID })
+		newShards := 0
+		for _, shard := range shards {
+			if started[shard.ShardID] != nil {
+				continue
+			}
+			var parents []chan struct{}
+			for id, other := range started {
+				if isParentShard(other, shard) {
+					parents = append(parents, finished[id])
+				}
+			}
+			started[shard.ShardID] = shard
+			done := make(chan struct{})
+			finished[shard.ShardID] = done
+			newShards++
+			reader := NewShardReader(t.client, t.config, shard.ShardID)
+			if !initial {
+				reader.from = "begin"
+				level.Info(t.logger).Log("msg", "new shard found", "shard", shard.ShardID, "parents", len(parents))
+			}
+			running.Inc()
+			waitGroup.Add(1)
+			go func() {
+				defer func() {
+					close(done)
+					if running.Dec() == 0 {
+						select {
+						case idle <- struct{}{}:
+						default:
+						}
+					}
+					waitGroup.Done()
+				}()
+				for _, parent := range parents {
+					select {
+					case <-parent:
+					case <-ctx.Done():
+						return
+					}
+				}
+				if err := t.readShard(ctx, reader, fn); err != nil {
+					mutex.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mutex.Unlock()
+					cancel()
+				}
+			}()
+		}
+		// all the shards are read to the end, and no more shards are created
+		if !initial && newShards == 0 && err == nil && t.config.CursorEndTime > 0 && running.Load() == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(t.config.shardListInterval())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-idle:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// isParentShard returns whether the shard is created by splitting or merging the parent,
+// the parent is an older shard (with a smaller id) whose hash key range overlaps the one of the shard
+func isParentShard(parent, shard *sls.Shard) bool {
+	if parent.ShardID >= shard.ShardID || parent.InclusiveBeginKey == "" || shard.InclusiveBeginKey == "" {
+		return false
+	}
+	return parent.InclusiveBeginKey < shard.ExclusiveBeginKey && shard.InclusiveBeginKey < parent.ExclusiveBeginKey
+}
+
+// readShard passes the batches of the shard to fn until the end of the shard,
+// it returns nil if ctx is done
+func (t *LogstoreTailer) readShard(ctx context.Context, reader *ShardReader, fn ReadFunc) error {
+	logger := log.With(t.logger, "shard", reader.ShardId())
+	for failures := 0; ; {
+		logGroupList, plm, err := reader.Next(ctx)
+		if err == io.EOF {
+			level.Info(logger).Log("msg", "all the data of the shard is read")
+			return nil
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			failures++
+			if failures >= 3 {
+				return fmt.Errorf("failed to read shard %d: %w", reader.ShardId(), err)
+			}
+			level.Warn(logger).Log("msg", "failed to read shard, retry later", "err", err, "cursor", reader.Cursor())
+			sleepWithContext(ctx, fetchFailedSleepTime)
+			continue
+		}
+		failures = 0
+		if err := fn(reader.ShardId(), logGroupList, plm); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,31 @@
+//go:build go1.23
+
+package consumerLibrary
+
+import (
+	"context"
+	"io"
+	"iter"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+// All returns an iterator over the batches of the shard, the same as calling Next until it returns io.EOF.
+// The iteration stops after an error is yielded.
+//
+//	for logGroupList, err := range reader.All(ctx) {
+//		...
+//	}
+func (r *ShardReader) All(ctx context.Context) iter.Seq2[*sls.LogGroupList, error] {
+	return func(yield func(*sls.LogGroupList, error) bool) {
+		for {
+			logGroupList, _, err := r.Next(ctx)
+			if err == io.EOF {
+				return
+			}
+			if !yield(logGroupList, err) || err != nil {
+				return
+			}
+		}
+	}
+}
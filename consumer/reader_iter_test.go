@@ -0,0 +1,25 @@
+//go:build go1.23
+
+package consumerLibrary
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardReaderAll(t *testing.T) {
+	store, client := newFakeLogstore(t)
+	store.setShard(0, "readwrite", 5)
+	reader := NewShardReader(client, ReaderConfig{Project: "p", Logstore: "l", CursorEndTime: 3}, 0)
+	n := 0
+	for logGroupList, err := range reader.All(context.Background()) {
+		require.NoError(t, err)
+		assert.NotNil(t, logGroupList)
+		n++
+	}
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "0-3", reader.Cursor())
+}
@@ -0,0 +1,281 @@
+package consumerLibrary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/internal/testutil/clienthelper"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLogstore serves the shards with one batch per cursor, the cursor of the n-th batch of shard s is "s-n",
+// the cursor of the log received at time t is the one of the t-th batch, and the time of the n-th cursor is n*10
+type fakeLogstore struct {
+	mutex  sync.Mutex
+	shards map[int]*fakeShard
+}
+
+type fakeShard struct {
+	status   string
+	batches  int
+	beginKey string
+	endKey   string
+}
+
+func newFakeLogstore(t *testing.T) (*fakeLogstore, sls.ClientInterface) {
+	transport := httpmock.NewMockTransport()
+	return registerFakeLogstore(transport), clienthelper.NewMockedClient(transport)
+}
+
+func registerFakeLogstore(transport *httpmock.MockTransport) *fakeLogstore {
+	store := &fakeLogstore{shards: make(map[int]*fakeShard)}
+	transport.RegisterResponder("GET", `=~/logstores/l/shards`, store.serve)
+	return store
+}
+
+func (s *fakeLogstore) setShard(shardId int, status string, batches int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.shards[shardId] = &fakeShard{status: status, batches: batches}
+}
+
+// setShardRange sets the hash key range of the shard, the shards without a range are not related to each other
+func (s *fakeLogstore) setShardRange(shardId int, beginKey, endKey string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.shards[shardId].beginKey, s.shards[shardId].endKey = beginKey, endKey
+}
+
+func (s *fakeLogstore) serve(req *http.Request) (*http.Response, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if strings.HasSuffix(req.URL.Path, "/shards") {
+		var shards []*sls.Shard
+		for id, shard := range s.shards {
+			shards = append(shards, &sls.Shard{ShardID: id, Status: shard.status, InclusiveBeginKey: shard.beginKey, ExclusiveBeginKey: shard.endKey})
+		}
+		return httpmock.NewJsonResponse(200, shards)
+	}
+	shardId, _ := strconv.Atoi(req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:])
+	shard := s.shards[shardId]
+	query := req.URL.Query()
+	if query.Get("type") == "cursor" {
+		n := 0
+		switch from := query.Get("from"); from {
+		case "begin":
+		case "end":
+			n = shard.batches
+		default:
+			n, _ = strconv.Atoi(from)
+			if n > shard.batches {
+				n = shard.batches
+			}
+		}
+		return httpmock.NewJsonResponse(200, map[string]string{"cursor": fmt.Sprintf("%d-%d", shardId, n)})
+	}
+
+	cursor := query.Get("cursor")
+	n, _ := strconv.Atoi(strings.TrimPrefix(cursor, fmt.Sprintf("%d-", shardId)))
+	if query.Get("type") == "cursor_time" {
+		return httpmock.NewJsonResponse(200, map[string]int{"cursor_time": n * 10})
+	}
+	if n < shard.batches && query.Get("end_cursor") != cursor {
+		n++
+	}
+	resp := httpmock.NewBytesResponse(200, nil)
+	resp.Header.Set("X-Log-Cursor", fmt.Sprintf("%d-%d", shardId, n))
+	resp.Header.Set("X-Log-Count", "1")
+	resp.Header.Set("X-Log-Bodyrawsize", "0")
+	return resp, nil
+}
+
+// batchRecorder records the cursors of the batches read
+type batchRecorder struct {
+	mutex   sync.Mutex
+	cursors map[int][]string
+}
+
+func (r *batchRecorder) read(shardId int, logGroupList *sls.LogGroupList, plm *sls.PullLogMeta) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.cursors == nil {
+		r.cursors = make(map[int][]string)
+	}
+	r.cursors[shardId] = append(r.cursors[shardId], plm.NextCursor)
+	return nil
+}
+
+func (r *batchRecorder) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	n := 0
+	for _, cursors := range r.cursors {
+		n += len(cursors)
+	}
+	return n
+}
+
+func readAll(t *testing.T, reader *ShardReader) []string {
+	var cursors []string
+	for {
+		_, plm, err := reader.Next(context.Background())
+		if err != nil {
+			require.ErrorIs(t, err, io.EOF)
+			return cursors
+		}
+		cursors = append(cursors, plm.NextCursor)
+	}
+}
+
+func TestShardReaderTimeRange(t *testing.T) {
+	store, client := newFakeLogstore(t)
+	store.setShard(0, "readwrite", 5)
+	reader := NewShardReader(client, ReaderConfig{
+		Project:         "p",
+		Logstore:        "l",
+		CursorPosition:  SPECIAL_TIMER_CURSOR,
+		CursorStartTime: 1,
+		CursorEndTime:   3,
+	}, 0)
+	assert.Equal(t, []string{"0-2", "0-3"}, readAll(t, reader))
+	assert.Equal(t, "0-3", reader.Cursor())
+}
+
+func TestShardReaderReadOnly(t *testing.T) {
+	store, client := newFakeLogstore(t)
+	store.setShard(0, "readonly", 2)
+	reader := NewShardReader(client, ReaderConfig{Project: "p", Logstore: "l"}, 0)
+	assert.Equal(t, []string{"0-1", "0-2"}, readAll(t, reader))
+	store.setShard(2, "ReadOnly", 1)
+	reader = NewShardReader(client, ReaderConfig{Project: "p", Logstore: "l"}, 2)
+	assert.Equal(t, []string{"2-1"}, readAll(t, reader))
+
+	// waits for new data of the readwrite shard
+	store.setShard(1, "readwrite", 1)
+	reader = NewShardReader(client, ReaderConfig{Project: "p", Logstore: "l"}, 1)
+	_, plm, err := reader.Next(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "1-1", plm.NextCursor)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		store.setShard(1, "readwrite", 2)
+	}()
+	_, plm, err = reader.Next(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "1-2", plm.NextCursor)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, _, err = reader.Next(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestLogstoreTailerFollowShards(t *testing.T) {
+	store, client := newFakeLogstore(t)
+	store.setShard(0, "readonly", 2)
+	store.setShard(1, "readwrite", 2)
+	tailer := NewLogstoreTailer(client, ReaderConfig{
+		Project:                   "p",
+		Logstore:                  "l",
+		CursorPosition:            END_CURSOR,
+		ShardListIntervalInSecond: 1,
+	})
+
+	recorder := &batchRecorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- tailer.Run(ctx, recorder.read) }()
+
+	// the new data of shard 1 and the shard created by splitting are read
+	time.Sleep(100 * time.Millisecond)
+	store.setShard(1, "readwrite", 3)
+	store.setShard(2, "readwrite", 2)
+	assert.Eventually(t, func() bool { return recorder.count() == 3 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, map[int][]string{1: {"1-3"}, 2: {"2-1", "2-2"}}, recorder.cursors)
+}
+
+func TestLogstoreTailerParentFirst(t *testing.T) {
+	store, client := newFakeLogstore(t)
+	// shard 0 is split into 1 and 2, then 1 and 2 are merged into 3, shard 4 is not related
+	store.setShard(0, "readonly", 3)
+	store.setShardRange(0, "00", "ff")
+	store.setShard(1, "readonly", 2)
+	store.setShardRange(1, "00", "80")
+	store.setShard(2, "readonly", 2)
+	store.setShardRange(2, "80", "ff")
+	store.setShard(3, "readwrite", 2)
+	store.setShardRange(3, "00", "ff")
+	store.setShard(4, "readwrite", 1)
+	tailer := NewLogstoreTailer(client, ReaderConfig{Project: "p", Logstore: "l", CursorEndTime: 100})
+
+	var mutex sync.Mutex
+	var order []int
+	require.NoError(t, tailer.Run(context.Background(), func(shardId int, logGroupList *sls.LogGroupList, plm *sls.PullLogMeta) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		order = append(order, shardId)
+		return nil
+	}))
+	last := map[int]int{}
+	first := map[int]int{}
+	for i, shardId := range order {
+		if _, ok := first[shardId]; !ok {
+			first[shardId] = i
+		}
+		last[shardId] = i
+	}
+	assert.Less(t, last[0], first[1])
+	assert.Less(t, last[0], first[2])
+	assert.Less(t, last[1], first[3])
+	assert.Less(t, last[2], first[3])
+	assert.Len(t, order, 10)
+	assert.True(t, isParentShard(&sls.Shard{ShardID: 1, InclusiveBeginKey: "00", ExclusiveBeginKey: "80"},
+		&sls.Shard{ShardID: 3, InclusiveBeginKey: "7f", ExclusiveBeginKey: "ff"}))
+	assert.False(t, isParentShard(&sls.Shard{ShardID: 1, InclusiveBeginKey: "00", ExclusiveBeginKey: "80"},
+		&sls.Shard{ShardID: 3, InclusiveBeginKey: "80", ExclusiveBeginKey: "ff"}))
+}
+
+func TestLogstoreTailerEndTime(t *testing.T) {
+	store, client := newFakeLogstore(t)
+	store.setShard(0, "readonly", 1)
+	store.setShard(1, "readwrite", 3)
+	store.setShard(2, "readwrite", 3)
+	tailer := NewLogstoreTailer(client, ReaderConfig{Project: "p", Logstore: "l", CursorEndTime: 2})
+
+	recorder := &batchRecorder{}
+	require.NoError(t, tailer.Run(context.Background(), recorder.read))
+	assert.Equal(t, map[int][]string{
+		0: {"0-1"},
+		1: {"1-1", "1-2"},
+		2: {"2-1", "2-2"},
+	}, recorder.cursors)
+}
+
+func TestLogstoreTailerError(t *testing.T) {
+	store, client := newFakeLogstore(t)
+	store.setShard(0, "readwrite", 3)
+	store.setShard(1, "readwrite", 3)
+	tailer := NewLogstoreTailer(client, ReaderConfig{Project: "p", Logstore: "l"})
+
+	errStop := errors.New("stop")
+	err := tailer.Run(context.Background(), func(shardId int, logGroupList *sls.LogGroupList, plm *sls.PullLogMeta) error {
+		if plm.NextCursor == "1-2" {
+			return errStop
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, errStop)
+}
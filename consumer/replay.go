@@ -0,0 +1,144 @@
+package consumerLibrary
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+const defaultReplayCheckInterval = 10 * time.Second
+
+// ReplayProgress is the progress of consuming the logstore until CursorEndTime
+type ReplayProgress struct {
+	Shards          []int // all the shards of the logstore, including the ones created by splitting or merging
+	CompletedShards []int // the shards consumed until CursorEndTime, by this consumer or the others of the consumer group
+}
+
+// Completed returns whether all the shards are consumed until CursorEndTime
+func (p ReplayProgress) Completed() bool {
+	return len(p.Shards) > 0 && len(p.CompletedShards) == len(p.Shards)
+}
+
+// replayTracker tracks the shards consumed until CursorEndTime
+type replayTracker struct {
+	interval   time.Duration
+	lastCheck  time.Time
+	endCursors map[int]string // cached end cursors of the shards
+	completed  map[int]bool
+
+	mutex    sync.Mutex
+	progress ReplayProgress
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newReplayTracker() *replayTracker {
+	return &replayTracker{
+		interval:   defaultReplayCheckInterval,
+		endCursors: make(map[int]string),
+		completed:  make(map[int]bool),
+		done:       make(chan struct{}),
+	}
+}
+
+// Done returns a channel closed when all the shards of the logstore are consumed until CursorEndTime,
+// including the shards created by splitting or merging. It is never closed if CursorEndTime is not set.
+// The shards are still held by the worker after the channel is closed, call StopAndWait to release them.
+func (consumerWorker *ConsumerWorker) Done() <-chan struct{} {
+	return consumerWorker.replay.done
+}
+
+// Progress returns the progress of consuming the logstore until CursorEndTime, it is updated every 10 seconds
+func (consumerWorker *ConsumerWorker) Progress() ReplayProgress {
+	replay := consumerWorker.replay
+	replay.mutex.Lock()
+	defer replay.mutex.Unlock()
+	return ReplayProgress{
+		Shards:          append([]int(nil), replay.progress.Shards...),
+		CompletedShards: append([]int(nil), replay.progress.CompletedShards...),
+	}
+}
+
+// Run starts the worker and waits until all the shards are consumed until CursorEndTime, then stops the worker.
+// If ctx is done before that, it stops the worker and returns the error of ctx.
+// If the worker is stopped by FailureActionStop, it returns the error of the batch failed to be processed.
+func (consumerWorker *ConsumerWorker) Run(ctx context.Context) error {
+	consumerWorker.Start()
+	select {
+	case <-consumerWorker.Done():
+		consumerWorker.StopAndWait()
+		return nil
+	case <-consumerWorker.failed:
+		consumerWorker.StopAndWait()
+		return consumerWorker.Err()
+	case <-ctx.Done():
+		consumerWorker.StopAndWait()
+		return ctx.Err()
+	}
+}
+
+// checkReplayCompleted updates the progress by the shards consumed by this worker,
+// and the checkpoints of the shards held by the others
+func (consumerWorker *ConsumerWorker) checkReplayCompleted() {
+	replay := consumerWorker.replay
+	if consumerWorker.client.option.CursorEndTime == 0 || time.Since(replay.lastCheck) < replay.interval {
+		return
+	}
+	replay.lastCheck = time.Now()
+	shards, err := consumerWorker.client.listShards()
+	if err != nil {
+		level.Warn(consumerWorker.Logger).Log("msg", "failed to list shards to check the replay progress", "err", err)
+		return
+	}
+
+	progress := ReplayProgress{}
+	store := consumerWorker.client.checkpointStore()
+	for _, shard := range shards {
+		shardId := shard.ShardID
+		progress.Shards = append(progress.Shards, shardId)
+		if !replay.completed[shardId] {
+			replay.completed[shardId] = consumerWorker.isShardEndReached(shardId, store)
+		}
+		if replay.completed[shardId] {
+			progress.CompletedShards = append(progress.CompletedShards, shardId)
+		}
+	}
+	sort.Ints(progress.Shards)
+	sort.Ints(progress.CompletedShards)
+
+	replay.mutex.Lock()
+	replay.progress = progress
+	replay.mutex.Unlock()
+	if progress.Completed() {
+		replay.doneOnce.Do(func() {
+			level.Info(consumerWorker.Logger).Log("msg", "all the shards are consumed until the end time", "shards", fmt.Sprintf("%v", progress.Shards))
+			close(replay.done)
+		})
+	}
+}
+
+func (consumerWorker *ConsumerWorker) isShardEndReached(shardId int, store CheckpointStore) bool {
+	if consumer, ok := consumerWorker.shardConsumer.Load(shardId); ok && consumer.(*ShardConsumerWorker).isEndReached() {
+		return true
+	}
+	endCursor, ok := consumerWorker.replay.endCursors[shardId]
+	if !ok {
+		var err error
+		endCursor, err = consumerWorker.client.getCursor(shardId, fmt.Sprintf("%v", consumerWorker.client.option.CursorEndTime))
+		if err != nil {
+			level.Warn(consumerWorker.Logger).Log("msg", "failed to get the end cursor", "shard", shardId, "err", err)
+			return false
+		}
+		consumerWorker.replay.endCursors[shardId] = endCursor
+	}
+	checkpoint, err := store.GetCheckpoint(shardId)
+	if err != nil {
+		level.Warn(consumerWorker.Logger).Log("msg", "failed to get the checkpoint", "shard", shardId, "err", err)
+		return false
+	}
+	return checkpoint == endCursor
+}
@@ -0,0 +1,181 @@
+package consumerLibrary
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+// fakeConsumerGroup serves the checkpoints of the consumer group, and holds no shards in heartbeats
+type fakeConsumerGroup struct {
+	mutex       sync.Mutex
+	checkpoints map[int]*sls.ConsumerGroupCheckPoint
+}
+
+func registerFakeConsumerGroup(transport *httpmock.MockTransport, name string) *fakeConsumerGroup {
+	group := &fakeConsumerGroup{checkpoints: make(map[int]*sls.ConsumerGroupCheckPoint)}
+	pattern := fmt.Sprintf(`=~/logstores/l/consumergroups/%s(\?|$)`, name)
+	transport.RegisterResponder("GET", pattern, func(req *http.Request) (*http.Response, error) {
+		group.mutex.Lock()
+		defer group.mutex.Unlock()
+		checkpoints := []*sls.ConsumerGroupCheckPoint{}
+		for _, checkpoint := range group.checkpoints {
+			checkpoints = append(checkpoints, checkpoint)
+		}
+		return httpmock.NewJsonResponse(200, checkpoints)
+	})
+	transport.RegisterResponder("POST", pattern, func(req *http.Request) (*http.Response, error) {
+		if req.URL.Query().Get("type") == "checkpoint" {
+			var body struct {
+				Shard      int    `json:"shard"`
+				Checkpoint string `json:"checkpoint"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			group.setConsumerCheckpoint(body.Shard, body.Checkpoint, req.URL.Query().Get("consumer"), time.Now())
+		}
+		return httpmock.NewJsonResponse(200, []int{})
+	})
+	return group
+}
+
+// setCheckpoint sets the checkpoint saved by consumer "c" just now
+func (g *fakeConsumerGroup) setCheckpoint(shard int, checkpoint string) {
+	g.setConsumerCheckpoint(shard, checkpoint, "c", time.Now())
+}
+
+func (g *fakeConsumerGroup) setConsumerCheckpoint(shard int, checkpoint, consumer string, updateTime time.Time) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.checkpoints[shard] = &sls.ConsumerGroupCheckPoint{
+		ShardID:    shard,
+		CheckPoint: checkpoint,
+		Consumer:   consumer,
+		UpdateTime: updateTime.UnixMicro(),
+	}
+}
+
+func (g *fakeConsumerGroup) get(shard int) *sls.ConsumerGroupCheckPoint {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.checkpoints[shard]
+}
+
+func (g *fakeConsumerGroup) getCheckpoint(shard int) string {
+	if checkpoint := g.get(shard); checkpoint != nil {
+		return checkpoint.CheckPoint
+	}
+	return ""
+}
+
+func newReplayTestClient(transport *httpmock.MockTransport) *ConsumerClient {
+	client := newMockedConsumerClient(transport)
+	client.option.CursorPosition = BEGIN_CURSOR
+	client.option.CursorEndTime = 2
+	return client
+}
+
+func TestShardConsumerWorkerEndReached(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	logstore := registerFakeLogstore(transport)
+	logstore.setShard(0, "readwrite", 3)
+	group := registerFakeConsumerGroup(transport, "cg")
+
+	var processed atomic.Int32
+	worker := newTestShardConsumerWorker(context.Background(), newReplayTestClient(transport),
+		ContextProcessFunc(func(ctx context.Context, shard int, logGroupList *sls.LogGroupList, tracker CheckPointTracker) (string, error) {
+			processed.Inc()
+			return "", tracker.SaveCheckPoint(false)
+		}))
+	worker.ensureStarted()
+	assert.Eventually(t, worker.isEndReached, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), processed.Load())
+	assert.Equal(t, "0-2", group.getCheckpoint(0))
+
+	worker.shutdown()
+	assert.Eventually(t, worker.isStopped, 5*time.Second, 10*time.Millisecond)
+}
+
+func newReplayTestWorker(transport *httpmock.MockTransport) *ConsumerWorker {
+	client := newReplayTestClient(transport)
+	ctx, cancel := context.WithCancel(context.Background())
+	worker := &ConsumerWorker{
+		consumerHeatBeat:   initConsumerHeatBeat(client, log.NewNopLogger()),
+		client:             client,
+		workerShutDownFlag: atomic.NewBool(false),
+		processor:          ContextProcessFunc(nil),
+		Logger:             log.NewNopLogger(),
+		ioThrottler:        newSimpleIoThrottler(1),
+		ctx:                ctx,
+		cancel:             cancel,
+		replay:             newReplayTracker(),
+		failure:            atomic.NewError(nil),
+		failed:             make(chan struct{}),
+	}
+	worker.replay.interval = 0
+	return worker
+}
+
+func TestReplayProgress(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	logstore := registerFakeLogstore(transport)
+	logstore.setShard(0, "readonly", 1)
+	logstore.setShard(1, "readwrite", 3)
+	logstore.setShard(2, "readwrite", 3)
+	group := registerFakeConsumerGroup(transport, "cg")
+	group.setCheckpoint(1, "1-2")
+	group.setCheckpoint(2, "2-1")
+
+	worker := newReplayTestWorker(transport)
+	consumer := worker.getShardConsumer(0)
+	consumer.endReached.Store(true)
+
+	worker.checkReplayCompleted()
+	progress := worker.Progress()
+	assert.Equal(t, []int{0, 1, 2}, progress.Shards)
+	assert.Equal(t, []int{0, 1}, progress.CompletedShards)
+	assert.False(t, progress.Completed())
+	select {
+	case <-worker.Done():
+		t.Fatal("shard 2 is not consumed until the end time")
+	default:
+	}
+
+	// shard 0 is completed even if it is revoked
+	worker.shardConsumer.Delete(0)
+	group.setCheckpoint(2, "2-2")
+	worker.checkReplayCompleted()
+	assert.True(t, worker.Progress().Completed())
+	select {
+	case <-worker.Done():
+	default:
+		t.Fatal("all the shards are consumed until the end time")
+	}
+	require.NoError(t, worker.Run(context.Background()))
+}
+
+func TestReplayWithoutEndTime(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	registerFakeLogstore(transport)
+	registerFakeConsumerGroup(transport, "cg")
+	worker := newReplayTestWorker(transport)
+	worker.client.option.CursorEndTime = 0
+
+	worker.checkReplayCompleted()
+	assert.Empty(t, worker.Progress().Shards)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, worker.Run(ctx), context.DeadlineExceeded)
+}
@@ -18,13 +18,19 @@ type MonitorMetrics struct {
 
 	processFailedCount atomic.Int64
 	processHistogram   internal.TimeHistogram // in us
+
+	processRetryCount   atomic.Int64
+	processSkippedCount atomic.Int64 // including the batches sent to the dead letter sink
+	deadLetterCount     atomic.Int64
 }
 
 type ShardMonitor struct {
-	shard          int
-	reportInterval time.Duration
-	lastReportTime time.Time
-	metrics        atomic.Value // *MonitorMetrics
+	shard           int
+	reportInterval  time.Duration
+	lastReportTime  time.Time
+	metrics         atomic.Value // *MonitorMetrics
+	lastFetchTime   atomic.Int64 // in unix nano, of the last successful fetch
+	lastProcessTime atomic.Int64 // in unix nano, of the last successful process
 }
 
 func newShardMonitor(shard int, reportInterval time.Duration) *ShardMonitor {
@@ -43,6 +49,7 @@ func (m *ShardMonitor) RecordFetchRequest(plm *sls.PullLogMeta, err error, start
 		metrics.fetchReqFailedCount.Inc()
 	} else {
 		metrics.logRawSize.Add(int64(plm.RawSize))
+		m.lastFetchTime.Store(time.Now().UnixNano())
 	}
 	metrics.fetchLogHistogram.AddSample(float64(time.Since(start).Microseconds()))
 }
@@ -51,10 +58,29 @@ func (m *ShardMonitor) RecordProcess(err error, start time.Time) {
 	metrics := m.metrics.Load().(*MonitorMetrics)
 	if err != nil {
 		metrics.processFailedCount.Inc()
+	} else {
+		m.lastProcessTime.Store(time.Now().UnixNano())
 	}
 	metrics.processHistogram.AddSample(float64(time.Since(start).Microseconds()))
 }
 
+// RecordRetry records a retry of the batch failed to be processed
+func (m *ShardMonitor) RecordRetry() {
+	m.metrics.Load().(*MonitorMetrics).processRetryCount.Inc()
+}
+
+// RecordSkip records a batch skipped by the FailurePolicy
+func (m *ShardMonitor) RecordSkip() {
+	m.metrics.Load().(*MonitorMetrics).processSkippedCount.Inc()
+}
+
+// RecordDeadLetter records a batch sent to the dead letter sink and skipped
+func (m *ShardMonitor) RecordDeadLetter() {
+	metrics := m.metrics.Load().(*MonitorMetrics)
+	metrics.processSkippedCount.Inc()
+	metrics.deadLetterCount.Inc()
+}
+
 func (m *ShardMonitor) getAndResetMetrics() *MonitorMetrics {
 	// we dont need cmp and swap, only one thread would call m.metrics.Store
 	old := m.metrics.Load().(*MonitorMetrics)
@@ -66,14 +92,42 @@ func (m *ShardMonitor) shouldReport() bool {
 	return time.Since(m.lastReportTime) >= m.reportInterval
 }
 
-func (m *ShardMonitor) reportByLogger(logger log.Logger) {
+// reportByLogger logs the metrics and the status, the lag is only logged if withLag is true
+func (m *ShardMonitor) reportByLogger(logger log.Logger, status ShardStatus, withLag bool) {
 	m.lastReportTime = time.Now()
 	metrics := m.getAndResetMetrics()
-	level.Info(logger).Log("msg", "report status",
+	keyvals := []interface{}{"msg", "report status",
 		"fetchFailed", metrics.fetchReqFailedCount.Load(),
 		"logRawSize", metrics.logRawSize.Load(),
 		"processFailed", metrics.processFailedCount.Load(),
+		"processRetry", metrics.processRetryCount.Load(),
+		"processSkipped", metrics.processSkippedCount.Load(),
+		"deadLetter", metrics.deadLetterCount.Load(),
 		"fetch", metrics.fetchLogHistogram.String(),
 		"process", metrics.processHistogram.String(),
-	)
+		"state", status.State,
+	}
+	if withLag {
+		keyvals = append(keyvals, "lagSeconds", status.LagSeconds, "bytesBehind", status.BytesBehind)
+	}
+	level.Info(logger).Log(keyvals...)
+	if status.Err != nil {
+		level.Warn(logger).Log("msg", "failed to compute the lag", "err", status.Err)
+	}
+}
+
+func (m *ShardMonitor) getLastFetchTime() time.Time {
+	return unixNanoTime(m.lastFetchTime.Load())
+}
+
+func (m *ShardMonitor) getLastProcessTime() time.Time {
+	return unixNanoTime(m.lastProcessTime.Load())
+}
+
+// unixNanoTime returns zero time if it is never recorded
+func unixNanoTime(nano int64) time.Time {
+	if nano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nano)
 }
@@ -1,6 +1,8 @@
 package consumerLibrary
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"runtime"
 	"sync"
@@ -25,7 +27,7 @@ const (
 type ShardConsumerWorker struct {
 	client                    *ConsumerClient
 	consumerCheckPointTracker *DefaultCheckPointTracker
-	processor                 Processor
+	processor                 ContextProcessor
 	shardId                   int
 	monitor                   *ShardMonitor
 
@@ -33,12 +35,23 @@ type ShardConsumerWorker struct {
 	lastCheckpointSaveTime time.Time
 	shutDownFlag           *atomic.Bool
 	stopped                *atomic.Bool
+	endReached             *atomic.Bool // consumed until the endCursor
+	state                  *atomic.String
+	caughtUp               *atomic.Bool  // no more data on the last fetch
+	fetchedBytes           *atomic.Int64 // the raw size of the logs fetched
+	lagEstimator           lagEstimator
+	failure                *atomic.Error // the error the shard is stopped with by FailureActionStop
 	startOnceFlag          sync.Once
 	ioThrottler            ioThrottler
 	endCursor              string
+	ctx                    context.Context // cancelled when the shard is unassigned, revoked or shutting down
+	cancel                 context.CancelFunc
+	parallel               *parallelProcessor // nil if the batches are processed one by one
+	prefetcher             *prefetcher        // nil if the batches are fetched after the previous one is processed
 }
 
-func newShardConsumerWorker(shardId int, consumerClient *ConsumerClient, consumerHeartBeat *ConsumerHeartBeat, processor Processor, logger log.Logger, ioThrottler ioThrottler) *ShardConsumerWorker {
+func newShardConsumerWorker(ctx context.Context, shardId int, consumerClient *ConsumerClient, consumerHeartBeat *ConsumerHeartBeat, processor ContextProcessor, logger log.Logger, ioThrottler ioThrottler, prefetchBudget *memoryBudget) *ShardConsumerWorker {
+	ctx, cancel := context.WithCancel(ctx)
 	shardConsumeWorker := &ShardConsumerWorker{
 		processor:                 processor,
 		consumerCheckPointTracker: initConsumerCheckpointTracker(shardId, consumerClient, consumerHeartBeat, logger),
@@ -47,9 +60,26 @@ func newShardConsumerWorker(shardId int, consumerClient *ConsumerClient, consume
 		logger:                    log.With(logger, "shard", shardId),
 		shutDownFlag:              atomic.NewBool(false),
 		stopped:                   atomic.NewBool(false),
+		endReached:                atomic.NewBool(false),
+		state:                     atomic.NewString(INITIALIZING),
+		caughtUp:                  atomic.NewBool(false),
+		fetchedBytes:              atomic.NewInt64(0),
+		failure:                   atomic.NewError(nil),
 		lastCheckpointSaveTime:    time.Now(),
 		monitor:                   newShardMonitor(shardId, time.Minute),
 		ioThrottler:               ioThrottler,
+		ctx:                       ctx,
+		cancel:                    cancel,
+	}
+	if consumerClient.option.ShardProcessConcurrency > 1 {
+		shardConsumeWorker.parallel = newParallelProcessor(shardConsumeWorker, consumerClient.option.ShardProcessConcurrency)
+	}
+	if consumerClient.option.PrefetchDepth > 0 && prefetchBudget != nil {
+		shardConsumeWorker.prefetcher = newPrefetcher(shardConsumeWorker, prefetchBudget, consumerClient.option.PrefetchDepth)
+	}
+	shardConsumeWorker.consumerCheckPointTracker.onRevoked = func() {
+		level.Warn(shardConsumeWorker.logger).Log("msg", "shard is revoked, cancel the processing and stop saving checkpoint")
+		shardConsumeWorker.shutdown()
 	}
 	return shardConsumeWorker
 }
@@ -64,35 +94,103 @@ func (c *ShardConsumerWorker) runLoop() {
 	level.Info(c.logger).Log("msg", "runLoop started")
 	defer func() {
 		c.recoverIfPanic("runLoop panic")
+		if c.prefetcher != nil {
+			c.prefetcher.stop()
+		}
+		if c.parallel != nil {
+			c.parallel.wait()
+		}
 		c.doShutDown()
 	}()
 
 	cursor, endCursor := c.getInitCursor()
 	c.endCursor = endCursor
 	level.Info(c.logger).Log("msg", "runLoop got init cursor", "cursor", cursor, "endCursor", endCursor)
+	if c.prefetcher != nil {
+		c.prefetcher.endCursor = endCursor
+		c.prefetchLoop(cursor)
+		return
+	}
 
 	for !c.shutDownFlag.Load() {
 		lastFetchTime := time.Now()
+		c.state.Store(PULLING)
 		logGroupList, plm, err := c.fetchLogs(cursor, endCursor)
 		if err != nil {
 			continue
 		}
 
-		cursor = c.callProcess(logGroupList, plm)
+		c.state.Store(PROCESSING)
+		cursor = c.process(cursor, logGroupList, plm)
 		if c.shutDownFlag.Load() {
 			break
 		}
 
 		// we already reach consumer's endCursor, but we can't release the shard to aviod shard re-assignment.
 		// just sleep longer to reduce meanless data fetching.
-		if endCursor != "" && cursor == endCursor {
-			time.Sleep(time.Second * 5)
+		if c.checkEndReached(cursor) {
+			sleepWithContext(c.ctx, time.Second*5)
 		}
 
 		c.sleepUtilNextFetch(lastFetchTime, plm)
 	}
 }
 
+// prefetchLoop processes the batches fetched in the background, the prefetcher paces the fetching instead
+func (c *ShardConsumerWorker) prefetchLoop(cursor string) {
+	for !c.shutDownFlag.Load() {
+		c.state.Store(PULLING)
+		batch := c.prefetcher.fetch(cursor)
+		if batch == nil {
+			break
+		}
+		c.trackFetched(cursor, batch.plm)
+		c.state.Store(PROCESSING)
+		cursor = c.process(cursor, batch.logGroupList, batch.plm)
+		c.prefetcher.processed(batch)
+		c.checkEndReached(cursor)
+	}
+}
+
+// checkEndReached returns whether the shard is consumed until the endCursor, the checkpoint is flushed when it is reached
+func (c *ShardConsumerWorker) checkEndReached(cursor string) bool {
+	if c.endCursor == "" || cursor != c.endCursor {
+		return false
+	}
+	if c.endReached.Load() {
+		return true
+	}
+	if c.parallel != nil {
+		c.parallel.wait()
+		// the batches are fetched again from the rollback checkpoint
+		if c.parallel.hasRollback() {
+			return false
+		}
+	}
+	if !c.client.option.AutoCommitDisabled {
+		c.consumerCheckPointTracker.flushCheckPoint()
+		c.lastCheckpointSaveTime = time.Now()
+	}
+	level.Info(c.logger).Log("msg", "shard is consumed until the end cursor", "endCursor", c.endCursor)
+	c.endReached.Store(true)
+	return true
+}
+
+func (c *ShardConsumerWorker) isEndReached() bool {
+	return c.endReached.Load()
+}
+
+// process processes the batch, or dispatches it if the batches are processed concurrently,
+// it returns the cursor to fetch from
+func (c *ShardConsumerWorker) process(cursor string, logGroupList *sls.LogGroupList, plm *sls.PullLogMeta) string {
+	if c.parallel != nil {
+		cursor = c.parallel.dispatch(cursor, logGroupList, plm)
+		c.saveCheckPointIfNeeded()
+		return cursor
+	}
+	return c.callProcess(logGroupList, plm)
+}
+
 func (consumer *ShardConsumerWorker) getInitCursor() (string, string) {
 	for !consumer.shutDownFlag.Load() {
 		beginCursor, endCursor, err := consumer.consumerInitializeTask()
@@ -105,6 +203,20 @@ func (consumer *ShardConsumerWorker) getInitCursor() (string, string) {
 }
 
 func (c *ShardConsumerWorker) fetchLogs(cursor string, endCursor string) (logGroupList *sls.LogGroupList, plm *sls.PullLogMeta, err error) {
+	logGroupList, plm, err = c.pullLogs(cursor, endCursor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.trackFetched(cursor, plm)
+	if cursor == plm.NextCursor { // already reach end of shard
+		time.Sleep(noProgressSleepTime)
+	}
+	return logGroupList, plm, nil
+}
+
+// pullLogs pulls logs from the cursor, the ioThrottler is only held during the request
+func (c *ShardConsumerWorker) pullLogs(cursor string, endCursor string) (logGroupList *sls.LogGroupList, plm *sls.PullLogMeta, err error) {
 	c.ioThrottler.Acquire()
 	defer c.ioThrottler.Release()
 
@@ -116,45 +228,34 @@ func (c *ShardConsumerWorker) fetchLogs(cursor string, endCursor string) (logGro
 		time.Sleep(fetchFailedSleepTime)
 		return nil, nil, err
 	}
+	return logGroupList, plm, nil
+}
 
-	c.consumerCheckPointTracker.setCurrentCursor(cursor)
-	c.consumerCheckPointTracker.setNextCursor(plm.NextCursor)
+// trackFetched tracks the cursors of the batch to be processed
+func (c *ShardConsumerWorker) trackFetched(cursor string, plm *sls.PullLogMeta) {
+	c.caughtUp.Store(cursor == plm.NextCursor)
+	c.fetchedBytes.Add(int64(plm.RawSize))
+	// the cursors of the batches processed concurrently are tracked by themselves,
+	// and the next cursor of the shard is the watermark
+	if c.parallel == nil {
+		c.consumerCheckPointTracker.setCurrentCursor(cursor)
+		c.consumerCheckPointTracker.setNextCursor(plm.NextCursor)
+	}
 
 	if cursor == plm.NextCursor { // already reach end of shard
 		c.saveCheckPointIfNeeded()
-		time.Sleep(noProgressSleepTime)
 	}
-	return logGroupList, plm, nil
 }
 
+// callProcess processes the batch by the FailurePolicy, it returns the cursor to fetch from
 func (c *ShardConsumerWorker) callProcess(logGroupList *sls.LogGroupList, plm *sls.PullLogMeta) (nextCursor string) {
-	for {
-		start := time.Now()
-		rollBackCheckpoint, err := c.processInternal(logGroupList)
-		c.monitor.RecordProcess(err, start)
-
-		c.saveCheckPointIfNeeded()
-		if err != nil {
-			level.Error(c.logger).Log("msg", "process func returns an error", "err", err)
-		}
-		if rollBackCheckpoint != "" {
-			level.Warn(c.logger).Log("msg", "Rollback checkpoint by user",
-				"rollBackCheckpoint", rollBackCheckpoint)
-			return rollBackCheckpoint
-		}
-		if err == nil {
-			return plm.NextCursor
-		}
-		// if process failed and shutting down, just quit
-		if c.shutDownFlag.Load() {
-			level.Warn(c.logger).Log("msg", "shutting down and last process failed, just quit")
-			return plm.NextCursor
-		}
-		time.Sleep(processFailedSleepTime)
+	if rollBackCheckpoint := c.processWithRetry(logGroupList, c.consumerCheckPointTracker); rollBackCheckpoint != "" {
+		return rollBackCheckpoint
 	}
+	return plm.NextCursor
 }
 
-func (c *ShardConsumerWorker) processInternal(logGroupList *sls.LogGroupList) (rollBackCheckpoint string, err error) {
+func (c *ShardConsumerWorker) processInternal(logGroupList *sls.LogGroupList, checkpointTracker CheckPointTracker) (rollBackCheckpoint string, err error) {
 	defer func() {
 		if r := c.recoverIfPanic("panic in your process function"); r != nil {
 			err = fmt.Errorf("panic when process: %v", r)
@@ -167,15 +268,16 @@ func (c *ShardConsumerWorker) processInternal(logGroupList *sls.LogGroupList) (r
 	if logGroupList.LogGroups == nil {
 		logGroupList.LogGroups = make([]*sls.LogGroup, 0)
 	}
-	return c.processor.Process(c.shardId, logGroupList, c.consumerCheckPointTracker)
+	return c.processor.ProcessWithContext(c.ctx, c.shardId, logGroupList, checkpointTracker)
 }
 
 // call user shutdown func and flush checkpoint
 func (c *ShardConsumerWorker) doShutDown() {
+	c.state.Store(SHUTTING_DOWN)
 	level.Info(c.logger).Log("msg", "begin to shutdown, invoking processor.shutdown")
 	for {
 		err := c.processor.Shutdown(c.consumerCheckPointTracker) // todo: should we catch panic here?
-		if err == nil {
+		if err == nil || errors.Is(err, ErrShardRevoked) {
 			break
 		}
 		level.Error(c.logger).Log("msg", "processor.shutdown finished with error", "err", err)
@@ -189,18 +291,29 @@ func (c *ShardConsumerWorker) doShutDown() {
 		if err == nil {
 			break
 		}
+		if errors.Is(err, ErrShardRevoked) {
+			level.Warn(c.logger).Log("msg", "shard is revoked, skip flushing checkpoint when shutting down")
+			break
+		}
 		level.Error(c.logger).Log("msg", "failed to flush checkpoint when shutting down", "err", err)
 		time.Sleep(flushCheckPointFailedSleepTime)
 	}
 	level.Info(c.logger).Log("msg", "shutting down completed, bye")
+	c.state.Store(SHUTDOWN_COMPLETE)
 	c.stopped.Store(true)
 }
 
 // todo: refine sleep time, make it more reasonable
 func (c *ShardConsumerWorker) sleepUtilNextFetch(lastFetchSuccessTime time.Time, plm *sls.PullLogMeta) {
+	// negative or zero sleepTime is ok
+	time.Sleep(c.nextFetchDelay(lastFetchSuccessTime, plm))
+}
+
+// nextFetchDelay returns how long to wait before the next fetch, it is longer if less data is fetched last time
+func (c *ShardConsumerWorker) nextFetchDelay(lastFetchSuccessTime time.Time, plm *sls.PullLogMeta) time.Duration {
 	sinceLastFetch := time.Since(lastFetchSuccessTime)
 	if sinceLastFetch > time.Duration(c.client.option.DataFetchIntervalInMs)*time.Millisecond {
-		return
+		return 0
 	}
 
 	lastFetchRawSize := plm.RawSize
@@ -211,19 +324,15 @@ func (c *ShardConsumerWorker) sleepUtilNextFetch(lastFetchSuccessTime time.Time,
 	}
 
 	if lastFetchGroupCount >= c.client.option.MaxFetchLogGroupCount || lastFetchRawSize >= 4*1024*1024 {
-		return
+		return 0
 	}
-	// negative or zero sleepTime is ok
 	if lastFetchGroupCount < 100 && lastFetchRawSize < 1024*1024 {
-		time.Sleep(500*time.Millisecond - sinceLastFetch)
-		return
+		return 500*time.Millisecond - sinceLastFetch
 	}
 	if lastFetchGroupCount < 500 && lastFetchRawSize < 2*1024*1024 {
-		time.Sleep(200*time.Millisecond - sinceLastFetch)
-		return
+		return 200*time.Millisecond - sinceLastFetch
 	}
-
-	time.Sleep(50*time.Millisecond - sinceLastFetch)
+	return 50*time.Millisecond - sinceLastFetch
 }
 
 func (c *ShardConsumerWorker) saveCheckPointIfNeeded() {
@@ -239,6 +348,7 @@ func (c *ShardConsumerWorker) saveCheckPointIfNeeded() {
 func (c *ShardConsumerWorker) shutdown() {
 	level.Info(c.logger).Log("msg", "shutting down by others")
 	c.shutDownFlag.Store(true)
+	c.cancel()
 }
 
 func (c *ShardConsumerWorker) isStopped() bool {
@@ -262,5 +372,6 @@ func (c *ShardConsumerWorker) shouldReportMetrics() bool {
 }
 
 func (c *ShardConsumerWorker) reportMetrics() {
-	c.monitor.reportByLogger(c.logger)
+	reportLag := c.client.option.ReportLagInRuntimeMetrics
+	c.monitor.reportByLogger(c.logger, c.status(reportLag), reportLag)
 }
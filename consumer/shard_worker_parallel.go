@@ -0,0 +1,198 @@
+package consumerLibrary
+
+import (
+	"sync"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log/level"
+)
+
+// maxWindowPerConcurrency limits the batches dispatched after the watermark to this times the concurrency,
+// so that the window is bounded if the processor doesn't save the checkpoint of some batches
+const maxWindowPerConcurrency = 16
+
+// parallelProcessor processes the successive batches fetched from a shard concurrently,
+// the checkpoint only advances to the highest cursor below which all the batches are completed (the watermark),
+// so that no data is skipped if the consumer fails.
+type parallelProcessor struct {
+	worker        *ShardConsumerWorker
+	slots         chan struct{}
+	waitGroup     sync.WaitGroup
+	maxWindow     int
+	windowChanged chan struct{} // notifies the dispatching waiting for the window, there is only one
+
+	mutex    sync.Mutex
+	window   []*batchCheckPointTracker // the batches dispatched after the watermark, in fetch order
+	rollback string                    // the first rollback checkpoint returned by the processor
+}
+
+func newParallelProcessor(worker *ShardConsumerWorker, concurrency int) *parallelProcessor {
+	return &parallelProcessor{
+		worker:        worker,
+		slots:         make(chan struct{}, concurrency),
+		maxWindow:     concurrency * maxWindowPerConcurrency,
+		windowChanged: make(chan struct{}, 1),
+	}
+}
+
+// dispatch processes the batch in a new goroutine after one of the batches in process is finished if there are too many,
+// it returns the cursor to fetch from.
+// If the processor returned a rollback checkpoint, it waits for the batches in process and returns the checkpoint instead.
+// If the window is full, it waits until the watermark advances, and returns the cursor of the batch without processing it
+// if the worker is shut down in the meantime.
+func (p *parallelProcessor) dispatch(cursor string, logGroupList *sls.LogGroupList, plm *sls.PullLogMeta) string {
+	p.slots <- struct{}{}
+	if !p.waitForWindow() {
+		<-p.slots
+		return cursor
+	}
+	if rollback := p.takeRollback(); rollback != "" {
+		<-p.slots
+		return rollback
+	}
+
+	batch := &batchCheckPointTracker{
+		parallel:      p,
+		tracker:       p.worker.consumerCheckPointTracker,
+		currentCursor: cursor,
+		nextCursor:    plm.NextCursor,
+	}
+	p.mutex.Lock()
+	p.window = append(p.window, batch)
+	p.mutex.Unlock()
+
+	p.waitGroup.Add(1)
+	go func() {
+		defer func() {
+			<-p.slots
+			p.waitGroup.Done()
+		}()
+		p.process(batch, logGroupList)
+	}()
+	return plm.NextCursor
+}
+
+// waitForWindow waits until there is room in the window or a rollback checkpoint is returned,
+// it returns false if the worker is shut down in the meantime
+func (p *parallelProcessor) waitForWindow() bool {
+	for warned := false; ; warned = true {
+		p.mutex.Lock()
+		full := len(p.window) >= p.maxWindow && p.rollback == ""
+		p.mutex.Unlock()
+		if !full {
+			return true
+		}
+		if !warned {
+			level.Warn(p.worker.logger).Log("msg", "too many batches are not completed, wait for the checkpoint of the oldest one to be saved",
+				"maxWindow", p.maxWindow)
+		}
+		select {
+		case <-p.windowChanged:
+		case <-p.worker.ctx.Done():
+			return false
+		}
+	}
+}
+
+func (p *parallelProcessor) notifyWindowChanged() {
+	select {
+	case p.windowChanged <- struct{}{}:
+	default:
+	}
+}
+
+// process processes the batch by the FailurePolicy, the same as callProcess
+func (p *parallelProcessor) process(batch *batchCheckPointTracker, logGroupList *sls.LogGroupList) {
+	if rollBackCheckpoint := p.worker.processWithRetry(logGroupList, batch); rollBackCheckpoint != "" {
+		p.mutex.Lock()
+		if p.rollback == "" {
+			p.rollback = rollBackCheckpoint
+		}
+		p.mutex.Unlock()
+		p.notifyWindowChanged()
+	}
+}
+
+// takeRollback returns the rollback checkpoint after the batches in process are finished,
+// the batches after the watermark are dropped and fetched again from the checkpoint
+func (p *parallelProcessor) takeRollback() string {
+	p.mutex.Lock()
+	rollback := p.rollback
+	p.mutex.Unlock()
+	if rollback == "" {
+		return ""
+	}
+	p.wait()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.rollback = ""
+	p.window = nil
+	return rollback
+}
+
+// hasRollback returns whether a rollback checkpoint is returned by the processor and not taken yet
+func (p *parallelProcessor) hasRollback() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.rollback != ""
+}
+
+// complete marks the batch as completed, and advances the watermark over the completed batches at the head of the window
+func (p *parallelProcessor) complete(batch *batchCheckPointTracker) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	defer p.notifyWindowChanged()
+	batch.completed = true
+	n := 0
+	for n < len(p.window) && p.window[n].completed {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	// the watermark is advanced in the lock, so it never goes backwards
+	p.worker.consumerCheckPointTracker.advanceWatermark(p.window[n-1].nextCursor)
+	p.window = p.window[n:]
+}
+
+// wait waits for the batches in process
+func (p *parallelProcessor) wait() {
+	p.waitGroup.Wait()
+}
+
+// batchCheckPointTracker is the CheckPointTracker of a batch processed concurrently,
+// SaveCheckPoint marks the batch as completed, and saves the watermark instead of the next cursor of the batch.
+type batchCheckPointTracker struct {
+	parallel      *parallelProcessor
+	tracker       *DefaultCheckPointTracker
+	currentCursor string
+	nextCursor    string
+	completed     bool // protected by the mutex of parallelProcessor
+}
+
+func (batch *batchCheckPointTracker) GetCheckPoint() string {
+	return batch.tracker.GetCheckPoint()
+}
+
+func (batch *batchCheckPointTracker) SaveCheckPoint(force bool) error {
+	if batch.tracker.isRevoked() {
+		return ErrShardRevoked
+	}
+	batch.parallel.complete(batch)
+	if force {
+		return batch.tracker.flushCheckPoint()
+	}
+	return nil
+}
+
+func (batch *batchCheckPointTracker) GetCurrentCursor() string {
+	return batch.currentCursor
+}
+
+func (batch *batchCheckPointTracker) GetNextCursor() string {
+	return batch.nextCursor
+}
+
+func (batch *batchCheckPointTracker) GetShardId() int {
+	return batch.tracker.GetShardId()
+}
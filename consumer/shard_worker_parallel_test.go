@@ -0,0 +1,188 @@
+package consumerLibrary
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+// blockingBatches is a processor blocking each batch until it is released, the batches are keyed by their cursors
+type blockingBatches struct {
+	started  chan string
+	release  map[string]chan string // the rollback checkpoint returned, or empty to save checkpoint
+	inflight atomic.Int32
+	maxSeen  atomic.Int32
+}
+
+func newBlockingBatches(cursors ...string) *blockingBatches {
+	b := &blockingBatches{started: make(chan string, len(cursors)), release: make(map[string]chan string)}
+	for _, cursor := range cursors {
+		b.release[cursor] = make(chan string, 1)
+	}
+	return b
+}
+
+func (b *blockingBatches) process(ctx context.Context, shard int, logGroupList *sls.LogGroupList, tracker CheckPointTracker) (string, error) {
+	n := b.inflight.Inc()
+	defer b.inflight.Dec()
+	for seen := b.maxSeen.Load(); n > seen && !b.maxSeen.CompareAndSwap(seen, n); seen = b.maxSeen.Load() {
+	}
+	b.started <- tracker.GetCurrentCursor()
+	rollback := <-b.release[tracker.GetCurrentCursor()]
+	if rollback != "" {
+		return rollback, nil
+	}
+	return "", tracker.SaveCheckPoint(false)
+}
+
+func newParallelTestWorker(t *testing.T, concurrency int, processor ContextProcessFunc) *ShardConsumerWorker {
+	client := newMockedConsumerClient(testutil.NewMockTransport())
+	client.option.ShardProcessConcurrency = concurrency
+	worker := newTestShardConsumerWorker(context.Background(), client, processor)
+	require.NotNil(t, worker.parallel)
+	return worker
+}
+
+func dispatchBatch(p *parallelProcessor, i int) string {
+	return p.dispatch(fmt.Sprintf("c%d", i), &sls.LogGroupList{}, &sls.PullLogMeta{NextCursor: fmt.Sprintf("c%d", i+1)})
+}
+
+func TestParallelProcessorWatermark(t *testing.T) {
+	batches := newBlockingBatches("c0", "c1", "c2", "c3")
+	worker := newParallelTestWorker(t, 3, batches.process)
+	tracker := worker.consumerCheckPointTracker
+	p := worker.parallel
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, fmt.Sprintf("c%d", i+1), dispatchBatch(p, i))
+	}
+	for i := 0; i < 3; i++ {
+		<-batches.started
+	}
+
+	// the 4th batch waits until one of the batches is finished
+	dispatched := make(chan string)
+	go func() { dispatched <- dispatchBatch(p, 3) }()
+	select {
+	case <-dispatched:
+		t.Fatal("more batches are processed than the concurrency")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the later batches are completed, but the watermark doesn't advance until the first one is completed
+	batches.release["c2"] <- ""
+	assert.Equal(t, "c4", <-dispatched)
+	batches.release["c1"] <- ""
+	assert.Eventually(t, func() bool {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		return len(p.window) == 4 && p.window[1].completed && p.window[2].completed
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "", tracker.GetNextCursor())
+
+	batches.release["c0"] <- ""
+	assert.Eventually(t, func() bool { return tracker.GetNextCursor() == "c3" }, time.Second, 10*time.Millisecond)
+	batches.release["c3"] <- ""
+	p.wait()
+	assert.Equal(t, "c4", tracker.GetNextCursor())
+	assert.Equal(t, "c4", tracker.pendingCheckPoint)
+	assert.Empty(t, p.window)
+	assert.Equal(t, int32(3), batches.maxSeen.Load())
+}
+
+func TestParallelProcessorRollback(t *testing.T) {
+	batches := newBlockingBatches("c0", "c1")
+	worker := newParallelTestWorker(t, 4, batches.process)
+	tracker := worker.consumerCheckPointTracker
+	p := worker.parallel
+
+	dispatchBatch(p, 0)
+	dispatchBatch(p, 1)
+	batches.release["c0"] <- "c0"
+	batches.release["c1"] <- ""
+	<-batches.started
+	<-batches.started
+	p.wait()
+
+	// the batch fetched after the rollback is dropped, and fetched again from the rollback checkpoint
+	assert.Equal(t, "c0", dispatchBatch(p, 2))
+	assert.Empty(t, p.window)
+	assert.Equal(t, "", tracker.GetNextCursor())
+}
+
+func TestParallelProcessorWindowLimit(t *testing.T) {
+	var first CheckPointTracker
+	held := map[string]bool{"c0": true}
+	worker := newParallelTestWorker(t, 2, func(ctx context.Context, shard int, logGroupList *sls.LogGroupList, tracker CheckPointTracker) (string, error) {
+		if held[tracker.GetCurrentCursor()] {
+			// the checkpoint is saved later, or never
+			first = tracker
+			return "", nil
+		}
+		return "", tracker.SaveCheckPoint(false)
+	})
+	p := worker.parallel
+	held[fmt.Sprintf("c%d", p.maxWindow+1)] = true
+	for i := 0; i < p.maxWindow; i++ {
+		dispatchBatch(p, i)
+	}
+	p.wait()
+	assert.Len(t, p.window, p.maxWindow)
+	assert.Equal(t, "", worker.consumerCheckPointTracker.GetNextCursor())
+
+	done := make(chan string)
+	go func() { done <- dispatchBatch(p, p.maxWindow) }()
+	select {
+	case <-done:
+		t.Fatal("the batch is dispatched when the window is full")
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.NoError(t, first.SaveCheckPoint(false))
+	assert.Equal(t, fmt.Sprintf("c%d", p.maxWindow+1), <-done)
+	p.wait()
+	assert.Empty(t, p.window)
+	assert.Equal(t, fmt.Sprintf("c%d", p.maxWindow+1), worker.consumerCheckPointTracker.GetNextCursor())
+
+	// the dispatching waiting for the window is stopped by the shutdown, the batch is not processed
+	for i := p.maxWindow + 1; i < 2*p.maxWindow+1; i++ {
+		dispatchBatch(p, i)
+	}
+	p.wait()
+	go func() { done <- dispatchBatch(p, 2*p.maxWindow+1) }()
+	worker.shutdown()
+	assert.Equal(t, fmt.Sprintf("c%d", 2*p.maxWindow+1), <-done)
+}
+
+func TestBatchCheckPointTracker(t *testing.T) {
+	transport := testutil.NewMockTransport()
+	testutil.RegisterJSON(t, transport, "POST", `=~/logstores/l/consumergroups/cg`, 200, map[string]string{})
+	client := newMockedConsumerClient(transport)
+	client.option.ShardProcessConcurrency = 2
+	var trackers []CheckPointTracker
+	worker := newTestShardConsumerWorker(context.Background(), client, nil)
+	worker.processor = ContextProcessFunc(func(ctx context.Context, shard int, logGroupList *sls.LogGroupList, tracker CheckPointTracker) (string, error) {
+		trackers = append(trackers, tracker)
+		return "", nil
+	})
+	worker.parallel.dispatch("c0", &sls.LogGroupList{}, &sls.PullLogMeta{NextCursor: "c1"})
+	worker.parallel.wait()
+	require.Len(t, trackers, 1)
+
+	tracker := trackers[0]
+	assert.Equal(t, "c0", tracker.GetCurrentCursor())
+	assert.Equal(t, "c1", tracker.GetNextCursor())
+	assert.Equal(t, 0, tracker.GetShardId())
+	require.NoError(t, tracker.SaveCheckPoint(true))
+	assert.Equal(t, "c1", tracker.GetCheckPoint())
+	assert.Equal(t, 1, transport.GetTotalCallCount())
+
+	worker.consumerCheckPointTracker.revoke()
+	assert.ErrorIs(t, tracker.SaveCheckPoint(true), ErrShardRevoked)
+}
@@ -0,0 +1,154 @@
+package consumerLibrary
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/internal/testutil"
+	"github.com/aliyun/aliyun-log-go-sdk/internal/testutil/clienthelper"
+	"github.com/go-kit/kit/log"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockedConsumerClient returns a consumer client sending requests to the transport
+func newMockedConsumerClient(transport *httpmock.MockTransport) *ConsumerClient {
+	option := LogHubConfig{
+		Project:                "p",
+		Logstore:               "l",
+		ConsumerGroupName:      "cg",
+		ConsumerName:           "c",
+		AutoCommitIntervalInMS: 60 * 1000,
+		DataFetchIntervalInMs:  200,
+		MaxFetchLogGroupCount:  1000,
+	}
+	return &ConsumerClient{
+		option:        option,
+		client:        clienthelper.NewMockedClient(transport),
+		consumerGroup: sls.ConsumerGroup{ConsumerGroupName: option.ConsumerGroupName},
+		logger:        log.NewNopLogger(),
+	}
+}
+
+func newTestShardConsumerWorker(ctx context.Context, client *ConsumerClient, processor ContextProcessor) *ShardConsumerWorker {
+	return newShardConsumerWorker(ctx, 0, client, initConsumerHeatBeat(client, log.NewNopLogger()), processor, log.NewNopLogger(), newSimpleIoThrottler(1), newMemoryBudget(0))
+}
+
+func TestCheckPointTrackerRevoked(t *testing.T) {
+	transport := testutil.NewMockTransport()
+	testutil.RegisterError(t, transport, "POST", `=~/logstores/l/consumergroups/cg`, 400, "ConsumerNotMatch", "consumer not match")
+	worker := newTestShardConsumerWorker(context.Background(), newMockedConsumerClient(transport), ContextProcessFunc(nil))
+	tracker := worker.consumerCheckPointTracker
+	tracker.setNextCursor("cursor-1")
+
+	assert.ErrorIs(t, tracker.SaveCheckPoint(true), ErrShardRevoked)
+	assert.True(t, tracker.isRevoked())
+	assert.Equal(t, "", tracker.GetCheckPoint())
+	assert.ErrorIs(t, worker.ctx.Err(), context.Canceled)
+	assert.True(t, worker.shutDownFlag.Load())
+
+	calls := transport.GetTotalCallCount()
+	assert.ErrorIs(t, tracker.SaveCheckPoint(false), ErrShardRevoked)
+	assert.ErrorIs(t, tracker.flushCheckPoint(), ErrShardRevoked)
+	assert.Equal(t, calls, transport.GetTotalCallCount())
+}
+
+func TestShardConsumerWorkerRevoke(t *testing.T) {
+	transport := testutil.NewMockTransport()
+	processing := make(chan struct{})
+	var saveErr error
+	worker := newTestShardConsumerWorker(context.Background(), newMockedConsumerClient(transport),
+		ContextProcessFunc(func(ctx context.Context, shard int, logGroupList *sls.LogGroupList, tracker CheckPointTracker) (string, error) {
+			close(processing)
+			<-ctx.Done()
+			saveErr = tracker.SaveCheckPoint(true)
+			return "", ctx.Err()
+		}))
+
+	done := make(chan error)
+	go func() {
+		_, err := worker.processInternal(&sls.LogGroupList{}, worker.consumerCheckPointTracker)
+		done <- err
+	}()
+	<-processing
+	worker.consumerCheckPointTracker.revoke()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("processing is not cancelled after the shard is revoked")
+	}
+	assert.ErrorIs(t, saveErr, ErrShardRevoked)
+	assert.Equal(t, 0, transport.GetTotalCallCount())
+
+	// skip flushing checkpoint instead of retrying forever
+	worker.doShutDown()
+	assert.True(t, worker.isStopped())
+}
+
+func TestShardConsumerWorkerShutdown(t *testing.T) {
+	transport := testutil.NewMockTransport()
+	testutil.RegisterJSON(t, transport, "POST", `=~/logstores/l/consumergroups/cg`, 200, map[string]string{})
+	ctx, cancel := context.WithCancel(context.Background())
+	worker := newTestShardConsumerWorker(ctx, newMockedConsumerClient(transport),
+		ContextProcessFunc(func(ctx context.Context, shard int, logGroupList *sls.LogGroupList, tracker CheckPointTracker) (string, error) {
+			<-ctx.Done()
+			return "", errors.New("interrupted")
+		}))
+
+	// the worker is stopped, the checkpoint is still saved since the shard is held
+	cancel()
+	_, err := worker.processInternal(&sls.LogGroupList{}, worker.consumerCheckPointTracker)
+	assert.Error(t, err)
+	tracker := worker.consumerCheckPointTracker
+	tracker.setNextCursor("cursor-1")
+	require.NoError(t, tracker.SaveCheckPoint(true))
+	assert.Equal(t, "cursor-1", tracker.GetCheckPoint())
+	assert.Equal(t, 1, transport.GetTotalCallCount())
+}
+
+type saveOnShutdownProcessor struct {
+	ContextProcessFunc
+	err error
+}
+
+func (p *saveOnShutdownProcessor) Shutdown(tracker CheckPointTracker) error {
+	p.err = tracker.SaveCheckPoint(true)
+	return nil
+}
+
+func TestShardConsumerWorkerUnassigned(t *testing.T) {
+	transport := testutil.NewMockTransport()
+	testutil.RegisterJSON(t, transport, "POST", `=~/logstores/l/consumergroups/cg`, 200, map[string]string{})
+	processor := &saveOnShutdownProcessor{}
+	worker := newTestShardConsumerWorker(context.Background(), newMockedConsumerClient(transport), processor)
+	tracker := worker.consumerCheckPointTracker
+	tracker.setNextCursor("cursor-1")
+
+	// the shard is unassigned by the rebalance, the checkpoint is still saved when shutting down
+	worker.shutdown()
+	assert.ErrorIs(t, worker.ctx.Err(), context.Canceled)
+	worker.doShutDown()
+	assert.NoError(t, processor.err)
+	assert.False(t, tracker.isRevoked())
+	assert.Equal(t, "cursor-1", tracker.GetCheckPoint())
+	assert.Equal(t, 1, transport.GetTotalCallCount())
+}
+
+func TestProcessorWithoutContext(t *testing.T) {
+	var gotShard int
+	processor := processorWithoutContext{ProcessFunc(func(shard int, logGroupList *sls.LogGroupList, tracker CheckPointTracker) (string, error) {
+		gotShard = shard
+		return "rollback", nil
+	})}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cursor, err := processor.ProcessWithContext(ctx, 3, &sls.LogGroupList{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "rollback", cursor)
+	assert.Equal(t, 3, gotShard)
+}
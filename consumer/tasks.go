@@ -27,7 +27,7 @@ func (consumer *ShardConsumerWorker) consumerInitializeTask() (string, string, e
 	}
 
 	// read checkpoint firstly
-	checkpoint, err := consumer.client.getCheckPoint(consumer.shardId)
+	checkpoint, err := consumer.consumerCheckPointTracker.store.GetCheckpoint(consumer.shardId)
 	if err != nil {
 		return "", "", err
 	}
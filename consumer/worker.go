@@ -1,8 +1,11 @@
 package consumerLibrary
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
@@ -18,10 +21,29 @@ type ConsumerWorker struct {
 	client             *ConsumerClient
 	workerShutDownFlag *atomic.Bool
 	shardConsumer      sync.Map // map[int]*ShardConsumerWorker
-	processor          Processor
+	processor          ContextProcessor
 	waitGroup          sync.WaitGroup
 	Logger             log.Logger
 	ioThrottler        ioThrottler
+	prefetchBudget     *memoryBudget   // shared by the shard consumers, nil if prefetch is disabled
+	ctx                context.Context // cancelled when the worker is stopped
+	cancel             context.CancelFunc
+	rebalanceListener  RebalanceListener
+	replay             *replayTracker
+	failure            *atomic.Error // the error the worker is stopped with by FailureActionStop
+	failed             chan struct{} // closed when the worker is stopped by FailureActionStop
+	failOnce           sync.Once
+}
+
+// RebalanceListener is notified when shards are assigned to or revoked from the consumer,
+// eg. to warm caches or open per-shard output files, and to flush state and release resources.
+// It is called by the goroutine of the worker, so don't block it for long.
+type RebalanceListener interface {
+	// OnShardsAssigned is called before the processing of the shards starts
+	OnShardsAssigned(shards []int)
+	// OnShardsRevoked is called after the processing of the shards stops and Processor.Shutdown returns,
+	// when the shards are reassigned or the worker is stopped
+	OnShardsRevoked(shards []int)
 }
 
 // depreciated: this old logic is to automatically save to memory, and then commit at a fixed time
@@ -52,8 +74,23 @@ func InitConsumerWorkerWithCheckpointTracker(option LogHubConfig, do func(int, *
 }
 
 // InitConsumerWorkerWithProcessor
-// you need save checkpoint by yourself and can do something after consumer shutdown
+// you need save checkpoint by yourself and can do something after consumer shutdown.
+// If LogHubConfig.ShardProcessConcurrency is greater than 1, SaveCheckPoint marks the batch of the tracker as completed,
+// and the checkpoint saved is the highest cursor below which all the batches are completed, not the next cursor of the batch.
+// A batch whose SaveCheckPoint is never called holds the checkpoint back, and the shard stops fetching
+// once 16 * ShardProcessConcurrency batches are dispatched after it.
 func InitConsumerWorkerWithProcessor(option LogHubConfig, processor Processor) *ConsumerWorker {
+	return InitConsumerWorkerWithContextProcessor(option, processorWithoutContext{processor})
+}
+
+// InitConsumerWorkerWithContextProcessor
+// the same as InitConsumerWorkerWithProcessor, but the context passed to the processor is cancelled
+// when the shard is no longer assigned to the consumer or the worker is stopped
+func InitConsumerWorkerWithContextProcessor(option LogHubConfig, processor ContextProcessor) *ConsumerWorker {
+	if _, ok := option.CheckpointStore.(*TransactionalCheckpointStore); ok && option.ShardProcessConcurrency > 1 {
+		panic("TransactionalCheckpointStore can't be used with ShardProcessConcurrency greater than 1, " +
+			"the next cursor committed by a batch may be ahead of the batches still in process")
+	}
 	logger := option.Logger
 	if logger == nil {
 		logger = logConfig(option)
@@ -65,6 +102,7 @@ func InitConsumerWorkerWithProcessor(option LogHubConfig, processor Processor) *
 
 	consumerClient := initConsumerClient(option, logger)
 	consumerHeatBeat := initConsumerHeatBeat(consumerClient, logger)
+	ctx, cancel := context.WithCancel(context.Background())
 	consumerWorker := &ConsumerWorker{
 		consumerHeatBeat:   consumerHeatBeat,
 		client:             consumerClient,
@@ -73,6 +111,14 @@ func InitConsumerWorkerWithProcessor(option LogHubConfig, processor Processor) *
 		processor:   processor,
 		Logger:      logger,
 		ioThrottler: newSimpleIoThrottler(maxIoWorker),
+		ctx:         ctx,
+		cancel:      cancel,
+		replay:      newReplayTracker(),
+		failure:     atomic.NewError(nil),
+		failed:      make(chan struct{}),
+	}
+	if option.PrefetchDepth > 0 {
+		consumerWorker.prefetchBudget = newMemoryBudget(option.PrefetchMemoryLimitInBytes)
 	}
 	if err := consumerClient.createConsumerGroup(); err != nil {
 		level.Error(consumerWorker.Logger).Log(
@@ -82,6 +128,11 @@ func InitConsumerWorkerWithProcessor(option LogHubConfig, processor Processor) *
 	return consumerWorker
 }
 
+// SetRebalanceListener sets the listener notified when shards are assigned or revoked, call it before Start
+func (consumerWorker *ConsumerWorker) SetRebalanceListener(listener RebalanceListener) {
+	consumerWorker.rebalanceListener = listener
+}
+
 func (consumerWorker *ConsumerWorker) Start() {
 	consumerWorker.waitGroup.Add(1)
 	go consumerWorker.run()
@@ -90,6 +141,7 @@ func (consumerWorker *ConsumerWorker) Start() {
 func (consumerWorker *ConsumerWorker) StopAndWait() {
 	level.Info(consumerWorker.Logger).Log("msg", "*** try to exit ***")
 	consumerWorker.workerShutDownFlag.Store(true)
+	consumerWorker.cancel()
 	consumerWorker.consumerHeatBeat.shutDownHeart()
 	consumerWorker.waitGroup.Wait()
 	level.Info(consumerWorker.Logger).Log("msg", "consumer worker stopped", "consumer name", consumerWorker.client.option.ConsumerName)
@@ -104,6 +156,7 @@ func (consumerWorker *ConsumerWorker) run() {
 		heldShards := consumerWorker.consumerHeatBeat.getHeldShards()
 		lastFetchTime := time.Now().UnixNano() / 1000 / 1000
 
+		consumerWorker.assignShards(heldShards)
 		for _, shard := range heldShards {
 			if consumerWorker.workerShutDownFlag.Load() {
 				break
@@ -115,6 +168,7 @@ func (consumerWorker *ConsumerWorker) run() {
 			}
 		}
 		consumerWorker.cleanShardConsumer(heldShards)
+		consumerWorker.checkReplayCompleted()
 		TimeToSleepInMillsecond(consumerWorker.client.option.DataFetchIntervalInMs, lastFetchTime, consumerWorker.workerShutDownFlag.Load())
 
 	}
@@ -126,6 +180,7 @@ func (consumerWorker *ConsumerWorker) shutDownAndWait() {
 	for {
 		time.Sleep(500 * time.Millisecond)
 		count := 0
+		var revokedShards []int
 		consumerWorker.shardConsumer.Range(
 			func(key, value interface{}) bool {
 				count++
@@ -134,10 +189,12 @@ func (consumerWorker *ConsumerWorker) shutDownAndWait() {
 					consumer.shutdown()
 				} else {
 					consumerWorker.shardConsumer.Delete(key)
+					revokedShards = append(revokedShards, key.(int))
 				}
 				return true
 			},
 		)
+		consumerWorker.notifyShardsRevoked(revokedShards)
 		if count == 0 {
 			break
 		}
@@ -150,19 +207,61 @@ func (consumerWorker *ConsumerWorker) getShardConsumer(shardId int) *ShardConsum
 	if ok {
 		return consumer.(*ShardConsumerWorker)
 	}
-	consumerIns := newShardConsumerWorker(shardId,
+	consumerIns := newShardConsumerWorker(consumerWorker.ctx,
+		shardId,
 		consumerWorker.client,
 		consumerWorker.consumerHeatBeat,
 		consumerWorker.processor,
 		consumerWorker.Logger,
-		consumerWorker.ioThrottler)
+		consumerWorker.ioThrottler,
+		consumerWorker.prefetchBudget)
 	consumerWorker.shardConsumer.Store(shardId, consumerIns)
 	return consumerIns
 
 }
 
-func (consumerWorker *ConsumerWorker) cleanShardConsumer(owned_shards []int) {
+// assignShards starts the consumers of the shards newly held, after notifying the listener
+func (consumerWorker *ConsumerWorker) assignShards(heldShards []int) {
+	var assignedShards []int
+	for _, shard := range heldShards {
+		if _, ok := consumerWorker.shardConsumer.Load(shard); !ok {
+			assignedShards = append(assignedShards, shard)
+		}
+	}
+	if len(assignedShards) == 0 {
+		return
+	}
+	sort.Ints(assignedShards)
+	if consumerWorker.rebalanceListener != nil {
+		level.Info(consumerWorker.Logger).Log("msg", "shards assigned", "shards", fmt.Sprintf("%v", assignedShards))
+		consumerWorker.callRebalanceListener(func() { consumerWorker.rebalanceListener.OnShardsAssigned(assignedShards) })
+	}
+	for _, shard := range assignedShards {
+		consumerWorker.getShardConsumer(shard).ensureStarted()
+	}
+}
+
+func (consumerWorker *ConsumerWorker) notifyShardsRevoked(revokedShards []int) {
+	if len(revokedShards) == 0 || consumerWorker.rebalanceListener == nil {
+		return
+	}
+	sort.Ints(revokedShards)
+	level.Info(consumerWorker.Logger).Log("msg", "shards revoked", "shards", fmt.Sprintf("%v", revokedShards))
+	consumerWorker.callRebalanceListener(func() { consumerWorker.rebalanceListener.OnShardsRevoked(revokedShards) })
+}
 
+func (consumerWorker *ConsumerWorker) callRebalanceListener(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			level.Error(consumerWorker.Logger).Log("msg", "get panic in rebalance listener", "error", r)
+		}
+	}()
+	f()
+}
+
+func (consumerWorker *ConsumerWorker) cleanShardConsumer(owned_shards []int) {
+	var revokedShards []int
+	var failure error
 	consumerWorker.shardConsumer.Range(
 		func(key, value interface{}) bool {
 			shard := key.(int)
@@ -170,22 +269,33 @@ func (consumerWorker *ConsumerWorker) cleanShardConsumer(owned_shards []int) {
 
 			if !Contain(shard, owned_shards) {
 				level.Info(consumerWorker.Logger).Log("msg", "try to call shut down for unassigned consumer shard", "shardId", shard)
+				// the processor and the final flush may still save the checkpoint,
+				// they are refused only if the server reports the shard is held by another consumer
 				consumer.shutdown()
 				level.Info(consumerWorker.Logger).Log("msg", "Complete call shut down for unassigned consumer shard", "shardId", shard)
 			}
 
 			if consumer.isStopped() {
+				if err := consumer.getFailure(); err != nil && failure == nil {
+					failure = err
+				}
 				isDeleteShard := consumerWorker.consumerHeatBeat.removeHeartShard(shard)
 				if isDeleteShard {
 					level.Info(consumerWorker.Logger).Log("msg", "Remove an assigned consumer shard", "shardId", shard)
-					consumerWorker.shardConsumer.Delete(shard)
 				} else {
-					level.Info(consumerWorker.Logger).Log("msg", "Remove an assigned consumer shard failed", "shardId", shard)
+					// the shard is already removed from heart shards when the checkpoint is rejected
+					level.Info(consumerWorker.Logger).Log("msg", "Remove an assigned consumer shard not in heart shards", "shardId", shard)
 				}
+				consumerWorker.shardConsumer.Delete(shard)
+				revokedShards = append(revokedShards, shard)
 			}
 			return true
 		},
 	)
+	consumerWorker.notifyShardsRevoked(revokedShards)
+	if failure != nil {
+		consumerWorker.fail(failure)
+	}
 }
 
 // This function is used to initialize the global logger
@@ -0,0 +1,137 @@
+package consumerLibrary
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-log-go-sdk/internal/testutil"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+)
+
+// eventRecorder records the calls of the rebalance listener and processor shutdown in order
+type eventRecorder struct {
+	mutex  sync.Mutex
+	events []string
+}
+
+func (r *eventRecorder) record(event string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) get() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string{}, r.events...)
+}
+
+func (r *eventRecorder) OnShardsAssigned(shards []int) {
+	r.record(fmt.Sprintf("assigned %v", shards))
+}
+
+func (r *eventRecorder) OnShardsRevoked(shards []int) {
+	r.record(fmt.Sprintf("revoked %v", shards))
+}
+
+type recordShutdownProcessor struct {
+	ContextProcessFunc
+	recorder *eventRecorder
+}
+
+func (p recordShutdownProcessor) Shutdown(tracker CheckPointTracker) error {
+	p.recorder.record(fmt.Sprintf("shutdown %d", tracker.GetShardId()))
+	return nil
+}
+
+// newTestConsumerWorker returns a worker whose shard consumers keep failing to pull logs, until they are shut down
+func newTestConsumerWorker(t *testing.T, recorder *eventRecorder) *ConsumerWorker {
+	transport := testutil.NewMockTransport()
+	testutil.RegisterJSON(t, transport, "GET", `=~/logstores/l/consumergroups/cg`, 200, []map[string]interface{}{
+		{"shard": 0, "checkpoint": "c0"},
+		{"shard": 2, "checkpoint": "c2"},
+	})
+	testutil.RegisterError(t, transport, "GET", `=~/logstores/l/shards/`, 400, "InvalidCursor", "invalid cursor")
+	client := newMockedConsumerClient(transport)
+	ctx, cancel := context.WithCancel(context.Background())
+	worker := &ConsumerWorker{
+		consumerHeatBeat:   initConsumerHeatBeat(client, log.NewNopLogger()),
+		client:             client,
+		workerShutDownFlag: atomic.NewBool(false),
+		processor:          recordShutdownProcessor{recorder: recorder},
+		Logger:             log.NewNopLogger(),
+		ioThrottler:        newSimpleIoThrottler(1),
+		ctx:                ctx,
+		cancel:             cancel,
+		replay:             newReplayTracker(),
+		failure:            atomic.NewError(nil),
+		failed:             make(chan struct{}),
+	}
+	worker.SetRebalanceListener(recorder)
+	return worker
+}
+
+func TestRebalanceListener(t *testing.T) {
+	recorder := &eventRecorder{}
+	worker := newTestConsumerWorker(t, recorder)
+
+	worker.assignShards([]int{2, 0})
+	worker.assignShards([]int{0, 2})
+	assert.Equal(t, []string{"assigned [0 2]"}, recorder.get())
+
+	assert.Eventually(t, func() bool {
+		worker.cleanShardConsumer([]int{2})
+		return len(recorder.get()) == 3
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Equal(t, []string{"assigned [0 2]", "shutdown 0", "revoked [0]"}, recorder.get())
+
+	worker.assignShards([]int{0, 2})
+	worker.shutDownAndWait()
+	events := recorder.get()
+	assert.Equal(t, "assigned [0]", events[3])
+	// the shards may be revoked together or one by one, each after its processor is shut down
+	revoked := map[int]bool{}
+	for _, event := range events[4:] {
+		var shard int
+		if _, err := fmt.Sscanf(event, "shutdown %d", &shard); err == nil {
+			assert.False(t, revoked[shard], event)
+			continue
+		}
+		var shards []int
+		switch event {
+		case "revoked [0]":
+			shards = []int{0}
+		case "revoked [2]":
+			shards = []int{2}
+		case "revoked [0 2]":
+			shards = []int{0, 2}
+		default:
+			t.Fatalf("unexpected event %s", event)
+		}
+		for _, shard := range shards {
+			assert.Contains(t, events[4:], fmt.Sprintf("shutdown %d", shard))
+			revoked[shard] = true
+		}
+	}
+	assert.Equal(t, map[int]bool{0: true, 2: true}, revoked)
+}
+
+type panicListener struct{}
+
+func (panicListener) OnShardsAssigned(shards []int) { panic("assigned") }
+func (panicListener) OnShardsRevoked(shards []int)  { panic("revoked") }
+
+func TestRebalanceListenerPanic(t *testing.T) {
+	worker := newTestConsumerWorker(t, &eventRecorder{})
+	worker.SetRebalanceListener(panicListener{})
+
+	worker.assignShards([]int{0})
+	_, ok := worker.shardConsumer.Load(0)
+	assert.True(t, ok)
+	worker.shutDownAndWait()
+}
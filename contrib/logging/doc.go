@@ -0,0 +1,13 @@
+// Package logging sends the logs of structured logging libraries to SLS through a producer.
+//
+// The subpackages adapt it to the logging libraries: slogsls provides a log/slog Handler,
+// zapsls a zapcore.Core, logrussls a logrus Hook and zerologsls a zerolog writer.
+// Each log is written with its time (with nanoseconds in TimeNs), level, message, caller,
+// stack trace and attributes as contents, attributes in groups are keyed like "group.key".
+//
+// The Writer takes over the producer of its Config: closing a handler, core, hook or writer
+// closes the producer, so give each of them a producer of its own.
+//
+// The subpackages import zap, logrus and zerolog, which is why this lives in its own module
+// under contrib/ instead of the SDK module.
+package logging
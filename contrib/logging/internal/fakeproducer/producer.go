@@ -0,0 +1,54 @@
+// Package fakeproducer provides a producer recording the logs sent, for the tests of the integrations.
+package fakeproducer
+
+import (
+	"sync"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+type Producer struct {
+	mutex  sync.Mutex
+	logs   []*sls.Log
+	Closed bool
+}
+
+func (p *Producer) SendLog(project, logstore, topic, source string, log *sls.Log) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.logs = append(p.logs, log)
+	return nil
+}
+
+func (p *Producer) Close(timeoutMs int64) error {
+	p.SafeClose()
+	return nil
+}
+
+func (p *Producer) SafeClose() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.Closed = true
+}
+
+// Logs returns the logs sent, each as a map of its contents
+func (p *Producer) Logs() []map[string]string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	logs := make([]map[string]string, 0, len(p.logs))
+	for _, log := range p.logs {
+		contents := make(map[string]string, len(log.Contents))
+		for _, content := range log.Contents {
+			contents[content.GetKey()] = content.GetValue()
+		}
+		logs = append(logs, contents)
+	}
+	return logs
+}
+
+// RawLogs returns the logs sent
+func (p *Producer) RawLogs() []*sls.Log {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]*sls.Log(nil), p.logs...)
+}
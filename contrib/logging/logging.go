@@ -0,0 +1,164 @@
+package logging
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+// keys of the contents every integration writes, besides the attributes of the log
+const (
+	LevelKey   = "level"
+	MessageKey = "message"
+	CallerKey  = "caller"
+	StackKey   = "stack"
+	LoggerKey  = "logger"
+)
+
+// ErrClosed is returned when a log is written after the Writer is closed.
+var ErrClosed = errors.New("logging: writer is closed")
+
+// Producer is the part of *producer.Producer used to send logs.
+type Producer interface {
+	SendLog(project, logstore, topic, source string, log *sls.Log) error
+	Close(timeoutMs int64) error
+	SafeClose()
+}
+
+// Config is the destination of the logs, and the producer sending them.
+type Config struct {
+	// Producer must be started. The Writer owns it from then on: it is closed when the Writer
+	// is closed, so don't share it with other code writing to SLS or close it yourself.
+	Producer Producer
+	Project  string
+	Logstore string
+	Topic    string
+	Source   string
+	// CloseTimeout limits how long Close waits for the cached logs to be sent,
+	// Close waits until all of them are sent or failed if it is 0.
+	CloseTimeout time.Duration
+}
+
+// Writer sends logs to SLS with a producer, the logs are batched by the producer and sent asynchronously.
+// It is thread safe.
+type Writer struct {
+	config    Config
+	closeOnce sync.Once
+	mutex     sync.RWMutex
+	closed    bool
+	closeErr  error
+}
+
+// NewWriter creates a Writer sending logs to the logstore of the config, the Writer owns the producer of the config.
+func NewWriter(config Config) *Writer {
+	return &Writer{config: config}
+}
+
+// Write sends a log of the time and contents.
+func (w *Writer) Write(t time.Time, contents []*sls.LogContent) error {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.closed {
+		return ErrClosed
+	}
+	return w.config.Producer.SendLog(w.config.Project, w.config.Logstore, w.config.Topic, w.config.Source, NewLog(t, contents))
+}
+
+// Close closes the producer of the config after the cached logs are sent, logs written afterwards are rejected with ErrClosed.
+// The producer is closed even if it was created by the caller, as the Writer owns it.
+func (w *Writer) Close() error {
+	w.closeOnce.Do(func() {
+		w.mutex.Lock()
+		w.closed = true
+		w.mutex.Unlock()
+		if w.config.CloseTimeout > 0 {
+			w.closeErr = w.config.Producer.Close(w.config.CloseTimeout.Milliseconds())
+		} else {
+			w.config.Producer.SafeClose()
+		}
+	})
+	return w.closeErr
+}
+
+// NewLog creates a log of the time, the sub-second part is kept in TimeNs.
+// The current time is used if t is zero.
+func NewLog(t time.Time, contents []*sls.LogContent) *sls.Log {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return &sls.Log{
+		Time:     proto.Uint32(uint32(t.Unix())),
+		TimeNs:   proto.Uint32(uint32(t.Nanosecond())),
+		Contents: contents,
+	}
+}
+
+// Content creates a log content of the key and value.
+func Content(key, value string) *sls.LogContent {
+	return &sls.LogContent{Key: proto.String(key), Value: proto.String(value)}
+}
+
+// JoinKey returns the key of an attribute in a group, eg. "http.request.method", groups are joined by ".".
+func JoinKey(group, key string) string {
+	if group == "" {
+		return key
+	}
+	return group + "." + key
+}
+
+// FormatValue formats the value of an attribute as a content value.
+// Errors, Stringers and text marshalers are formatted by themselves, times in RFC3339 with nanoseconds,
+// maps, slices and structs in JSON, and other values by fmt.
+func FormatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	case encoding.TextMarshaler:
+		if text, err := v.MarshalText(); err == nil {
+			return string(text)
+		}
+	}
+	switch reflect.Indirect(reflect.ValueOf(v)).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+		if data, err := json.Marshal(v); err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+// AppendMap appends the fields of a map, the values which are maps are flattened with their keys joined by JoinKey,
+// and the keys are sorted so that the contents are in a stable order.
+func AppendMap(contents []*sls.LogContent, group string, fields map[string]interface{}) []*sls.LogContent {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if m, ok := fields[key].(map[string]interface{}); ok {
+			contents = AppendMap(contents, JoinKey(group, key), m)
+			continue
+		}
+		contents = append(contents, Content(JoinKey(group, key), FormatValue(fields[key])))
+	}
+	return contents
+}
@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/contrib/logging/internal/fakeproducer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stringer struct{}
+
+func (stringer) String() string { return "stringer" }
+
+func TestFormatValue(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	for _, tt := range []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, ""},
+		{"s", "s"},
+		{[]byte("b"), "b"},
+		{12, "12"},
+		{1.5, "1.5"},
+		{true, "true"},
+		{now, "2024-01-02T03:04:05.000000006Z"},
+		{errors.New("failed"), "failed"},
+		{stringer{}, "stringer"},
+		{map[string]int{"a": 1}, `{"a":1}`},
+		{[]string{"a", "b"}, `["a","b"]`},
+		{struct{ A int }{1}, `{"A":1}`},
+		{&struct{ A int }{1}, `{"A":1}`},
+	} {
+		assert.Equal(t, tt.want, FormatValue(tt.value))
+	}
+}
+
+func TestWriter(t *testing.T) {
+	producer := &fakeproducer.Producer{}
+	writer := NewWriter(Config{Producer: producer, Project: "p", Logstore: "l"})
+
+	now := time.Unix(1700000000, 123456789)
+	require.NoError(t, writer.Write(now, []*sls.LogContent{Content("a", "b")}))
+	require.NoError(t, writer.Write(time.Time{}, nil))
+	logs := producer.RawLogs()
+	require.Len(t, logs, 2)
+	assert.Equal(t, uint32(1700000000), logs[0].GetTime())
+	assert.Equal(t, uint32(123456789), logs[0].GetTimeNs())
+	assert.NotZero(t, logs[1].GetTime())
+
+	require.NoError(t, writer.Close())
+	assert.True(t, producer.Closed)
+	assert.ErrorIs(t, writer.Write(now, nil), ErrClosed)
+	assert.NoError(t, writer.Close())
+}
+
+func TestAppendMap(t *testing.T) {
+	contents := AppendMap(nil, "g", map[string]interface{}{
+		"b": 1,
+		"a": map[string]interface{}{"c": "d"},
+	})
+	require.Len(t, contents, 2)
+	assert.Equal(t, "g.a.c", contents[0].GetKey())
+	assert.Equal(t, "d", contents[0].GetValue())
+	assert.Equal(t, "g.b", contents[1].GetKey())
+	assert.Equal(t, "1", contents[1].GetValue())
+}
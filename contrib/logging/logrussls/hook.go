@@ -0,0 +1,53 @@
+// Package logrussls provides a logrus Hook sending logs to SLS through a producer.
+package logrussls
+
+import (
+	"strconv"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/contrib/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// FunctionKey is the key of the function of the caller, written with the caller if the logger reports it.
+const FunctionKey = "function"
+
+// Hook is a logrus.Hook writing each entry as a log with its level, message, caller and data.
+type Hook struct {
+	writer *logging.Writer
+	levels []logrus.Level
+}
+
+// NewHook creates a Hook sending the entries of the levels with the producer of the config,
+// the entries of all levels are sent if no level is given.
+func NewHook(config logging.Config, levels ...logrus.Level) *Hook {
+	if len(levels) == 0 {
+		levels = logrus.AllLevels
+	}
+	return &Hook{writer: logging.NewWriter(config), levels: levels}
+}
+
+func (h *Hook) Levels() []logrus.Level {
+	return h.levels
+}
+
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	contents := make([]*sls.LogContent, 0, 4+len(entry.Data))
+	contents = append(contents,
+		logging.Content(logging.LevelKey, entry.Level.String()),
+		logging.Content(logging.MessageKey, entry.Message))
+	if entry.HasCaller() {
+		contents = append(contents,
+			logging.Content(logging.CallerKey, entry.Caller.File+":"+strconv.Itoa(entry.Caller.Line)),
+			logging.Content(FunctionKey, entry.Caller.Function))
+	}
+	for key, value := range entry.Data {
+		contents = append(contents, logging.Content(key, logging.FormatValue(value)))
+	}
+	return h.writer.Write(entry.Time, contents)
+}
+
+// Close closes the producer after the cached logs are sent.
+func (h *Hook) Close() error {
+	return h.writer.Close()
+}
@@ -0,0 +1,44 @@
+package logrussls
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/aliyun/aliyun-log-go-sdk/contrib/logging"
+	"github.com/aliyun/aliyun-log-go-sdk/contrib/logging/internal/fakeproducer"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHook(t *testing.T) {
+	producer := &fakeproducer.Producer{}
+	hook := NewHook(logging.Config{Producer: producer}, logrus.InfoLevel, logrus.WarnLevel)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.SetLevel(logrus.DebugLevel)
+	logger.SetReportCaller(true)
+	logger.AddHook(hook)
+
+	logger.Debug("dropped")
+	logger.WithFields(logrus.Fields{"n": 1, "tags": []string{"a"}}).WithError(errors.New("failed")).Warn("hello")
+
+	logs := producer.Logs()
+	require.Len(t, logs, 1)
+	assert.Equal(t, "warning", logs[0][logging.LevelKey])
+	assert.Equal(t, "hello", logs[0][logging.MessageKey])
+	assert.Contains(t, logs[0][logging.CallerKey], "hook_test.go:")
+	assert.Contains(t, logs[0][FunctionKey], "TestHook")
+	assert.Equal(t, "1", logs[0]["n"])
+	assert.Equal(t, `["a"]`, logs[0]["tags"])
+	assert.Equal(t, "failed", logs[0][logrus.ErrorKey])
+	assert.NotZero(t, producer.RawLogs()[0].GetTimeNs())
+
+	require.NoError(t, hook.Close())
+	assert.True(t, producer.Closed)
+}
+
+func TestHookLevels(t *testing.T) {
+	assert.Equal(t, logrus.AllLevels, NewHook(logging.Config{}).Levels())
+}
@@ -0,0 +1,119 @@
+// Package slogsls provides a log/slog Handler sending logs to SLS through a producer.
+package slogsls
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/contrib/logging"
+)
+
+// HandlerOptions are options for a Handler, the zero value is valid.
+type HandlerOptions struct {
+	// Level is the minimum level of the records written, slog.LevelInfo if it is nil
+	Level slog.Leveler
+	// AddSource writes the source file and line of the log call as the caller
+	AddSource bool
+}
+
+// Handler is a slog.Handler writing each record as a log with its level, message, caller and attributes,
+// attributes in groups are keyed like "group.key".
+type Handler struct {
+	writer   *logging.Writer
+	opts     HandlerOptions
+	group    string
+	contents []*sls.LogContent // attributes added by WithAttrs
+}
+
+// NewHandler creates a Handler sending logs with the producer of the config, opts can be nil.
+func NewHandler(config logging.Config, opts *HandlerOptions) *Handler {
+	h := &Handler{writer: logging.NewWriter(config)}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.opts.Level != nil {
+		minLevel = h.opts.Level.Level()
+	}
+	return level >= minLevel
+}
+
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	contents := make([]*sls.LogContent, 0, 3+len(h.contents)+r.NumAttrs())
+	contents = append(contents,
+		logging.Content(logging.LevelKey, r.Level.String()),
+		logging.Content(logging.MessageKey, r.Message))
+	if h.opts.AddSource && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		contents = append(contents, logging.Content(logging.CallerKey, frame.File+":"+strconv.Itoa(frame.Line)))
+	}
+	contents = append(contents, h.contents...)
+	r.Attrs(func(a slog.Attr) bool {
+		contents = appendAttr(contents, h.group, a)
+		return true
+	})
+	return h.writer.Write(r.Time, contents)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := *h
+	h2.contents = h.contents[:len(h.contents):len(h.contents)]
+	for _, a := range attrs {
+		h2.contents = appendAttr(h2.contents, h.group, a)
+	}
+	return &h2
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.group = logging.JoinKey(h.group, name)
+	return &h2
+}
+
+// Close closes the producer after the cached logs are sent, it is shared by the handlers derived from h.
+func (h *Handler) Close() error {
+	return h.writer.Close()
+}
+
+func appendAttr(contents []*sls.LogContent, group string, a slog.Attr) []*sls.LogContent {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return contents
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		// attributes of a group without a key are inlined
+		if a.Key != "" {
+			group = logging.JoinKey(group, a.Key)
+		}
+		for _, ga := range a.Value.Group() {
+			contents = appendAttr(contents, group, ga)
+		}
+		return contents
+	}
+	return append(contents, logging.Content(logging.JoinKey(group, a.Key), formatValue(a.Value)))
+}
+
+func formatValue(v slog.Value) string {
+	switch v.Kind() {
+	case slog.KindTime:
+		return v.Time().Format(time.RFC3339Nano)
+	case slog.KindAny:
+		return logging.FormatValue(v.Any())
+	default:
+		return v.String()
+	}
+}
@@ -0,0 +1,70 @@
+package slogsls
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-log-go-sdk/contrib/logging"
+	"github.com/aliyun/aliyun-log-go-sdk/contrib/logging/internal/fakeproducer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	producer := &fakeproducer.Producer{}
+	handler := NewHandler(logging.Config{Producer: producer}, &HandlerOptions{Level: slog.LevelDebug, AddSource: true})
+	logger := slog.New(handler).With("app", "test").WithGroup("req").With("id", 7)
+
+	logger.Debug("hello", "err", errors.New("failed"), slog.Group("user", "name", "alice", "age", 3),
+		slog.Group("", "inline", true), slog.Group("empty"), slog.Time("at", time.Unix(0, 0).UTC()))
+	slog.New(handler).Info("plain")
+
+	logs := producer.Logs()
+	require.Len(t, logs, 2)
+	assert.Equal(t, "DEBUG", logs[0][logging.LevelKey])
+	assert.Equal(t, "hello", logs[0][logging.MessageKey])
+	assert.Contains(t, logs[0][logging.CallerKey], "handler_test.go:")
+	assert.Equal(t, "test", logs[0]["app"])
+	assert.Equal(t, "7", logs[0]["req.id"])
+	assert.Equal(t, "failed", logs[0]["req.err"])
+	assert.Equal(t, "alice", logs[0]["req.user.name"])
+	assert.Equal(t, "3", logs[0]["req.user.age"])
+	assert.Equal(t, "true", logs[0]["req.inline"])
+	assert.Equal(t, "1970-01-01T00:00:00Z", logs[0]["req.at"])
+	for key := range logs[0] {
+		assert.False(t, strings.HasPrefix(key, "req.empty"), key)
+	}
+	assert.Equal(t, map[string]string{logging.LevelKey: "INFO", logging.MessageKey: "plain", logging.CallerKey: logs[1][logging.CallerKey]}, logs[1])
+
+	require.NoError(t, handler.Close())
+	assert.True(t, producer.Closed)
+	assert.ErrorIs(t, handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "closed", 0)), logging.ErrClosed)
+}
+
+func TestHandlerLevel(t *testing.T) {
+	producer := &fakeproducer.Producer{}
+	handler := NewHandler(logging.Config{Producer: producer}, nil)
+	logger := slog.New(handler)
+
+	logger.Debug("dropped")
+	logger.Warn("kept")
+	logs := producer.RawLogs()
+	require.Len(t, logs, 1)
+	assert.Equal(t, 2, len(logs[0].Contents))
+}
+
+func TestHandlerTime(t *testing.T) {
+	producer := &fakeproducer.Producer{}
+	handler := NewHandler(logging.Config{Producer: producer}, nil)
+
+	now := time.Unix(1700000000, 5)
+	require.NoError(t, handler.Handle(context.Background(), slog.NewRecord(now, slog.LevelInfo, "m", 0)))
+	logs := producer.RawLogs()
+	require.Len(t, logs, 1)
+	assert.Equal(t, uint32(1700000000), logs[0].GetTime())
+	assert.Equal(t, uint32(5), logs[0].GetTimeNs())
+}
@@ -0,0 +1,74 @@
+// Package zapsls provides a zapcore.Core sending logs to SLS through a producer.
+package zapsls
+
+import (
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/contrib/logging"
+	"go.uber.org/zap/zapcore"
+)
+
+// Core is a zapcore.Core writing each entry as a log with its level, message, logger name, caller, stack and fields,
+// fields in namespaces and objects are keyed like "namespace.key".
+type Core struct {
+	zapcore.LevelEnabler
+	writer   *logging.Writer
+	contents []*sls.LogContent // fields added by With
+}
+
+// NewCore creates a Core sending the entries enabled by enab with the producer of the config.
+func NewCore(config logging.Config, enab zapcore.LevelEnabler) *Core {
+	return &Core{LevelEnabler: enab, writer: logging.NewWriter(config)}
+}
+
+func (c *Core) With(fields []zapcore.Field) zapcore.Core {
+	if len(fields) == 0 {
+		return c
+	}
+	c2 := *c
+	c2.contents = append(c.contents[:len(c.contents):len(c.contents)], encodeFields(fields)...)
+	return &c2
+}
+
+func (c *Core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *Core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	contents := make([]*sls.LogContent, 0, 5+len(c.contents)+len(fields))
+	contents = append(contents,
+		logging.Content(logging.LevelKey, ent.Level.String()),
+		logging.Content(logging.MessageKey, ent.Message))
+	if ent.LoggerName != "" {
+		contents = append(contents, logging.Content(logging.LoggerKey, ent.LoggerName))
+	}
+	if ent.Caller.Defined {
+		contents = append(contents, logging.Content(logging.CallerKey, ent.Caller.String()))
+	}
+	if ent.Stack != "" {
+		contents = append(contents, logging.Content(logging.StackKey, ent.Stack))
+	}
+	contents = append(contents, c.contents...)
+	contents = append(contents, encodeFields(fields)...)
+	return c.writer.Write(ent.Time, contents)
+}
+
+// Sync does nothing, the logs are sent by the producer asynchronously, call Close to flush them.
+func (c *Core) Sync() error {
+	return nil
+}
+
+// Close closes the producer after the cached logs are sent, it is shared by the cores derived from c.
+func (c *Core) Close() error {
+	return c.writer.Close()
+}
+
+func encodeFields(fields []zapcore.Field) []*sls.LogContent {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+	return logging.AppendMap(nil, "", enc.Fields)
+}
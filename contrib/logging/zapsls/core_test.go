@@ -0,0 +1,57 @@
+package zapsls
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-log-go-sdk/contrib/logging"
+	"github.com/aliyun/aliyun-log-go-sdk/contrib/logging/internal/fakeproducer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestCore(t *testing.T) {
+	producer := &fakeproducer.Producer{}
+	core := NewCore(logging.Config{Producer: producer}, zapcore.InfoLevel)
+	logger := zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel)).Named("svc").With(zap.String("app", "test"))
+
+	logger.Debug("dropped")
+	logger.Info("hello", zap.Int("n", 1), zap.Error(errors.New("failed")),
+		zap.Namespace("req"), zap.String("id", "7"), zap.Duration("cost", time.Second))
+	logger.Error("oops")
+
+	logs := producer.Logs()
+	require.Len(t, logs, 2)
+	assert.Equal(t, "info", logs[0][logging.LevelKey])
+	assert.Equal(t, "hello", logs[0][logging.MessageKey])
+	assert.Equal(t, "svc", logs[0][logging.LoggerKey])
+	assert.Contains(t, logs[0][logging.CallerKey], "core_test.go:")
+	assert.Equal(t, "test", logs[0]["app"])
+	assert.Equal(t, "1", logs[0]["n"])
+	assert.Equal(t, "failed", logs[0]["error"])
+	assert.Equal(t, "7", logs[0]["req.id"])
+	assert.Equal(t, "1s", logs[0]["req.cost"])
+	assert.Empty(t, logs[0][logging.StackKey])
+
+	assert.Equal(t, "error", logs[1][logging.LevelKey])
+	assert.Contains(t, logs[1][logging.StackKey], "TestCore")
+
+	require.NoError(t, logger.Sync())
+	require.NoError(t, core.Close())
+	assert.True(t, producer.Closed)
+	assert.ErrorIs(t, core.Write(zapcore.Entry{Message: "closed"}, nil), logging.ErrClosed)
+}
+
+func TestCoreTime(t *testing.T) {
+	producer := &fakeproducer.Producer{}
+	core := NewCore(logging.Config{Producer: producer}, zapcore.DebugLevel)
+
+	require.NoError(t, core.Write(zapcore.Entry{Time: time.Unix(1700000000, 5), Message: "m"}, nil))
+	logs := producer.RawLogs()
+	require.Len(t, logs, 1)
+	assert.Equal(t, uint32(1700000000), logs[0].GetTime())
+	assert.Equal(t, uint32(5), logs[0].GetTimeNs())
+}
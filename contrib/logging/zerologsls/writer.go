@@ -0,0 +1,94 @@
+// Package zerologsls provides an io.Writer for zerolog sending logs to SLS through a producer.
+package zerologsls
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/contrib/logging"
+	"github.com/rs/zerolog"
+)
+
+// Writer is an io.Writer for zerolog, it parses each JSON event written by a zerolog.Logger into a log
+// with its level, message, caller, stack and fields, fields in objects are keyed like "object.key".
+// The time of the event is parsed in zerolog.TimeFieldFormat, the current time is used if the event has no time.
+type Writer struct {
+	writer *logging.Writer
+}
+
+// NewWriter creates a Writer sending the events with the producer of the config,
+// use it as the output of a logger, eg. zerolog.New(w).With().Timestamp().Logger().
+func NewWriter(config logging.Config) *Writer {
+	return &Writer{writer: logging.NewWriter(config)}
+}
+
+// Write sends the event in p, which is a JSON object.
+func (w *Writer) Write(p []byte) (int, error) {
+	decoder := json.NewDecoder(bytes.NewReader(p))
+	decoder.UseNumber()
+	var event map[string]interface{}
+	if err := decoder.Decode(&event); err != nil {
+		return 0, err
+	}
+
+	var t time.Time
+	if value, ok := event[zerolog.TimestampFieldName]; ok {
+		t = parseTime(value)
+		delete(event, zerolog.TimestampFieldName)
+	}
+	contents := make([]*sls.LogContent, 0, len(event))
+	for _, field := range []struct{ name, key string }{
+		{zerolog.LevelFieldName, logging.LevelKey},
+		{zerolog.MessageFieldName, logging.MessageKey},
+		{zerolog.CallerFieldName, logging.CallerKey},
+		{zerolog.ErrorStackFieldName, logging.StackKey},
+	} {
+		if value, ok := event[field.name]; ok {
+			contents = append(contents, logging.Content(field.key, logging.FormatValue(value)))
+			delete(event, field.name)
+		}
+	}
+	contents = logging.AppendMap(contents, "", event)
+	if err := w.writer.Write(t, contents); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the producer after the cached logs are sent.
+func (w *Writer) Close() error {
+	return w.writer.Close()
+}
+
+// parseTime parses the time of an event, zero is returned if it isn't in zerolog.TimeFieldFormat
+func parseTime(value interface{}) time.Time {
+	if s, ok := value.(string); ok {
+		t, _ := time.Parse(zerolog.TimeFieldFormat, s)
+		return t
+	}
+	n, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}
+	}
+	i, err := n.Int64()
+	if err != nil {
+		// not an integer, eg. written by a custom TimestampFunc
+		f, err := n.Float64()
+		if err != nil {
+			return time.Time{}
+		}
+		return time.Unix(0, int64(f*float64(time.Second)))
+	}
+	switch zerolog.TimeFieldFormat {
+	case zerolog.TimeFormatUnixMs:
+		return time.UnixMilli(i)
+	case zerolog.TimeFormatUnixMicro:
+		return time.UnixMicro(i)
+	case zerolog.TimeFormatUnixNano:
+		return time.Unix(0, i)
+	default:
+		return time.Unix(i, 0)
+	}
+}
@@ -0,0 +1,75 @@
+package zerologsls
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-log-go-sdk/contrib/logging"
+	"github.com/aliyun/aliyun-log-go-sdk/contrib/logging/internal/fakeproducer"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	producer := &fakeproducer.Producer{}
+	writer := NewWriter(logging.Config{Producer: producer})
+	logger := zerolog.New(writer).With().Timestamp().Caller().Str("app", "test").Logger()
+
+	logger.Warn().Int("n", 1).Err(errors.New("failed")).
+		Dict("req", zerolog.Dict().Str("id", "7").Dict("user", zerolog.Dict().Str("name", "alice"))).
+		Msg("hello")
+
+	logs := producer.Logs()
+	require.Len(t, logs, 1)
+	assert.Equal(t, "warn", logs[0][logging.LevelKey])
+	assert.Equal(t, "hello", logs[0][logging.MessageKey])
+	assert.Contains(t, logs[0][logging.CallerKey], "writer_test.go:")
+	assert.Equal(t, "test", logs[0]["app"])
+	assert.Equal(t, "1", logs[0]["n"])
+	assert.Equal(t, "failed", logs[0][zerolog.ErrorFieldName])
+	assert.Equal(t, "7", logs[0]["req.id"])
+	assert.Equal(t, "alice", logs[0]["req.user.name"])
+	assert.NotContains(t, logs[0], zerolog.TimestampFieldName)
+	assert.NotZero(t, producer.RawLogs()[0].GetTime())
+
+	require.NoError(t, writer.Close())
+	assert.True(t, producer.Closed)
+	_, err := writer.Write([]byte(`{"message":"closed"}`))
+	assert.ErrorIs(t, err, logging.ErrClosed)
+}
+
+func TestWriterTime(t *testing.T) {
+	defer func(format string) { zerolog.TimeFieldFormat = format }(zerolog.TimeFieldFormat)
+	now := time.Unix(1700000000, 123456789)
+	for _, tt := range []struct {
+		format string
+		event  string
+		want   time.Time
+	}{
+		{time.RFC3339Nano, `{"time":"2023-11-14T22:13:20.123456789Z"}`, now},
+		{time.RFC3339, `{"time":"2023-11-14T22:13:20Z"}`, time.Unix(1700000000, 0)},
+		{zerolog.TimeFormatUnix, `{"time":1700000000}`, time.Unix(1700000000, 0)},
+		{zerolog.TimeFormatUnixMs, `{"time":1700000000123}`, time.UnixMilli(1700000000123)},
+		{zerolog.TimeFormatUnixMicro, `{"time":1700000000123456}`, time.UnixMicro(1700000000123456)},
+		{zerolog.TimeFormatUnixNano, `{"time":1700000000123456789}`, now},
+	} {
+		zerolog.TimeFieldFormat = tt.format
+		producer := &fakeproducer.Producer{}
+		writer := NewWriter(logging.Config{Producer: producer})
+		n, err := writer.Write([]byte(tt.event + "\n"))
+		require.NoError(t, err)
+		assert.Equal(t, len(tt.event)+1, n)
+		logs := producer.RawLogs()
+		require.Len(t, logs, 1)
+		assert.Equal(t, uint32(tt.want.Unix()), logs[0].GetTime(), tt.format)
+		assert.Equal(t, uint32(tt.want.Nanosecond()), logs[0].GetTimeNs(), tt.format)
+	}
+}
+
+func TestWriterInvalidEvent(t *testing.T) {
+	writer := NewWriter(logging.Config{Producer: &fakeproducer.Producer{}})
+	_, err := writer.Write([]byte("not json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,12 @@
+// Package otelsls exports OpenTelemetry spans and log records to SLS logstores through a producer,
+// without running a collector.
+//
+// SpanExporter is a sdk/trace SpanExporter writing spans in the SLS trace schema, and LogExporter
+// is a sdk/log Exporter writing log records in the SLS log schema. The resource attributes, span
+// attributes, links and events are written as JSON fields. Each exporter sends its signal to the
+// logstore of its Config, and Routes send the data of some resources to other logstores by the
+// value of a resource attribute.
+//
+// The OpenTelemetry SDK requires a newer Go version than the SLS SDK, the exporters are kept
+// in their own module so that programs not using OpenTelemetry are not affected.
+package otelsls
@@ -0,0 +1,216 @@
+package otelsls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// keys of the contents shared by spans and log records, the same as the SLS trace and log schema
+const (
+	HostKey         = "host"
+	ServiceKey      = "service"
+	ResourceKey     = "resource"
+	AttributeKey    = "attribute"
+	ScopeNameKey    = "otlp.name"
+	ScopeVersionKey = "otlp.version"
+	TraceIDKey      = "traceID"
+	SpanIDKey       = "spanID"
+	NameKey         = "name"
+)
+
+const (
+	hostNameKey    = attribute.Key("host.name")
+	serviceNameKey = attribute.Key("service.name")
+
+	defaultContentsCapacity = 16
+	// the producer is still closed if the deadline of the shutdown has passed,
+	// it stops accepting logs without waiting for the cached ones
+	minCloseTimeoutMs = 1
+)
+
+// ErrShutdown is returned when data is exported after the exporter is shut down.
+var ErrShutdown = errors.New("otelsls: exporter is shut down")
+
+// Producer is the part of *producer.Producer used to send logs.
+type Producer interface {
+	SendLogList(project, logstore, topic, source string, logList []*sls.Log) error
+	Close(timeoutMs int64) error
+	SafeClose()
+}
+
+// Route sends the data of the resources with an attribute of the value to another logstore.
+type Route struct {
+	Attribute attribute.Key
+	// Value of the attribute, the route matches any value if it is empty
+	Value string
+	// Project of the logstore, Config.Project is used if it is empty
+	Project  string
+	Logstore string
+}
+
+// Config is the destination of a signal, and the producer sending it.
+type Config struct {
+	// Producer must be started, it is closed when the exporter is shut down,
+	// so give each exporter its own producer.
+	Producer Producer
+	Project  string
+	// Logstore receives the data of the resources no route matches
+	Logstore string
+	// Routes are checked in order against the resource of the data, the first matched is used
+	Routes []Route
+	Topic  string
+	Source string
+}
+
+type destination struct {
+	project, logstore string
+}
+
+// sender sends the logs converted from the data of a signal to the logstores routed.
+type sender struct {
+	config       Config
+	mutex        sync.RWMutex
+	shutdown     bool
+	shutdownOnce sync.Once
+}
+
+func (s *sender) route(res *resource.Resource) destination {
+	for _, route := range s.config.Routes {
+		value, ok := res.Set().Value(route.Attribute)
+		if !ok || (route.Value != "" && value.String() != route.Value) {
+			continue
+		}
+		if route.Project != "" {
+			return destination{route.Project, route.Logstore}
+		}
+		return destination{s.config.Project, route.Logstore}
+	}
+	return destination{s.config.Project, s.config.Logstore}
+}
+
+// send sends the logs of each resource to the logstore routed
+func (s *sender) send(ctx context.Context, logs map[*resource.Resource][]*sls.Log) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.shutdown {
+		return ErrShutdown
+	}
+	grouped := make(map[destination][]*sls.Log, 1)
+	for res, logList := range logs {
+		dest := s.route(res)
+		grouped[dest] = append(grouped[dest], logList...)
+	}
+	var errs []error
+	for dest, logList := range grouped {
+		if err := s.config.Producer.SendLogList(dest.project, dest.logstore, s.config.Topic, s.config.Source, logList); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// close closes the producer after the cached logs are sent, until the deadline of ctx if it has one.
+// It returns ctx.Err() if ctx is done before the cached logs are sent.
+func (s *sender) close(ctx context.Context) error {
+	var err error
+	s.shutdownOnce.Do(func() {
+		s.mutex.Lock()
+		s.shutdown = true
+		s.mutex.Unlock()
+		if deadline, ok := ctx.Deadline(); ok {
+			timeoutMs := time.Until(deadline).Milliseconds()
+			if timeoutMs < minCloseTimeoutMs {
+				timeoutMs = minCloseTimeoutMs
+			}
+			if err = s.config.Producer.Close(timeoutMs); err != nil && ctx.Err() != nil {
+				err = ctx.Err()
+			}
+		} else {
+			s.config.Producer.SafeClose()
+		}
+	})
+	return err
+}
+
+func newLog(t time.Time) *sls.Log {
+	return &sls.Log{
+		Time:     proto.Uint32(uint32(t.Unix())),
+		TimeNs:   proto.Uint32(uint32(t.Nanosecond())),
+		Contents: make([]*sls.LogContent, 0, defaultContentsCapacity),
+	}
+}
+
+func addContent(log *sls.Log, key, value string) {
+	log.Contents = append(log.Contents, &sls.LogContent{Key: proto.String(key), Value: proto.String(value)})
+}
+
+// addResource adds the host, service and attributes of the resource, and the name and version of the scope
+func addResource(log *sls.Log, res *resource.Resource, scope instrumentation.Scope) {
+	set := res.Set()
+	host, _ := set.Value(hostNameKey)
+	service, _ := set.Value(serviceNameKey)
+	addContent(log, HostKey, host.String())
+	addContent(log, ServiceKey, service.String())
+	addContent(log, ResourceKey, attributesJSON(set.ToSlice()))
+	addContent(log, ScopeNameKey, scope.Name)
+	addContent(log, ScopeVersionKey, scope.Version)
+}
+
+// attributesJSON formats the attributes as a JSON object
+func attributesJSON(attrs []attribute.KeyValue) string {
+	return marshalJSON(attributesMap(attrs))
+}
+
+func attributesMap(attrs []attribute.KeyValue) map[string]interface{} {
+	m := make(map[string]interface{}, len(attrs))
+	for _, attr := range attrs {
+		m[string(attr.Key)] = jsonValue(attr.Value)
+	}
+	return m
+}
+
+// jsonValue converts the value to the one encoded in JSON, bytes are encoded in base64,
+// and floats JSON can't represent are formatted as strings
+func jsonValue(v attribute.Value) interface{} {
+	switch v.Type() {
+	case attribute.FLOAT64:
+		if f := v.AsFloat64(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return v.String()
+		}
+		return v.AsFloat64()
+	case attribute.FLOAT64SLICE:
+		return json.RawMessage(v.String())
+	case attribute.BYTESLICE:
+		return v.String()
+	case attribute.SLICE:
+		values := v.AsSlice()
+		s := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			s = append(s, jsonValue(value))
+		}
+		return s
+	default:
+		return v.AsInterface()
+	}
+}
+
+func marshalJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,123 @@
+package otelsls
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+type fakeProducer struct {
+	mutex     sync.Mutex
+	logs      map[string][]*sls.Log // by project/logstore
+	closed    bool
+	timeoutMs int64
+	closeErr  error
+}
+
+func (p *fakeProducer) SendLogList(project, logstore, topic, source string, logList []*sls.Log) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.logs == nil {
+		p.logs = make(map[string][]*sls.Log)
+	}
+	p.logs[project+"/"+logstore] = append(p.logs[project+"/"+logstore], logList...)
+	return nil
+}
+
+func (p *fakeProducer) Close(timeoutMs int64) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed, p.timeoutMs = true, timeoutMs
+	return p.closeErr
+}
+
+func (p *fakeProducer) SafeClose() {
+	p.Close(0)
+}
+
+// contents returns the logs sent to the logstore, each as a map of its contents
+func (p *fakeProducer) contents(project, logstore string) []map[string]string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var logs []map[string]string
+	for _, log := range p.logs[project+"/"+logstore] {
+		contents := make(map[string]string, len(log.Contents))
+		for _, content := range log.Contents {
+			contents[content.GetKey()] = content.GetValue()
+		}
+		logs = append(logs, contents)
+	}
+	return logs
+}
+
+func TestSenderRoute(t *testing.T) {
+	s := &sender{config: Config{
+		Project:  "p",
+		Logstore: "default",
+		Routes: []Route{
+			{Attribute: "env", Value: "prod", Logstore: "prod"},
+			{Attribute: "tenant", Project: "tenant-project", Logstore: "tenant"},
+		},
+	}}
+	for _, tt := range []struct {
+		attrs []attribute.KeyValue
+		want  destination
+	}{
+		{nil, destination{"p", "default"}},
+		{[]attribute.KeyValue{attribute.String("env", "test")}, destination{"p", "default"}},
+		{[]attribute.KeyValue{attribute.String("env", "prod")}, destination{"p", "prod"}},
+		{[]attribute.KeyValue{attribute.String("tenant", "a")}, destination{"tenant-project", "tenant"}},
+		{[]attribute.KeyValue{attribute.String("env", "prod"), attribute.String("tenant", "a")}, destination{"p", "prod"}},
+	} {
+		assert.Equal(t, tt.want, s.route(resource.NewSchemaless(tt.attrs...)), tt.attrs)
+	}
+	assert.Equal(t, destination{"p", "default"}, s.route(nil))
+}
+
+func TestSenderShutdown(t *testing.T) {
+	producer := &fakeProducer{}
+	s := &sender{config: Config{Producer: producer, Project: "p", Logstore: "l"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, s.send(ctx, nil), context.Canceled)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	require.NoError(t, s.close(ctx))
+	assert.True(t, producer.closed)
+	assert.Greater(t, producer.timeoutMs, int64(0))
+	assert.True(t, errors.Is(s.send(context.Background(), nil), ErrShutdown))
+	assert.NoError(t, s.close(context.Background()))
+}
+
+func TestSenderShutdownDeadlinePassed(t *testing.T) {
+	producer := &fakeProducer{closeErr: errors.New("TimeoutException")}
+	s := &sender{config: Config{Producer: producer, Project: "p", Logstore: "l"}}
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	assert.ErrorIs(t, s.close(ctx), context.DeadlineExceeded)
+	assert.True(t, producer.closed)
+	assert.Equal(t, int64(minCloseTimeoutMs), producer.timeoutMs)
+}
+
+func TestJSONValue(t *testing.T) {
+	attrs := []attribute.KeyValue{
+		attribute.String("s", "v"),
+		attribute.Int("i", 1),
+		attribute.Float64("f", 1.5),
+		attribute.Bool("b", true),
+		attribute.StringSlice("ss", []string{"a", "b"}),
+		attribute.Float64Slice("fs", []float64{0.5}),
+	}
+	assert.JSONEq(t, `{"s":"v","i":1,"f":1.5,"b":true,"ss":["a","b"],"fs":[0.5]}`, attributesJSON(attrs))
+}
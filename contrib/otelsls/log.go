@@ -0,0 +1,139 @@
+package otelsls
+
+import (
+	"context"
+	"encoding/base64"
+	"math"
+	"strconv"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// keys of the contents of a log record, the same as the SLS log schema
+const (
+	TimeUnixNanoKey   = "timeUnixNano"
+	SeverityNumberKey = "severityNumber"
+	SeverityTextKey   = "severityText"
+	ContentKey        = "content"
+	FlagsKey          = "flags"
+)
+
+// LogExporter is a sdklog.Exporter writing each log record as a log of the SLS log schema,
+// the body is the content, and the name is the event name of the record.
+// The time of the log is the timestamp of the record, or the observed timestamp if it isn't set.
+type LogExporter struct {
+	sender
+}
+
+var _ sdklog.Exporter = (*LogExporter)(nil)
+
+// NewLogExporter creates a LogExporter sending the log records with the producer of the config,
+// use it with a batch processor, eg. sdklog.NewBatchProcessor(exporter).
+func NewLogExporter(config Config) *LogExporter {
+	return &LogExporter{sender: sender{config: config}}
+}
+
+func (e *LogExporter) Export(ctx context.Context, records []sdklog.Record) error {
+	logs := make(map[*resource.Resource][]*sls.Log, 1)
+	for i := range records {
+		res := records[i].Resource()
+		logs[res] = append(logs[res], recordToLog(&records[i]))
+	}
+	return e.send(ctx, logs)
+}
+
+// ForceFlush does nothing, the logs are sent by the producer asynchronously, call Shutdown to flush them.
+func (e *LogExporter) ForceFlush(ctx context.Context) error {
+	return nil
+}
+
+// Shutdown closes the producer after the cached records are sent, until the deadline of ctx if it has one.
+func (e *LogExporter) Shutdown(ctx context.Context) error {
+	return e.close(ctx)
+}
+
+func recordToLog(record *sdklog.Record) *sls.Log {
+	t := record.Timestamp()
+	if t.IsZero() {
+		t = record.ObservedTimestamp()
+	}
+	slsLog := newLog(t)
+	addResource(slsLog, record.Resource(), record.InstrumentationScope())
+
+	addContent(slsLog, TimeUnixNanoKey, strconv.FormatInt(t.UnixNano(), 10))
+	addContent(slsLog, SeverityNumberKey, strconv.Itoa(int(record.Severity())))
+	addContent(slsLog, SeverityTextKey, record.SeverityText())
+	addContent(slsLog, NameKey, record.EventName())
+	addContent(slsLog, ContentKey, bodyString(record.Body()))
+
+	attrs := make(map[string]interface{}, record.AttributesLen())
+	record.WalkAttributes(func(attr log.KeyValue) bool {
+		attrs[attr.Key] = logJSONValue(attr.Value)
+		return true
+	})
+	addContent(slsLog, AttributeKey, marshalJSON(attrs))
+	addContent(slsLog, FlagsKey, strconv.Itoa(int(record.TraceFlags())))
+	traceID, spanID := "", ""
+	if record.TraceID().IsValid() {
+		traceID = record.TraceID().String()
+	}
+	if record.SpanID().IsValid() {
+		spanID = record.SpanID().String()
+	}
+	addContent(slsLog, TraceIDKey, traceID)
+	addContent(slsLog, SpanIDKey, spanID)
+	return slsLog
+}
+
+// bodyString formats the body as the content, strings are kept as they are, bytes are encoded in base64,
+// and other values are formatted in JSON
+func bodyString(v log.Value) string {
+	switch v.Kind() {
+	case log.KindEmpty:
+		return ""
+	case log.KindString:
+		return v.AsString()
+	case log.KindBytes:
+		return base64.StdEncoding.EncodeToString(v.AsBytes())
+	default:
+		return marshalJSON(logJSONValue(v))
+	}
+}
+
+// logJSONValue converts the value of a log record to the one encoded in JSON, the same as jsonValue
+func logJSONValue(v log.Value) interface{} {
+	switch v.Kind() {
+	case log.KindBool:
+		return v.AsBool()
+	case log.KindInt64:
+		return v.AsInt64()
+	case log.KindFloat64:
+		if f := v.AsFloat64(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+		return v.AsFloat64()
+	case log.KindString:
+		return v.AsString()
+	case log.KindBytes:
+		return base64.StdEncoding.EncodeToString(v.AsBytes())
+	case log.KindSlice:
+		values := v.AsSlice()
+		s := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			s = append(s, logJSONValue(value))
+		}
+		return s
+	case log.KindMap:
+		kvs := v.AsMap()
+		m := make(map[string]interface{}, len(kvs))
+		for _, kv := range kvs {
+			m[kv.Key] = logJSONValue(kv.Value)
+		}
+		return m
+	default:
+		return nil
+	}
+}
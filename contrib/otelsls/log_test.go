@@ -0,0 +1,71 @@
+package otelsls
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestLogExporter(t *testing.T) {
+	producer := &fakeProducer{}
+	exporter := NewLogExporter(Config{Producer: producer, Project: "p", Logstore: "logs"})
+	res := resource.NewSchemaless(attribute.String("service.name", "svc"))
+	provider := sdklog.NewLoggerProvider(sdklog.WithProcessor(sdklog.NewSimpleProcessor(exporter)), sdklog.WithResource(res))
+	logger := provider.Logger("lib", log.WithInstrumentationVersion("1.0"))
+
+	traceID, _ := trace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+	spanID, _ := trace.SpanIDFromHex("0102030405060708")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled,
+	}))
+	now := time.Unix(1700000000, 5)
+	var record log.Record
+	record.SetTimestamp(now)
+	record.SetSeverity(log.SeverityWarn)
+	record.SetSeverityText("WARN")
+	record.SetEventName("login")
+	record.SetBody(log.StringValue("hello"))
+	record.AddAttributes(log.Int("n", 1), log.Map("user", log.String("name", "alice")))
+	logger.Emit(ctx, record)
+
+	var observed log.Record
+	observed.SetObservedTimestamp(now.Add(time.Second))
+	observed.SetBody(log.MapValue(log.String("k", "v")))
+	logger.Emit(context.Background(), observed)
+
+	logs := producer.contents("p", "logs")
+	require.Len(t, logs, 2)
+	assert.Equal(t, "svc", logs[0][ServiceKey])
+	assert.Equal(t, "lib", logs[0][ScopeNameKey])
+	assert.Equal(t, "1.0", logs[0][ScopeVersionKey])
+	assert.Equal(t, strconv.FormatInt(now.UnixNano(), 10), logs[0][TimeUnixNanoKey])
+	assert.Equal(t, "13", logs[0][SeverityNumberKey])
+	assert.Equal(t, "WARN", logs[0][SeverityTextKey])
+	assert.Equal(t, "login", logs[0][NameKey])
+	assert.Equal(t, "hello", logs[0][ContentKey])
+	assert.JSONEq(t, `{"n":1,"user":{"name":"alice"}}`, logs[0][AttributeKey])
+	assert.Equal(t, "1", logs[0][FlagsKey])
+	assert.Equal(t, traceID.String(), logs[0][TraceIDKey])
+	assert.Equal(t, spanID.String(), logs[0][SpanIDKey])
+	assert.Equal(t, uint32(5), producer.logs["p/logs"][0].GetTimeNs())
+
+	assert.Equal(t, strconv.FormatInt(now.Add(time.Second).UnixNano(), 10), logs[1][TimeUnixNanoKey])
+	assert.JSONEq(t, `{"k":"v"}`, logs[1][ContentKey])
+	assert.Equal(t, "", logs[1][TraceIDKey])
+	assert.Equal(t, "{}", logs[1][AttributeKey])
+
+	require.NoError(t, provider.ForceFlush(context.Background()))
+	require.NoError(t, provider.Shutdown(context.Background()))
+	assert.True(t, producer.closed)
+	assert.True(t, errors.Is(exporter.Export(context.Background(), nil), ErrShutdown))
+}
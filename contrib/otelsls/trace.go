@@ -0,0 +1,128 @@
+package otelsls
+
+import (
+	"context"
+	"strconv"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// keys of the contents of a span, the same as the SLS trace schema
+const (
+	ParentSpanIDKey  = "parentSpanID"
+	KindKey          = "kind"
+	TraceStateKey    = "traceState"
+	StartKey         = "start"
+	EndKey           = "end"
+	DurationKey      = "duration"
+	StatusCodeKey    = "statusCode"
+	StatusMessageKey = "statusMessage"
+	LinksKey         = "links"
+	EventsKey        = "logs"
+)
+
+// SpanExporter is a sdktrace.SpanExporter writing each span as a log of the SLS trace schema,
+// the start, end and duration are in microseconds, and the links and events are JSON arrays.
+// The time of the log is the start time of the span.
+type SpanExporter struct {
+	sender
+}
+
+var _ sdktrace.SpanExporter = (*SpanExporter)(nil)
+
+// NewSpanExporter creates a SpanExporter sending the spans with the producer of the config,
+// use it with a batch span processor, eg. sdktrace.WithBatcher(exporter).
+func NewSpanExporter(config Config) *SpanExporter {
+	return &SpanExporter{sender: sender{config: config}}
+}
+
+func (e *SpanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	logs := make(map[*resource.Resource][]*sls.Log, 1)
+	for _, span := range spans {
+		logs[span.Resource()] = append(logs[span.Resource()], spanToLog(span))
+	}
+	return e.send(ctx, logs)
+}
+
+// Shutdown closes the producer after the cached spans are sent, until the deadline of ctx if it has one.
+func (e *SpanExporter) Shutdown(ctx context.Context) error {
+	return e.close(ctx)
+}
+
+func spanToLog(span sdktrace.ReadOnlySpan) *sls.Log {
+	start, end := span.StartTime(), span.EndTime()
+	log := newLog(start)
+	addResource(log, span.Resource(), span.InstrumentationScope())
+
+	spanContext := span.SpanContext()
+	addContent(log, TraceIDKey, spanContext.TraceID().String())
+	addContent(log, SpanIDKey, spanContext.SpanID().String())
+	parentSpanID := ""
+	if span.Parent().HasSpanID() {
+		parentSpanID = span.Parent().SpanID().String()
+	}
+	addContent(log, ParentSpanIDKey, parentSpanID)
+	addContent(log, NameKey, span.Name())
+	addContent(log, KindKey, spanKindString(span.SpanKind()))
+	addContent(log, TraceStateKey, spanContext.TraceState().String())
+	addContent(log, StartKey, strconv.FormatInt(start.UnixMicro(), 10))
+	addContent(log, EndKey, strconv.FormatInt(end.UnixMicro(), 10))
+	addContent(log, DurationKey, strconv.FormatInt(end.Sub(start).Microseconds(), 10))
+	addContent(log, AttributeKey, attributesJSON(span.Attributes()))
+	addContent(log, StatusCodeKey, statusCodeString(span.Status().Code))
+	addContent(log, StatusMessageKey, span.Status().Description)
+
+	links := make([]map[string]interface{}, 0, len(span.Links()))
+	for _, link := range span.Links() {
+		links = append(links, map[string]interface{}{
+			TraceIDKey:    link.SpanContext.TraceID().String(),
+			SpanIDKey:     link.SpanContext.SpanID().String(),
+			TraceStateKey: link.SpanContext.TraceState().String(),
+			AttributeKey:  attributesMap(link.Attributes),
+		})
+	}
+	addContent(log, LinksKey, marshalJSON(links))
+
+	events := make([]map[string]interface{}, 0, len(span.Events()))
+	for _, event := range span.Events() {
+		events = append(events, map[string]interface{}{
+			NameKey:      event.Name,
+			"time":       event.Time.UnixNano(),
+			AttributeKey: attributesMap(event.Attributes),
+		})
+	}
+	addContent(log, EventsKey, marshalJSON(events))
+	return log
+}
+
+func spanKindString(kind trace.SpanKind) string {
+	switch kind {
+	case trace.SpanKindInternal:
+		return "internal"
+	case trace.SpanKindServer:
+		return "server"
+	case trace.SpanKindClient:
+		return "client"
+	case trace.SpanKindProducer:
+		return "producer"
+	case trace.SpanKindConsumer:
+		return "consumer"
+	default:
+		return ""
+	}
+}
+
+func statusCodeString(code codes.Code) string {
+	switch code {
+	case codes.Ok:
+		return "OK"
+	case codes.Error:
+		return "ERROR"
+	default:
+		return "UNSET"
+	}
+}
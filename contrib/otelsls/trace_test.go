@@ -0,0 +1,107 @@
+package otelsls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSpanExporter(t *testing.T) {
+	producer := &fakeProducer{}
+	exporter := NewSpanExporter(Config{
+		Producer: producer,
+		Project:  "p",
+		Logstore: "traces",
+		Routes:   []Route{{Attribute: "tenant", Value: "a", Logstore: "traces-a"}},
+	})
+	res := resource.NewSchemaless(attribute.String("service.name", "svc"), attribute.String("host.name", "h"))
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter), sdktrace.WithResource(res))
+	tracer := provider.Tracer("lib", trace.WithInstrumentationVersion("1.0"))
+
+	start := time.Unix(1700000000, 123456789)
+	ctx, parent := tracer.Start(context.Background(), "parent", trace.WithTimestamp(start), trace.WithSpanKind(trace.SpanKindServer))
+	linked := parent.SpanContext()
+	_, child := tracer.Start(ctx, "child", trace.WithTimestamp(start),
+		trace.WithAttributes(attribute.Int("n", 1)),
+		trace.WithLinks(trace.Link{SpanContext: linked, Attributes: []attribute.KeyValue{attribute.String("k", "v")}}))
+	child.AddEvent("retry", trace.WithTimestamp(start.Add(time.Millisecond)), trace.WithAttributes(attribute.Int("attempt", 2)))
+	child.SetStatus(codes.Error, "failed")
+	child.End(trace.WithTimestamp(start.Add(1500 * time.Microsecond)))
+	parent.End(trace.WithTimestamp(start.Add(2 * time.Millisecond)))
+
+	logs := producer.contents("p", "traces")
+	require.Len(t, logs, 2)
+	span := logs[0]
+	assert.Equal(t, "child", span[NameKey])
+	assert.Equal(t, "svc", span[ServiceKey])
+	assert.Equal(t, "h", span[HostKey])
+	assert.JSONEq(t, `{"service.name":"svc","host.name":"h"}`, span[ResourceKey])
+	assert.Equal(t, "lib", span[ScopeNameKey])
+	assert.Equal(t, "1.0", span[ScopeVersionKey])
+	assert.Equal(t, linked.TraceID().String(), span[TraceIDKey])
+	assert.Equal(t, linked.SpanID().String(), span[ParentSpanIDKey])
+	assert.Len(t, span[SpanIDKey], 16)
+	assert.Equal(t, "internal", span[KindKey])
+	assert.Equal(t, strconv.FormatInt(start.UnixMicro(), 10), span[StartKey])
+	assert.Equal(t, strconv.FormatInt(start.UnixMicro()+1500, 10), span[EndKey])
+	assert.Equal(t, "1500", span[DurationKey])
+	assert.JSONEq(t, `{"n":1}`, span[AttributeKey])
+	assert.Equal(t, "ERROR", span[StatusCodeKey])
+	assert.Equal(t, "failed", span[StatusMessageKey])
+
+	var links []map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(span[LinksKey]), &links))
+	require.Len(t, links, 1)
+	assert.Equal(t, linked.SpanID().String(), links[0][SpanIDKey])
+	assert.Equal(t, map[string]interface{}{"k": "v"}, links[0][AttributeKey])
+	var events []map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(span[EventsKey]), &events))
+	require.Len(t, events, 1)
+	assert.Equal(t, "retry", events[0][NameKey])
+	assert.Equal(t, float64(start.Add(time.Millisecond).UnixNano()), events[0]["time"])
+	assert.Equal(t, map[string]interface{}{"attempt": float64(2)}, events[0][AttributeKey])
+
+	assert.Equal(t, "parent", logs[1][NameKey])
+	assert.Equal(t, "server", logs[1][KindKey])
+	assert.Equal(t, "", logs[1][ParentSpanIDKey])
+	assert.Equal(t, "UNSET", logs[1][StatusCodeKey])
+	assert.Equal(t, "[]", logs[1][LinksKey])
+
+	raw := producer.logs["p/traces"][0]
+	assert.Equal(t, uint32(1700000000), raw.GetTime())
+	assert.Equal(t, uint32(123456789), raw.GetTimeNs())
+
+	require.NoError(t, provider.Shutdown(context.Background()))
+	assert.True(t, producer.closed)
+	assert.True(t, errors.Is(exporter.ExportSpans(context.Background(), nil), ErrShutdown))
+}
+
+func TestSpanExporterRoutes(t *testing.T) {
+	producer := &fakeProducer{}
+	exporter := NewSpanExporter(Config{
+		Producer: producer,
+		Project:  "p",
+		Logstore: "traces",
+		Routes:   []Route{{Attribute: "tenant", Value: "a", Logstore: "traces-a"}},
+	})
+	spans := make([]sdktrace.ReadOnlySpan, 0, 3)
+	for _, tenant := range []string{"a", "b", "a"} {
+		stub := tracetest.SpanStub{Name: "s", Resource: resource.NewSchemaless(attribute.String("tenant", tenant))}
+		spans = append(spans, stub.Snapshot())
+	}
+	require.NoError(t, exporter.ExportSpans(context.Background(), spans))
+	assert.Len(t, producer.contents("p", "traces-a"), 2)
+	assert.Len(t, producer.contents("p", "traces"), 1)
+}
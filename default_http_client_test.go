@@ -2,6 +2,7 @@ package sls
 
 import (
 	"net/http"
+	"sync"
 	"testing"
 	"time"
 
@@ -46,6 +47,26 @@ func TestClientHttpClient(t *testing.T) {
 	}
 }
 
+func TestClientHttpClientConcurrentInit(t *testing.T) {
+	client := CreateNormalInterface("cn-hangzhou.log.aliyuncs.com", "", "", "").(*Client)
+	projects := make(chan *LogProject, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(projects); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			projects <- convert(client, "test")
+		}()
+	}
+	wg.Wait()
+	close(projects)
+	// the requests sent concurrently share the http client created on the first request
+	for p := range projects {
+		assert.Same(t, client.HTTPClient, p.httpClient)
+		assert.Equal(t, defaultRetryTimeout, p.retryTimeout)
+	}
+}
+
 func TestProjectHttpClient(t *testing.T) {
 	assert.NotEqual(t, defaultRequestTimeout, time.Second*33)
 	{
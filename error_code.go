@@ -33,6 +33,7 @@ const PARAMETER_INVALID = "ParameterInvalid"
 const INVALID_LOGSTORE_QUERY = "InvalidLogStoreQuery"
 const LOGSTORE_WITHOUT_SHARD = "LogStoreWithoutShard"
 const SHARD_NOT_EXIST = "ShardNotExist"
+const SHARD_READ_ONLY = "ShardReadOnly"
 const INVALID_CURSOR = "InvalidCursor"
 const POST_BODY_INVALID = "PostBodyInvalid"
 const INVALID_TIMESTAMP = "InvalidTimestamp"
@@ -56,16 +56,6 @@ func process(shardId int, logGroupList *sls.LogGroupList, checkpointTracker cons
 	return "", nil
 }
 
-func updateCheckpoint(config consumerLibrary.LogHubConfig, client sls.ClientInterface, shardId int) error {
-	from := fmt.Sprintf("%d", time.Now().Unix())
-	cursor, err := client.GetCursor(config.Project, config.Logstore, shardId, from)
-	if err != nil {
-		fmt.Println(err)
-	}
-	return client.UpdateCheckpoint(config.Project, config.Logstore, config.ConsumerGroupName, "", shardId, cursor, true)
-
-}
-
 func UpdateConsumerGroupCheckPoint(config consumerLibrary.LogHubConfig) error {
 	client := sls.CreateNormalInterface(
 		config.Endpoint,
@@ -73,16 +63,8 @@ func UpdateConsumerGroupCheckPoint(config consumerLibrary.LogHubConfig) error {
 		config.AccessKeySecret,
 		"",
 	)
-	shards, err := client.ListShards(config.Project, config.Logstore)
-	if err != nil {
-		return err
-	} else {
-		for _, v := range shards {
-			err = updateCheckpoint(config, client, v.ShardID)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	return nil
+	admin := consumerLibrary.NewConsumerGroupAdmin(client, config.Project, config.Logstore, config.ConsumerGroupName)
+	// set admin.DryRun = true to print the checkpoints instead of writing them
+	_, err := admin.ResetCheckpoints(fmt.Sprintf("%d", time.Now().Unix()))
+	return err
 }
@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"math/bits"
+	"sync"
+)
+
+const (
+	minBufferSizeClass = 10 // 1KB
+	maxBufferSizeClass = 25 // 32MB, larger buffers are not pooled
+)
+
+// pools of buffers by size class, buffers in bufferPools[i] have a capacity of at least 1<<(i+minBufferSizeClass)
+var bufferPools [maxBufferSizeClass - minBufferSizeClass + 1]sync.Pool
+
+// GetBuffer returns an empty buffer with a capacity of at least size, call PutBuffer when it is no longer used.
+func GetBuffer(size int) []byte {
+	class := minBufferSizeClass
+	if size > 1<<minBufferSizeClass {
+		class = bits.Len(uint(size - 1))
+	}
+	if class > maxBufferSizeClass {
+		return make([]byte, 0, size)
+	}
+	if buf, ok := bufferPools[class-minBufferSizeClass].Get().(*[]byte); ok {
+		return (*buf)[:0]
+	}
+	return make([]byte, 0, 1<<class)
+}
+
+// PutBuffer puts a buffer got from GetBuffer, or grown from one by append, back to the pool.
+// The buffer must not be used by the caller afterwards.
+func PutBuffer(buf []byte) {
+	class := bits.Len(uint(cap(buf))) - 1
+	if class < minBufferSizeClass || class > maxBufferSizeClass {
+		return
+	}
+	bufferPools[class-minBufferSizeClass].Put(&buf)
+}
+
+// GrowBuffer returns buf with room for n more bytes. If buf is not large enough, its content is copied
+// to a pooled buffer twice as large, and buf is put back to the pool.
+func GrowBuffer(buf []byte, n int) []byte {
+	if cap(buf)-len(buf) >= n {
+		return buf
+	}
+	grown := append(GetBuffer(2*(len(buf)+n)), buf...)
+	PutBuffer(buf)
+	return grown
+}
@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"strconv"
 
+	"github.com/aliyun/aliyun-log-go-sdk/internal"
 	"github.com/go-kit/kit/log/level"
 	"github.com/gogo/protobuf/proto"
 	"github.com/pierrec/lz4/v4"
@@ -142,55 +143,82 @@ func copyIncompressible(src, dst []byte) (int, error) {
 	return di, nil
 }
 
-// PutRawLog put raw log data to log service, no marshal
-func (s *LogStore) PutRawLog(rawLogData []byte) (err error) {
-	if len(rawLogData) == 0 {
-		// empty log group
-		return nil
+// marshalLogGroup encodes the LogGroup into a pooled buffer, put it back by internal.PutBuffer after use
+func marshalLogGroup(lg *LogGroup) ([]byte, error) {
+	size := lg.Size()
+	body := internal.GetBuffer(size)[:size]
+	n, err := lg.MarshalToSizedBuffer(body)
+	if err != nil {
+		internal.PutBuffer(body)
+		return nil, err
 	}
+	return body[size-n:], nil
+}
 
-	var out []byte
-	var h map[string]string
-	var outLen int
-	switch s.putLogCompressType {
+// compressBody compresses the body of a put logs request.
+// The output never shares memory with pooled buffers, as net/http may still read the request body after the request returns.
+// If copyBody is true, the body is copied when it is not compressed, eg. it is a pooled buffer put back after the request.
+func compressBody(compressType int, body []byte, copyBody bool) (out []byte, h map[string]string, err error) {
+	switch compressType {
 	case Compress_LZ4:
-		// Compresse body with lz4
-		out = make([]byte, lz4.CompressBlockBound(len(rawLogData)))
-		n, err := lz4.CompressBlock(rawLogData, out, nil)
+		// Compresse body with lz4 into a pooled buffer, then copy the result of the exact size
+		buf := internal.GetBuffer(lz4.CompressBlockBound(len(body)))
+		defer internal.PutBuffer(buf)
+		buf = buf[:cap(buf)]
+		n, err := lz4.CompressBlock(body, buf, nil)
 		if err != nil {
-			return NewClientError(err)
+			return nil, nil, NewClientError(err)
 		}
 		// copy incompressible data as lz4 format
 		if n == 0 {
-			n, _ = copyIncompressible(rawLogData, out)
+			n, _ = copyIncompressible(body, buf)
 		}
 
 		h = map[string]string{
 			"x-log-compresstype": "lz4",
-			"x-log-bodyrawsize":  strconv.Itoa(len(rawLogData)),
+			"x-log-bodyrawsize":  strconv.Itoa(len(body)),
 			"Content-Type":       "application/x-protobuf",
 		}
-		outLen = n
+		return append([]byte(nil), buf[:n]...), h, nil
 	case Compress_ZSTD:
-		out, _ = slsZstdCompressor.Compress(rawLogData, nil)
+		// Compress body with zstd
+		out, err = slsZstdCompressor.Compress(body, nil)
+		if err != nil {
+			return nil, nil, NewClientError(err)
+		}
 		h = map[string]string{
 			"x-log-compresstype": "zstd",
-			"x-log-bodyrawsize":  strconv.Itoa(len(rawLogData)),
+			"x-log-bodyrawsize":  strconv.Itoa(len(body)),
 			"Content-Type":       "application/x-protobuf",
 		}
-		outLen = len(out)
-	case Compress_None:
+		return out, h, nil
+	default:
 		// no compress
-		out = rawLogData
 		h = map[string]string{
-			"x-log-bodyrawsize": strconv.Itoa(len(rawLogData)),
+			"x-log-bodyrawsize": strconv.Itoa(len(body)),
 			"Content-Type":      "application/x-protobuf",
 		}
-		outLen = len(out)
+		if copyBody {
+			body = append([]byte(nil), body...)
+		}
+		return body, h, nil
+	}
+}
+
+// PutRawLog put raw log data to log service, no marshal
+func (s *LogStore) PutRawLog(rawLogData []byte) (err error) {
+	if len(rawLogData) == 0 {
+		// empty log group
+		return nil
+	}
+
+	out, h, err := compressBody(s.putLogCompressType, rawLogData, false)
+	if err != nil {
+		return err
 	}
 
 	uri := fmt.Sprintf("/logstores/%v", s.Name)
-	r, err := request(s.project, "POST", uri, h, out[:outLen])
+	r, err := request(s.project, "POST", uri, h, out)
 	if err != nil {
 		return NewClientError(err)
 	}
@@ -220,49 +248,13 @@ func (s *LogStore) PostRawLogs(body []byte, hashKey *string) (err error) {
 		return s.PutRawLog(body)
 	}
 
-	var out []byte
-	var h map[string]string
-	var outLen int
-	switch s.putLogCompressType {
-	case Compress_LZ4:
-		// Compresse body with lz4
-		out = make([]byte, lz4.CompressBlockBound(len(body)))
-		n, err := lz4.CompressBlock(body, out, nil)
-		if err != nil {
-			return NewClientError(err)
-		}
-		// copy incompressible data as lz4 format
-		if n == 0 {
-			n, _ = copyIncompressible(body, out)
-		}
-
-		h = map[string]string{
-			"x-log-compresstype": "lz4",
-			"x-log-bodyrawsize":  strconv.Itoa(len(body)),
-			"Content-Type":       "application/x-protobuf",
-		}
-		outLen = n
-	case Compress_ZSTD:
-		// Compress body with zstd
-		out, _ = slsZstdCompressor.Compress(body, nil)
-		h = map[string]string{
-			"x-log-compresstype": "zstd",
-			"x-log-bodyrawsize":  strconv.Itoa(len(body)),
-			"Content-Type":       "application/x-protobuf",
-		}
-		outLen = len(out)
-	case Compress_None:
-		// no compress
-		out = body
-		h = map[string]string{
-			"x-log-bodyrawsize": strconv.Itoa(len(body)),
-			"Content-Type":      "application/x-protobuf",
-		}
-		outLen = len(out)
+	out, h, err := compressBody(s.putLogCompressType, body, false)
+	if err != nil {
+		return err
 	}
 
 	uri := fmt.Sprintf("/logstores/%v/shards/route?key=%v", s.Name, *hashKey)
-	r, err := request(s.project, "POST", uri, h, out[:outLen])
+	r, err := request(s.project, "POST", uri, h, out)
 	if err != nil {
 		return NewClientError(err)
 	}
@@ -289,50 +281,15 @@ func (s *LogStore) PutLogs(lg *LogGroup) (err error) {
 		return nil
 	}
 
-	body, err := proto.Marshal(lg)
+	body, err := marshalLogGroup(lg)
 	if err != nil {
 		return NewClientError(err)
 	}
+	defer internal.PutBuffer(body)
 
-	var out []byte
-	var h map[string]string
-	var outLen int
-	switch s.putLogCompressType {
-	case Compress_LZ4:
-		// Compresse body with lz4
-		out = make([]byte, lz4.CompressBlockBound(len(body)))
-		n, err := lz4.CompressBlock(body, out, nil)
-		if err != nil {
-			return NewClientError(err)
-		}
-		// copy incompressible data as lz4 format
-		if n == 0 {
-			n, _ = copyIncompressible(body, out)
-		}
-
-		h = map[string]string{
-			"x-log-compresstype": "lz4",
-			"x-log-bodyrawsize":  strconv.Itoa(len(body)),
-			"Content-Type":       "application/x-protobuf",
-		}
-		outLen = n
-	case Compress_ZSTD:
-		// Compress body with zstd
-		out, _ = slsZstdCompressor.Compress(body, nil)
-		h = map[string]string{
-			"x-log-compresstype": "zstd",
-			"x-log-bodyrawsize":  strconv.Itoa(len(body)),
-			"Content-Type":       "application/x-protobuf",
-		}
-		outLen = len(out)
-	case Compress_None:
-		// no compress
-		out = body
-		h = map[string]string{
-			"x-log-bodyrawsize": strconv.Itoa(len(body)),
-			"Content-Type":      "application/x-protobuf",
-		}
-		outLen = len(out)
+	out, h, err := compressBody(s.putLogCompressType, body, true)
+	if err != nil {
+		return err
 	}
 	var uri string
 	if s.useMetricStoreURL {
@@ -340,7 +297,7 @@ func (s *LogStore) PutLogs(lg *LogGroup) (err error) {
 	} else {
 		uri = fmt.Sprintf("/logstores/%v", s.Name)
 	}
-	r, err := request(s.project, "POST", uri, h, out[:outLen])
+	r, err := request(s.project, "POST", uri, h, out)
 	if err != nil {
 		return NewClientError(err)
 	}
@@ -366,75 +323,50 @@ func (s *LogStore) PostLogStoreLogs(req *PostLogStoreLogsRequest) (err error) {
 		return err
 	}
 
-	if req.LogGroup == nil || len(req.LogGroup.Logs) == 0 {
-		// empty log group or empty hashkey
-		return nil
-	}
+	body := req.RawLogGroup
+	if body == nil {
+		if req.LogGroup == nil || len(req.LogGroup.Logs) == 0 {
+			// empty log group or empty hashkey
+			return nil
+		}
 
-	if s.useMetricStoreURL {
-		return s.PutLogs(req.LogGroup)
+		if s.useMetricStoreURL {
+			return s.PutLogs(req.LogGroup)
+		}
+
+		if body, err = marshalLogGroup(req.LogGroup); err != nil {
+			return NewClientError(err)
+		}
+		defer internal.PutBuffer(body)
+	} else if len(body) == 0 {
+		return nil
 	}
 
-	body, err := proto.Marshal(req.LogGroup)
+	// RawLogGroup may be a pooled buffer of the caller as well
+	out, h, err := compressBody(s.putLogCompressType, body, true)
 	if err != nil {
-		return NewClientError(err)
+		return err
 	}
 
-	var out []byte
-	var h map[string]string
-	var outLen int
-	switch s.putLogCompressType {
-	case Compress_LZ4:
-		// Compresse body with lz4
-		out = make([]byte, lz4.CompressBlockBound(len(body)))
-		n, err := lz4.CompressBlock(body, out, nil)
-		if err != nil {
-			return NewClientError(err)
+	var uri = fmt.Sprintf("/logstores/%s", s.Name)
+	if s.useMetricStoreURL {
+		// the same as PutLogs, the hash key and processor are not supported
+		uri = fmt.Sprintf("/prometheus/%s/%s/api/v1/write", s.project.Name, s.Name)
+	} else {
+		var params = url.Values{}
+		if req.HashKey != nil && *req.HashKey != "" {
+			params.Set("key", *req.HashKey)
+			uri = fmt.Sprintf("/logstores/%s/shards/route", s.Name)
 		}
-		// copy incompressible data as lz4 format
-		if n == 0 {
-			n, _ = copyIncompressible(body, out)
+		if req.Processor != "" {
+			params.Set("processor", req.Processor)
 		}
-
-		h = map[string]string{
-			"x-log-compresstype": "lz4",
-			"x-log-bodyrawsize":  strconv.Itoa(len(body)),
-			"Content-Type":       "application/x-protobuf",
+		if len(params) > 0 {
+			uri = fmt.Sprintf("%s?%s", uri, params.Encode())
 		}
-		outLen = n
-	case Compress_ZSTD:
-		// Compress body with zstd
-		out, _ = slsZstdCompressor.Compress(body, nil)
-		h = map[string]string{
-			"x-log-compresstype": "zstd",
-			"x-log-bodyrawsize":  strconv.Itoa(len(body)),
-			"Content-Type":       "application/x-protobuf",
-		}
-		outLen = len(out)
-	case Compress_None:
-		// no compress
-		out = body
-		h = map[string]string{
-			"x-log-bodyrawsize": strconv.Itoa(len(body)),
-			"Content-Type":      "application/x-protobuf",
-		}
-		outLen = len(out)
-	}
-
-	var uri = fmt.Sprintf("/logstores/%s", s.Name)
-	var params = url.Values{}
-	if req.HashKey != nil && *req.HashKey != "" {
-		params.Set("key", *req.HashKey)
-		uri = fmt.Sprintf("/logstores/%s/shards/route", s.Name)
-	}
-	if req.Processor != "" {
-		params.Set("processor", req.Processor)
-	}
-	if len(params) > 0 {
-		uri = fmt.Sprintf("%s?%s", uri, params.Encode())
 	}
 
-	r, err := request(s.project, "POST", uri, h, out[:outLen])
+	r, err := request(s.project, "POST", uri, h, out)
 	if err != nil {
 		return NewClientError(err)
 	}
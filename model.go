@@ -355,6 +355,7 @@ const (
 
 type PostLogStoreLogsRequest struct {
 	LogGroup     *LogGroup
+	RawLogGroup  []byte // encoded LogGroup, sent instead of LogGroup if it is not nil, it is not used after the call returns
 	HashKey      *string
 	CompressType int
 	Processor    string
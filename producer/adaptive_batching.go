@@ -0,0 +1,255 @@
+package producer
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// AdaptiveBatchingConfig enables tuning LingerMs / MaxBatchSize / MaxBatchCount per logstore
+// from the observed throughput, send latency and retry rate.
+type AdaptiveBatchingConfig struct {
+	// Optional, the target time in milliseconds from a log being sent to the producer
+	// until its batch is delivered, the linger time is lowered to meet it. 0 means not used.
+	TargetLatencyMs int64
+	// Optional, the target count of send requests per second to a logstore,
+	// the linger time is raised to merge logs into fewer requests when it is exceeded. 0 means not used.
+	TargetRequestsPerSec float64
+
+	// Optional, bounds of the tuned values.
+	// Defaults to 100 ms ~ ProducerConfig.LingerMs, 64 KB ~ 5 MB and 128 ~ 40960 logs.
+	MinLingerMs   int64
+	MaxLingerMs   int64
+	MinBatchSize  int64
+	MaxBatchSize  int64
+	MinBatchCount int
+	MaxBatchCount int
+
+	// Optional, defaults to 1000.
+	// The interval in milliseconds to adjust the values from what is observed during it.
+	AdjustIntervalMs int64
+}
+
+// BatchingParams are the batching thresholds used for new batches of a logstore.
+type BatchingParams struct {
+	LingerMs      int64
+	MaxBatchSize  int64
+	MaxBatchCount int
+}
+
+// above this ratio of failed sends, batches are enlarged to lower the pressure on the server
+const adaptiveRetryRateThreshold = 0.1
+
+// validateAdaptiveBatchingConfig fills the defaults into a copy, the AdaptiveBatchingConfig of the caller isn't modified
+func validateAdaptiveBatchingConfig(producerConfig *ProducerConfig, logger log.Logger) {
+	copied := *producerConfig.AdaptiveBatching
+	config := &copied
+	producerConfig.AdaptiveBatching = config
+	if config.MinLingerMs < 100 {
+		config.MinLingerMs = 100
+	}
+	if config.MaxLingerMs <= 0 {
+		config.MaxLingerMs = producerConfig.LingerMs
+	}
+	if config.MinBatchSize <= 0 {
+		config.MinBatchSize = 64 * 1024
+	}
+	if config.MaxBatchSize <= 0 {
+		config.MaxBatchSize = 5 * 1024 * 1024
+	} else if config.MaxBatchSize > 1024*1024*30 {
+		config.MaxBatchSize = 1024 * 1024 * 30
+	}
+	if config.MinBatchCount <= 0 {
+		config.MinBatchCount = 128
+	}
+	if config.MaxBatchCount <= 0 || config.MaxBatchCount > 40960 {
+		config.MaxBatchCount = 40960
+	}
+	if config.AdjustIntervalMs <= 0 {
+		config.AdjustIntervalMs = 1000
+	}
+	if config.MinLingerMs > config.MaxLingerMs || config.MinBatchSize > config.MaxBatchSize || config.MinBatchCount > config.MaxBatchCount {
+		level.Warn(logger).Log("msg", "The minimum values of AdaptiveBatching exceed the maximum values, and have been reset to the maximum values")
+		if config.MinLingerMs > config.MaxLingerMs {
+			config.MinLingerMs = config.MaxLingerMs
+		}
+		if config.MinBatchSize > config.MaxBatchSize {
+			config.MinBatchSize = config.MaxBatchSize
+		}
+		if config.MinBatchCount > config.MaxBatchCount {
+			config.MinBatchCount = config.MaxBatchCount
+		}
+	}
+}
+
+type adaptiveBatcher struct {
+	config       *AdaptiveBatchingConfig
+	initial      BatchingParams
+	logger       log.Logger
+	mutex        sync.RWMutex
+	destinations map[string]*adaptiveDestination
+}
+
+// adaptiveDestination holds the current params of a logstore, and what is observed in the current interval
+type adaptiveDestination struct {
+	params      BatchingParams
+	windowStart time.Time
+	window      batchingWindow
+}
+
+type batchingWindow struct {
+	requests    int
+	retries     int // failed requests
+	bytes       int64
+	logs        int64
+	sendLatency time.Duration // sum of all requests
+}
+
+func newAdaptiveBatcher(producerConfig *ProducerConfig, logger log.Logger) *adaptiveBatcher {
+	config := producerConfig.AdaptiveBatching
+	initial := BatchingParams{
+		LingerMs:      producerConfig.LingerMs,
+		MaxBatchSize:  producerConfig.MaxBatchSize,
+		MaxBatchCount: producerConfig.MaxBatchCount,
+	}
+	if config.TargetLatencyMs > 0 && config.TargetLatencyMs < initial.LingerMs {
+		initial.LingerMs = config.TargetLatencyMs
+	}
+	return &adaptiveBatcher{
+		config:       config,
+		initial:      clampBatchingParams(initial, config),
+		logger:       logger,
+		destinations: make(map[string]*adaptiveDestination),
+	}
+}
+
+// params returns the thresholds for a new batch of the logstore
+func (b *adaptiveBatcher) params(project, logstore string) BatchingParams {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	if d, ok := b.destinations[project+Delimiter+logstore]; ok {
+		return d.params
+	}
+	return b.initial
+}
+
+// minLingerMs returns the smallest linger time in use, the mover must not sleep longer than it
+func (b *adaptiveBatcher) minLingerMs() int64 {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	lingerMs := b.initial.LingerMs
+	for _, d := range b.destinations {
+		if d.params.LingerMs < lingerMs {
+			lingerMs = d.params.LingerMs
+		}
+	}
+	return lingerMs
+}
+
+// record adds a send request to the current interval, and adjusts the params at the end of the interval
+func (b *adaptiveBatcher) record(producerBatch *ProducerBatch, sendCost time.Duration, failed bool) {
+	now := time.Now()
+	key := producerBatch.getProject() + Delimiter + producerBatch.getLogstore()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	d, ok := b.destinations[key]
+	if !ok {
+		d = &adaptiveDestination{params: b.initial, windowStart: now}
+		b.destinations[key] = d
+	}
+	d.window.requests++
+	d.window.sendLatency += sendCost
+	if failed {
+		d.window.retries++
+	}
+	// the first attempt of batches shows the incoming throughput
+	if producerBatch.attemptCount == 0 {
+		d.window.bytes += producerBatch.totalDataSize
+		d.window.logs += int64(producerBatch.getLogCount())
+	}
+
+	elapsed := now.Sub(d.windowStart)
+	if elapsed < time.Duration(b.config.AdjustIntervalMs)*time.Millisecond {
+		return
+	}
+	params := adjustBatchingParams(d.params, d.window, elapsed, b.config)
+	if params != d.params {
+		level.Debug(b.logger).Log("msg", "adjust batching params", "project", producerBatch.getProject(),
+			"logstore", producerBatch.getLogstore(), "lingerMs", params.LingerMs,
+			"maxBatchSize", params.MaxBatchSize, "maxBatchCount", params.MaxBatchCount)
+	}
+	d.params = params
+	d.window = batchingWindow{}
+	d.windowStart = now
+}
+
+// adjustBatchingParams calculates the params for the next interval from what is observed in the last one.
+// The linger time is set to what the latency target leaves after sending, raised if there are too many requests
+// or retries; the batch size and count are set so that a batch is just filled up when the linger time expires.
+func adjustBatchingParams(current BatchingParams, window batchingWindow, elapsed time.Duration, config *AdaptiveBatchingConfig) BatchingParams {
+	if window.requests == 0 || elapsed <= 0 {
+		return current
+	}
+	seconds := elapsed.Seconds()
+	avgSendLatencyMs := float64(window.sendLatency.Milliseconds()) / float64(window.requests)
+	lingerMs := float64(current.LingerMs)
+	if config.TargetLatencyMs > 0 {
+		lingerMs = float64(config.TargetLatencyMs) - avgSendLatencyMs
+	}
+	if requestsPerSec := float64(window.requests) / seconds; config.TargetRequestsPerSec > 0 && requestsPerSec > config.TargetRequestsPerSec {
+		lingerMs = math.Max(lingerMs, float64(current.LingerMs)*math.Min(requestsPerSec/config.TargetRequestsPerSec, 2))
+	}
+	if float64(window.retries)/float64(window.requests) > adaptiveRetryRateThreshold {
+		lingerMs = math.Max(lingerMs, float64(current.LingerMs)*1.5)
+	}
+	// move halfway to the new value to avoid oscillation
+	lingerMs = (lingerMs + float64(current.LingerMs)) / 2
+	lingerMs = math.Min(math.Max(lingerMs, float64(config.MinLingerMs)), float64(config.MaxLingerMs))
+
+	// 20% headroom, so that batches are usually sent by the linger time rather than split by the thresholds
+	lingerSeconds := lingerMs / 1000
+	batchSize := float64(window.bytes) / seconds * lingerSeconds * 1.2
+	batchCount := float64(window.logs) / seconds * lingerSeconds * 1.2
+	next := BatchingParams{
+		LingerMs:      int64(lingerMs),
+		MaxBatchSize:  int64((batchSize + float64(current.MaxBatchSize)) / 2),
+		MaxBatchCount: int((batchCount + float64(current.MaxBatchCount)) / 2),
+	}
+	return clampBatchingParams(next, config)
+}
+
+func clampBatchingParams(params BatchingParams, config *AdaptiveBatchingConfig) BatchingParams {
+	if params.LingerMs < config.MinLingerMs {
+		params.LingerMs = config.MinLingerMs
+	} else if params.LingerMs > config.MaxLingerMs {
+		params.LingerMs = config.MaxLingerMs
+	}
+	if params.MaxBatchSize < config.MinBatchSize {
+		params.MaxBatchSize = config.MinBatchSize
+	} else if params.MaxBatchSize > config.MaxBatchSize {
+		params.MaxBatchSize = config.MaxBatchSize
+	}
+	if params.MaxBatchCount < config.MinBatchCount {
+		params.MaxBatchCount = config.MinBatchCount
+	} else if params.MaxBatchCount > config.MaxBatchCount {
+		params.MaxBatchCount = config.MaxBatchCount
+	}
+	return params
+}
+
+// BatchingParams returns the batching thresholds currently used for new batches of the logstore.
+// They are the fixed values of the ProducerConfig unless AdaptiveBatching is enabled.
+func (producer *Producer) BatchingParams(project, logstore string) BatchingParams {
+	if producer.adaptiveBatcher != nil {
+		return producer.adaptiveBatcher.params(project, logstore)
+	}
+	config := producer.config()
+	return BatchingParams{
+		LingerMs:      config.LingerMs,
+		MaxBatchSize:  config.MaxBatchSize,
+		MaxBatchCount: config.MaxBatchCount,
+	}
+}
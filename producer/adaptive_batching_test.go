@@ -0,0 +1,117 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAdaptiveBatchingConfig(config *AdaptiveBatchingConfig) *AdaptiveBatchingConfig {
+	producerConfig := GetDefaultProducerConfig()
+	producerConfig.AdaptiveBatching = config
+	validateAdaptiveBatchingConfig(producerConfig, log.NewNopLogger())
+	return producerConfig.AdaptiveBatching
+}
+
+func TestValidateAdaptiveBatchingConfigCopies(t *testing.T) {
+	config := &AdaptiveBatchingConfig{TargetLatencyMs: 300}
+	validated := newTestAdaptiveBatchingConfig(config)
+	assert.Equal(t, int64(100), validated.MinLingerMs)
+	assert.Equal(t, int64(1000), validated.AdjustIntervalMs)
+	assert.Equal(t, &AdaptiveBatchingConfig{TargetLatencyMs: 300}, config)
+}
+
+func TestAdjustBatchingParams(t *testing.T) {
+	current := BatchingParams{LingerMs: 1000, MaxBatchSize: 512 * 1024, MaxBatchCount: 4096}
+	for _, tt := range []struct {
+		name   string
+		config *AdaptiveBatchingConfig
+		window batchingWindow
+		check  func(t *testing.T, next BatchingParams)
+	}{
+		{
+			name:   "no requests",
+			config: &AdaptiveBatchingConfig{TargetLatencyMs: 200},
+			window: batchingWindow{},
+			check: func(t *testing.T, next BatchingParams) {
+				assert.Equal(t, current, next)
+			},
+		},
+		{
+			name:   "lower linger to meet the latency target",
+			config: &AdaptiveBatchingConfig{TargetLatencyMs: 300, MaxLingerMs: 2000},
+			window: batchingWindow{requests: 10, sendLatency: 10 * 100 * time.Millisecond, bytes: 1024, logs: 10},
+			check: func(t *testing.T, next BatchingParams) {
+				assert.Equal(t, int64(600), next.LingerMs) // halfway from 1000 to 300 - 100
+				assert.Less(t, next.MaxBatchSize, current.MaxBatchSize)
+				assert.Less(t, next.MaxBatchCount, current.MaxBatchCount)
+			},
+		},
+		{
+			name:   "enlarge batches for high throughput",
+			config: &AdaptiveBatchingConfig{MaxLingerMs: 2000},
+			window: batchingWindow{requests: 10, bytes: 100 * 1024 * 1024, logs: 1000000},
+			check: func(t *testing.T, next BatchingParams) {
+				assert.Equal(t, int64(1000), next.LingerMs)
+				assert.Equal(t, int64(5*1024*1024), next.MaxBatchSize)
+				assert.Equal(t, 40960, next.MaxBatchCount)
+			},
+		},
+		{
+			name:   "raise linger for too many requests",
+			config: &AdaptiveBatchingConfig{TargetRequestsPerSec: 5, MaxLingerMs: 5000},
+			window: batchingWindow{requests: 20, bytes: 1024, logs: 10},
+			check: func(t *testing.T, next BatchingParams) {
+				assert.Equal(t, int64(1500), next.LingerMs) // halfway from 1000 to 2000
+			},
+		},
+		{
+			name:   "raise linger for retries",
+			config: &AdaptiveBatchingConfig{TargetLatencyMs: 300, MaxLingerMs: 5000},
+			window: batchingWindow{requests: 10, retries: 5, bytes: 1024, logs: 10},
+			check: func(t *testing.T, next BatchingParams) {
+				assert.Equal(t, int64(1250), next.LingerMs) // halfway from 1000 to 1500
+			},
+		},
+		{
+			name:   "bounded",
+			config: &AdaptiveBatchingConfig{TargetLatencyMs: 50, MinLingerMs: 800, MaxLingerMs: 2000},
+			window: batchingWindow{requests: 10, bytes: 1024, logs: 10},
+			check: func(t *testing.T, next BatchingParams) {
+				assert.Equal(t, int64(800), next.LingerMs)
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestAdaptiveBatchingConfig(tt.config)
+			tt.check(t, adjustBatchingParams(current, tt.window, time.Second, config))
+		})
+	}
+}
+
+func TestAdaptiveBatchingProducer(t *testing.T) {
+	client := &mockSendClient{latency: 10 * time.Millisecond}
+	config := GetDefaultProducerConfig()
+	config.AdaptiveBatching = &AdaptiveBatchingConfig{TargetLatencyMs: 300, AdjustIntervalMs: 100}
+	producer := newMockProducer(client, config)
+	assert.Equal(t, int64(300), producer.BatchingParams("p", "l").LingerMs)
+	producer.Start()
+	defer producer.SafeClose()
+
+	callback := &resultCollector{}
+	begin := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, producer.SendLogWithCallBack("p", "l", "", "", GenerateLog(1, map[string]string{"k": "v"}), callback))
+		assert.Eventually(t, func() bool { return len(callback.get()) == i+1 }, 2*time.Second, 10*time.Millisecond)
+	}
+	// sent by the adapted linger time rather than LingerMs of 2 seconds
+	assert.Less(t, time.Since(begin), 3*time.Second)
+
+	params := producer.BatchingParams("p", "l")
+	assert.True(t, params.LingerMs >= 100 && params.LingerMs <= 300)
+	assert.Less(t, params.MaxBatchSize, config.MaxBatchSize)
+	assert.Equal(t, int64(300), producer.BatchingParams("p", "other").LingerMs)
+}
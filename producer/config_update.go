@@ -0,0 +1,152 @@
+package producer
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// the fields of ProducerConfig that UpdateConfig applies to a running producer
+var liveConfigFields = map[string]bool{
+	"TotalSizeLnBytes":    true,
+	"MaxIoWorkerCount":    true,
+	"MaxBlockSec":         true,
+	"MaxBatchSize":        true,
+	"MaxBatchCount":       true,
+	"LingerMs":            true,
+	"Retries":             true,
+	"MaxReservedAttempts": true,
+	"BaseRetryBackoffMs":  true,
+	"MaxRetryBackoffMs":   true,
+	"CompressType":        true,
+}
+
+// why the other fields can't be changed without creating a new producer
+var configFieldReasons = map[string]string{
+	"Endpoint":            "the client is created with it",
+	"AccessKeyID":         "the client is created with it",
+	"AccessKeySecret":     "the client is created with it",
+	"CredentialsProvider": "the client is created with it",
+	"UpdateStsToken":      "the client is created with it",
+	"StsTokenShutDown":    "the client is created with it",
+	"Region":              "the client is created with it",
+	"AuthVersion":         "the client is created with it",
+	"HTTPClient":          "the client is created with it",
+	"UserAgent":           "the client is created with it",
+	"Logger":              "the logger is created with it",
+	"AllowLogLevel":       "the logger is created with it",
+	"LogFileName":         "the logger is created with it",
+	"IsJsonType":          "the logger is created with it",
+	"LogMaxSize":          "the logger is created with it",
+	"LogMaxBackups":       "the logger is created with it",
+	"LogCompress":         "the logger is created with it",
+	"AdjustShargHash":     "logs with the same shard hash would be split into different batches and lose their order",
+	"Buckets":             "logs with the same shard hash would be split into different batches and lose their order",
+	"ShardAwareRouting":   "logs with the same shard hash would be split into different batches and lose their order",
+	"FanoutDestinations":  "batches being sent are already split for the destinations",
+	"GeneratePackId":      "pack ids of the batches being retried must not change",
+	"PackIdStore":         "pack ids of the batches being retried must not change",
+}
+
+// GetConfig returns a copy of the config in use, which can be modified and passed to UpdateConfig.
+func (producer *Producer) GetConfig() *ProducerConfig {
+	config := *producer.config()
+	return &config
+}
+
+// UpdateConfig applies the config to the running producer without losing the cached logs.
+// Only TotalSizeLnBytes, MaxIoWorkerCount, MaxBlockSec, MaxBatchSize, MaxBatchCount, LingerMs, Retries,
+// MaxReservedAttempts, BaseRetryBackoffMs, MaxRetryBackoffMs and CompressType can be changed, the config
+// is rejected with the reasons if any other field differs. Batching thresholds and retry settings apply to
+// batches created afterwards, io workers already sending are not interrupted when MaxIoWorkerCount shrinks.
+// The config is validated and corrected the same way as NewProducer does.
+func (producer *Producer) UpdateConfig(config *ProducerConfig) error {
+	producer.configMutex.Lock()
+	defer producer.configMutex.Unlock()
+
+	updated := *config
+	validateProducerConfig(&updated, producer.logger)
+	current := producer.config()
+	if err := checkConfigUpdate(current, &updated); err != nil {
+		level.Warn(producer.logger).Log("msg", "reject config update", "error", err)
+		return err
+	}
+
+	producer.liveConfig.Store(&updated)
+	if updated.MaxIoWorkerCount != current.MaxIoWorkerCount {
+		producer.threadPool.ioworker.resize(updated.MaxIoWorkerCount, updated.HighPriorityReservedRatio)
+	}
+	level.Info(producer.logger).Log("msg", "producer config updated", "totalSizeLnBytes", updated.TotalSizeLnBytes,
+		"maxIoWorkerCount", updated.MaxIoWorkerCount, "lingerMs", updated.LingerMs, "maxBatchSize", updated.MaxBatchSize,
+		"maxBatchCount", updated.MaxBatchCount, "retries", updated.Retries, "compressType", updated.CompressType)
+	return nil
+}
+
+// checkConfigUpdate returns an error explaining every field that can't be changed live
+func checkConfigUpdate(current, updated *ProducerConfig) error {
+	var reasons []string
+	currentValue, updatedValue := reflect.ValueOf(current).Elem(), reflect.ValueOf(updated).Elem()
+	for i := 0; i < currentValue.NumField(); i++ {
+		name := currentValue.Type().Field(i).Name
+		if configFieldEqual(currentValue.Field(i), updatedValue.Field(i)) {
+			continue
+		}
+		if liveConfigFields[name] {
+			// the batching thresholds are managed by the adaptive batcher, which is not reset
+			if current.AdaptiveBatching != nil && (name == "LingerMs" || name == "MaxBatchSize" || name == "MaxBatchCount") {
+				reasons = append(reasons, name+" is tuned by AdaptiveBatching")
+			}
+			continue
+		}
+		reason, ok := configFieldReasons[name]
+		if !ok {
+			reason = "it is only used when the producer is created"
+		}
+		reasons = append(reasons, name+" can't be changed, "+reason)
+	}
+	if len(reasons) > 0 {
+		return fmt.Errorf("unsafe producer config update: %s", strings.Join(reasons, "; "))
+	}
+	return nil
+}
+
+// configFieldEqual compares funcs by code address, as reflect.DeepEqual treats non-nil funcs as different.
+// Closures of the same func literal can't be told apart, changes of their captured values are not detected.
+func configFieldEqual(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Func:
+		return a.Pointer() == b.Pointer()
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		if a.Elem().Type() != b.Elem().Type() {
+			return false
+		}
+		if a.Elem().Type().Comparable() {
+			return a.Interface() == b.Interface()
+		}
+		return configFieldEqual(a.Elem(), b.Elem())
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !configFieldEqual(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// config returns the config in use, which is replaced as a whole by UpdateConfig
+func (producer *Producer) config() *ProducerConfig {
+	if config := producer.liveConfig.Load(); config != nil {
+		return config
+	}
+	return producer.producerConfig
+}
@@ -0,0 +1,82 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	client := &mockSendClient{}
+	producer := newMockProducer(client, nil)
+	producer.Start()
+	defer producer.SafeClose()
+
+	config := producer.GetConfig()
+	config.MaxIoWorkerCount = 2
+	config.TotalSizeLnBytes = 1024 * 1024
+	config.LingerMs = 100
+	config.MaxBatchCount = 1
+	require.NoError(t, producer.UpdateConfig(config))
+
+	assert.Equal(t, int64(2), producer.Stats().MaxIoWorkerCount)
+	assert.Equal(t, int64(1024*1024), producer.memoryLimit(PriorityNormal))
+	assert.Equal(t, int64(100), producer.GetConfig().LingerMs)
+
+	// every log is sealed at once with the new MaxBatchCount
+	for i := 0; i < 3; i++ {
+		require.NoError(t, producer.SendLog("p", "l", "", "", GenerateLog(1, map[string]string{"k": "v"})))
+	}
+	assert.Eventually(t, func() bool { return len(client.sentRequests()) == 3 }, time.Second, 10*time.Millisecond)
+
+	// the config passed in is copied
+	config.MaxIoWorkerCount = 10
+	assert.Equal(t, int64(2), producer.GetConfig().MaxIoWorkerCount)
+}
+
+func TestUpdateConfigRejected(t *testing.T) {
+	producer := newMockProducer(&mockSendClient{}, nil)
+
+	config := producer.GetConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.AdjustShargHash = !config.AdjustShargHash
+	config.MaxIoWorkerCount = 2
+	err := producer.UpdateConfig(config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Endpoint")
+	assert.Contains(t, err.Error(), "AdjustShargHash")
+	assert.NotContains(t, err.Error(), "MaxIoWorkerCount")
+	// nothing is applied
+	assert.Equal(t, int64(50), producer.GetConfig().MaxIoWorkerCount)
+
+	config = GetDefaultProducerConfig()
+	config.AdaptiveBatching = &AdaptiveBatchingConfig{}
+	producer = newMockProducer(&mockSendClient{}, config)
+	config = producer.GetConfig()
+	config.LingerMs = 500
+	err = producer.UpdateConfig(config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "LingerMs is tuned by AdaptiveBatching")
+
+	config = producer.GetConfig()
+	config.Retries = 3
+	assert.NoError(t, producer.UpdateConfig(config))
+}
+
+func TestUpdateConfigWithFuncs(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.LogProcessors = []LogProcessor{NewSamplingProcessor(0, ContentEquals("level", "debug"))}
+	producer := newMockProducer(&mockSendClient{}, config)
+
+	config = producer.GetConfig()
+	config.MaxBlockSec = 1
+	assert.NoError(t, producer.UpdateConfig(config))
+
+	config = producer.GetConfig()
+	config.LogProcessors = append(config.LogProcessors, NewSamplingProcessor(0, ContentEquals("level", "info")))
+	err := producer.UpdateConfig(config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "LogProcessors")
+}
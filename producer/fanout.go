@@ -0,0 +1,181 @@
+package producer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+)
+
+// FanoutPolicy decides whether a batch delivered to multiple destinations is successful.
+const (
+	// FanoutSuccessWhenAll reports success only if the batch is delivered to all destinations.
+	FanoutSuccessWhenAll = iota
+	// FanoutSuccessWhenAny reports success if the batch is delivered to at least one destination.
+	FanoutSuccessWhenAny
+)
+
+// FanoutDestination is one of the destinations every log is delivered to in fan-out mode,
+// eg. the old and the new project during a migration between regions.
+type FanoutDestination struct {
+	// Required, identifies the destination in Result.GetDestinationResults, must be unique.
+	Name string
+	// Optional, overrides the project / logstore passed to the send methods.
+	Project  string
+	Logstore string
+
+	Endpoint            string
+	CredentialsProvider sls.CredentialsProvider
+	Region              string
+	AuthVersion         sls.AuthVersionType
+	// Optional, the client used to send logs to this destination.
+	// Endpoint / CredentialsProvider / Region / AuthVersion are ignored if it is set.
+	Client sls.ClientInterface
+}
+
+type fanoutDestination struct {
+	name     string
+	project  string
+	logstore string
+	client   sls.ClientInterface
+}
+
+// validateFanoutDestinations checks the names, which are the keys of Result.GetDestinationResults
+func validateFanoutDestinations(destinations []*FanoutDestination) error {
+	names := make(map[string]bool, len(destinations))
+	for i, d := range destinations {
+		if d == nil {
+			return fmt.Errorf("FanoutDestinations[%d] is nil", i)
+		}
+		if d.Name == "" {
+			return fmt.Errorf("the Name of FanoutDestinations[%d] is empty", i)
+		}
+		if names[d.Name] {
+			return fmt.Errorf("duplicate FanoutDestination Name %q", d.Name)
+		}
+		names[d.Name] = true
+	}
+	return nil
+}
+
+func initFanoutDestinations(config *ProducerConfig, logger log.Logger) []*fanoutDestination {
+	destinations := make([]*fanoutDestination, 0, len(config.FanoutDestinations))
+	for _, d := range config.FanoutDestinations {
+		client := d.Client
+		if client == nil {
+			client = sls.CreateNormalInterfaceV2(d.Endpoint, d.CredentialsProvider)
+			configureClient(client, &ProducerConfig{
+				Region:      d.Region,
+				AuthVersion: d.AuthVersion,
+				HTTPClient:  config.HTTPClient,
+				UserAgent:   config.UserAgent,
+			})
+		}
+		destinations = append(destinations, &fanoutDestination{
+			name:     d.Name,
+			project:  d.Project,
+			logstore: d.Logstore,
+			client:   client,
+		})
+	}
+	return destinations
+}
+
+// fanoutBatch tracks the copies of a sealed batch, one per destination.
+// Every copy shares the LogGroup but has its own retry state, the callbacks of the
+// original batch are called once all copies are finished.
+type fanoutBatch struct {
+	parent             *ProducerBatch
+	policy             int
+	mutex              sync.Mutex
+	remaining          int
+	destinationNames   []string
+	destinationResults map[string]*Result
+}
+
+func (producer *Producer) splitFanoutBatch(parent *ProducerBatch) []*ProducerBatch {
+	f := &fanoutBatch{
+		parent:             parent,
+		policy:             producer.producerConfig.FanoutPolicy,
+		remaining:          len(producer.fanoutDestinations),
+		destinationNames:   make([]string, 0, len(producer.fanoutDestinations)),
+		destinationResults: make(map[string]*Result, len(producer.fanoutDestinations)),
+	}
+	children := make([]*ProducerBatch, 0, len(producer.fanoutDestinations))
+	for _, d := range producer.fanoutDestinations {
+		project, logstore := parent.project, parent.logstore
+		if d.project != "" {
+			project = d.project
+		}
+		if d.logstore != "" {
+			logstore = d.logstore
+		}
+		f.destinationNames = append(f.destinationNames, d.name)
+		children = append(children, &ProducerBatch{
+			maxRetryIntervalInMs: parent.maxRetryIntervalInMs,
+			baseRetryBackoffMs:   parent.baseRetryBackoffMs,
+			maxRetryTimes:        parent.maxRetryTimes,
+			createTimeMs:         parent.createTimeMs,
+			project:              project,
+			logstore:             logstore,
+			shardHash:            parent.shardHash,
+			maxReservedAttempts:  parent.maxReservedAttempts,
+			useMetricStoreUrl:    parent.useMetricStoreUrl,
+			priority:             parent.priority,
+			totalDataSize:        parent.totalDataSize,
+			logGroup:             parent.logGroup,
+			rawData:              parent.rawData,
+			rawLogCount:          parent.rawLogCount,
+			callBackList:         []CallBack{},
+			result:               initResult(),
+			client:               d.client,
+			destination:          d.name,
+			fanout:               f,
+		})
+	}
+	return children
+}
+
+// finish records the result of one copy, and completes the original batch after the last one.
+func (f *fanoutBatch) finish(child *ProducerBatch, err *sls.Error, begin time.Time, ioWorker *IoWorker) {
+	child.addAttempt(err, begin)
+
+	f.mutex.Lock()
+	f.destinationResults[child.destination] = child.result
+	f.remaining--
+	if f.remaining > 0 {
+		f.mutex.Unlock()
+		return
+	}
+	f.mutex.Unlock()
+
+	result := &Result{
+		attemptList:        []*Attempt{},
+		destinationResults: f.destinationResults,
+	}
+	successCount := 0
+	for _, name := range f.destinationNames {
+		destinationResult := f.destinationResults[name]
+		if destinationResult.IsSuccessful() {
+			successCount++
+		}
+		result.attemptList = append(result.attemptList, destinationResult.attemptList...)
+	}
+	if f.policy == FanoutSuccessWhenAny {
+		result.successful = successCount > 0
+	} else {
+		result.successful = successCount == len(f.destinationNames)
+	}
+
+	ioWorker.producer.stats.countFinished(f.parent, result.successful)
+	for _, callBack := range f.parent.callBackList {
+		if result.successful {
+			callBack.Success(result)
+		} else {
+			callBack.Fail(result)
+		}
+	}
+	ioWorker.releaseBatch(f.parent)
+}
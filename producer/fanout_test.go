@@ -0,0 +1,160 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFanoutTestProducer(policy int, oldClient, newClient sls.ClientInterface) *Producer {
+	config := GetDefaultProducerConfig()
+	config.BaseRetryBackoffMs = 1
+	config.MaxBatchCount = 1 // send every log at once
+	config.LingerMs = 100
+	config.FanoutPolicy = policy
+	config.FanoutDestinations = []*FanoutDestination{
+		{Name: "old", Client: oldClient},
+		{Name: "new", Project: "new-project", Client: newClient},
+	}
+	return newMockProducer(nil, config)
+}
+
+func TestFanoutProducerIndependentRetry(t *testing.T) {
+	oldClient := &mockSendClient{}
+	newClient := &mockSendClient{
+		errorFunc: func(project, logstore string, n int) error {
+			if n == 0 {
+				return &sls.Error{HTTPCode: 500, Code: sls.INTERNAL_SERVER_ERROR}
+			}
+			return nil
+		},
+	}
+	producer := newFanoutTestProducer(FanoutSuccessWhenAll, oldClient, newClient)
+	producer.Start()
+	callback := &resultCollector{}
+	require.NoError(t, producer.SendLogWithCallBack("p", "l", "", "", GenerateLog(1, map[string]string{"k": "v"}), callback))
+	// retry is disabled once the producer is closing, wait for the result first
+	assert.Eventually(t, func() bool { return len(callback.get()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	producer.SafeClose()
+
+	oldRequests := oldClient.sentRequests()
+	newRequests := newClient.sentRequests()
+	require.Len(t, oldRequests, 1)
+	require.Len(t, newRequests, 2)
+	assert.Equal(t, "p", oldRequests[0].project)
+	assert.Equal(t, "new-project", newRequests[0].project)
+	// custom clients get the LogGroup, the raw data is only sent by the clients of the SDK
+	require.NotNil(t, oldRequests[0].req.LogGroup)
+	assert.Len(t, oldRequests[0].req.LogGroup.Logs, 1)
+	assert.Equal(t, oldRequests[0].req.LogGroup.String(), newRequests[1].req.LogGroup.String())
+
+	results := callback.get()
+	require.Len(t, results, 1)
+	assert.True(t, results[0].IsSuccessful())
+	assert.Len(t, results[0].GetReservedAttempts(), 3)
+	assert.Len(t, results[0].GetDestinationResults()["new"].GetReservedAttempts(), 2)
+	assert.Equal(t, int64(0), producer.Stats().PendingBytes)
+}
+
+func TestFanoutProducerPolicy(t *testing.T) {
+	failedClient := func() *mockSendClient {
+		return &mockSendClient{
+			errorFunc: func(project, logstore string, n int) error {
+				return &sls.Error{HTTPCode: 404, Code: sls.PROJECT_NOT_EXIST}
+			},
+		}
+	}
+	for _, tt := range []struct {
+		name          string
+		policy        int
+		wantSucceeded bool
+	}{
+		{"all", FanoutSuccessWhenAll, false},
+		{"any", FanoutSuccessWhenAny, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			producer := newFanoutTestProducer(tt.policy, &mockSendClient{}, failedClient())
+			producer.Start()
+			callback := &resultCollector{}
+			require.NoError(t, producer.SendLogWithCallBack("p", "l", "", "", GenerateLog(1, map[string]string{"k": "v"}), callback))
+			producer.SafeClose()
+
+			results := callback.get()
+			require.Len(t, results, 1)
+			assert.Equal(t, tt.wantSucceeded, results[0].IsSuccessful())
+			assert.True(t, results[0].GetDestinationResults()["old"].IsSuccessful())
+			assert.False(t, results[0].GetDestinationResults()["new"].IsSuccessful())
+			assert.Equal(t, sls.PROJECT_NOT_EXIST, results[0].GetDestinationResults()["new"].GetErrorCode())
+		})
+	}
+}
+
+func TestFanoutDestinationsValidation(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		destinations []*FanoutDestination
+		wantErr      bool
+	}{
+		{"valid", []*FanoutDestination{{Name: "old"}, {Name: "new"}}, false},
+		{"empty name", []*FanoutDestination{{Name: "old"}, {}}, true},
+		{"duplicate name", []*FanoutDestination{{Name: "old"}, {Name: "old", Project: "new-project"}}, true},
+		{"nil", []*FanoutDestination{{Name: "old"}, nil}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := GetDefaultProducerConfig()
+			config.FanoutDestinations = tt.destinations
+			producer, err := NewProducer(config)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, producer)
+				return
+			}
+			require.NoError(t, err)
+			// the main client is not created from Endpoint in fan-out mode
+			assert.Nil(t, producer.threadPool.ioworker.client)
+		})
+	}
+}
+
+func TestFanoutProducerStats(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.MaxBatchCount = 1 // send every log at once
+	config.LingerMs = 100
+	config.FanoutPolicy = FanoutSuccessWhenAny
+	// the same project and logstore in two regions
+	config.FanoutDestinations = []*FanoutDestination{
+		{Name: "old", Client: &mockSendClient{}},
+		{Name: "new", Client: &mockSendClient{
+			errorFunc: func(project, logstore string, n int) error {
+				return &sls.Error{HTTPCode: 404, Code: sls.PROJECT_NOT_EXIST}
+			},
+		}},
+	}
+	producer := newMockProducer(nil, config)
+	producer.Start()
+	for i := 0; i < 2; i++ {
+		require.NoError(t, producer.SendLog("p", "l", "", "", GenerateLog(1, map[string]string{"k": "v"})))
+	}
+	producer.SafeClose()
+
+	stats := producer.Stats()
+	assert.Equal(t, int64(2), stats.SuccessBatches)
+	assert.Equal(t, int64(0), stats.FailedBatches)
+	assert.Equal(t, int64(2), stats.SuccessLogs)
+	assert.Equal(t, int64(0), stats.FailedLogs)
+	require.Len(t, stats.Destinations, 2)
+	oldStats, newStats := stats.Destinations["old"], stats.Destinations["new"]
+	require.NotNil(t, oldStats)
+	require.NotNil(t, newStats)
+	assert.Equal(t, "old", oldStats.Name)
+	assert.Equal(t, "p", oldStats.Project)
+	assert.Equal(t, int64(2), oldStats.SuccessBatches)
+	assert.Nil(t, oldStats.LastError)
+	assert.Equal(t, "new", newStats.Name)
+	assert.Equal(t, "p", newStats.Project)
+	assert.Equal(t, int64(2), newStats.FailedBatches)
+	assert.Equal(t, sls.PROJECT_NOT_EXIST, newStats.LastError.Code)
+}
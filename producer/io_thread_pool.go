@@ -2,6 +2,7 @@ package producer
 
 import (
 	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -11,42 +12,181 @@ import (
 type IoThreadPool struct {
 	threadPoolShutDownFlag *atomic.Bool
 	taskCh                 chan *ProducerBatch
+	highPriorityTaskCh     chan *ProducerBatch
+	highPriorityClosed     bool // only accessed by the goroutine running start
 	ioworker               *IoWorker
 	logger                 log.Logger
 	stopped                *atomic.Bool
+	abandoned              *atomic.Bool // Shutdown timeout, batches are not sent any more
 }
 
 func initIoThreadPool(ioworker *IoWorker, logger log.Logger) *IoThreadPool {
 	return &IoThreadPool{
 		threadPoolShutDownFlag: atomic.NewBool(false),
 		taskCh:                 make(chan *ProducerBatch, 100000),
+		highPriorityTaskCh:     make(chan *ProducerBatch, 100000),
 		ioworker:               ioworker,
 		logger:                 logger,
 		stopped:                atomic.NewBool(false),
+		abandoned:              atomic.NewBool(false),
 	}
 }
 
 func (threadPool *IoThreadPool) addTask(batch *ProducerBatch) {
+	if batch.priority == PriorityHigh {
+		threadPool.highPriorityTaskCh <- batch
+		return
+	}
 	threadPool.taskCh <- batch
 }
 
 func (threadPool *IoThreadPool) start(ioWorkerWaitGroup *sync.WaitGroup, ioThreadPoolwait *sync.WaitGroup) {
 	defer ioThreadPoolwait.Done()
-	for task := range threadPool.taskCh {
-		threadPool.ioworker.startSendTask(ioWorkerWaitGroup)
-		go func(producerBatch *ProducerBatch) {
-			defer threadPool.ioworker.closeSendTask(ioWorkerWaitGroup)
-			threadPool.ioworker.sendToServer(producerBatch)
-		}(task)
+	taskCh := threadPool.taskCh
+	for taskCh != nil || !threadPool.highPriorityClosed {
+		// high priority batches are always taken first
+		select {
+		case task, ok := <-threadPool.getHighPriorityTaskCh():
+			threadPool.handleHighPriorityTask(task, ok, ioWorkerWaitGroup)
+			continue
+		default:
+		}
+		select {
+		case task, ok := <-threadPool.getHighPriorityTaskCh():
+			threadPool.handleHighPriorityTask(task, ok, ioWorkerWaitGroup)
+		case task, ok := <-taskCh:
+			if !ok {
+				taskCh = nil
+				continue
+			}
+			threadPool.handle(task, ioWorkerWaitGroup)
+		}
 	}
 	level.Info(threadPool.logger).Log("msg", "All cache tasks in the thread pool have been successfully sent")
 	threadPool.stopped.Store(true)
 }
 
+// getHighPriorityTaskCh returns nil once the channel is closed and drained, so that selects skip it
+func (threadPool *IoThreadPool) getHighPriorityTaskCh() chan *ProducerBatch {
+	if threadPool.highPriorityClosed {
+		return nil
+	}
+	return threadPool.highPriorityTaskCh
+}
+
+func (threadPool *IoThreadPool) handleHighPriorityTask(task *ProducerBatch, ok bool, ioWorkerWaitGroup *sync.WaitGroup) {
+	if !ok {
+		threadPool.highPriorityClosed = true
+		return
+	}
+	threadPool.handle(task, ioWorkerWaitGroup)
+}
+
+func (threadPool *IoThreadPool) handle(task *ProducerBatch, ioWorkerWaitGroup *sync.WaitGroup) {
+	// a sealed batch in fan-out mode is sent to each destination separately
+	if len(threadPool.ioworker.producer.fanoutDestinations) > 0 && task.fanout == nil {
+		for _, child := range threadPool.ioworker.producer.splitFanoutBatch(task) {
+			threadPool.dispatch(child, ioWorkerWaitGroup)
+		}
+		return
+	}
+	threadPool.dispatch(task, ioWorkerWaitGroup)
+}
+
+func (threadPool *IoThreadPool) dispatch(producerBatch *ProducerBatch, ioWorkerWaitGroup *sync.WaitGroup) {
+	if threadPool.abandoned.Load() {
+		threadPool.ioworker.abandonBatch(producerBatch, threadPool.ioworker.producer.undelivered.Load())
+		return
+	}
+	if threadPool.deferIfRateLimited(producerBatch) {
+		return
+	}
+	normalIoWorker := threadPool.ioworker.normalIoWorker
+	if producerBatch.priority != PriorityNormal {
+		normalIoWorker = nil
+	}
+	if normalIoWorker != nil && !threadPool.takeNormalIoWorker(ioWorkerWaitGroup) {
+		threadPool.ioworker.abandonBatch(producerBatch, threadPool.ioworker.producer.undelivered.Load())
+		return
+	}
+	if !threadPool.acquireIoWorker(threadPool.ioworker.maxIoWorker) {
+		if normalIoWorker != nil {
+			normalIoWorker.release()
+		}
+		threadPool.ioworker.abandonBatch(producerBatch, threadPool.ioworker.producer.undelivered.Load())
+		return
+	}
+	threadPool.ioworker.startSendTask(ioWorkerWaitGroup)
+	go func() {
+		defer threadPool.ioworker.closeSendTask(producerBatch, ioWorkerWaitGroup)
+		threadPool.ioworker.sendToServer(producerBatch)
+	}()
+}
+
+// acquireIoWorker waits for an io worker, it returns false if Shutdown times out in the meantime
+func (threadPool *IoThreadPool) acquireIoWorker(limiter *ioWorkerLimiter) bool {
+	for !limiter.tryAcquire() {
+		if threadPool.abandoned.Load() {
+			return false
+		}
+		<-limiter.released
+	}
+	return true
+}
+
+// takeNormalIoWorker waits for an io worker not reserved for high priority batches,
+// and dispatches the high priority batches arriving in the meantime.
+// It returns false if Shutdown times out in the meantime.
+func (threadPool *IoThreadPool) takeNormalIoWorker(ioWorkerWaitGroup *sync.WaitGroup) bool {
+	for !threadPool.ioworker.normalIoWorker.tryAcquire() {
+		if threadPool.abandoned.Load() {
+			return false
+		}
+		select {
+		case <-threadPool.ioworker.normalIoWorker.released:
+		case task, ok := <-threadPool.getHighPriorityTaskCh():
+			threadPool.handleHighPriorityTask(task, ok, ioWorkerWaitGroup)
+		}
+	}
+	return true
+}
+
+// abandon stops sending batches when Shutdown times out, the batches dispatched afterwards are failed at once
+func (threadPool *IoThreadPool) abandon() {
+	threadPool.abandoned.Store(true)
+	threadPool.ioworker.retryQueueShutDownFlag.Store(true)
+	threadPool.ioworker.producer.mover.wakeUp()
+	// wake up the dispatching waiting for an io worker
+	threadPool.ioworker.maxIoWorker.notify()
+	if threadPool.ioworker.normalIoWorker != nil {
+		threadPool.ioworker.normalIoWorker.notify()
+	}
+}
+
+// deferIfRateLimited reserves rate limiter tokens for the batch, and puts it into the retry queue
+// if it has to wait, so that the batches of other destinations are not blocked.
+func (threadPool *IoThreadPool) deferIfRateLimited(producerBatch *ProducerBatch) bool {
+	rateLimiter := threadPool.ioworker.producer.rateLimiter
+	if rateLimiter == nil || producerBatch.rateReserved || threadPool.ioworker.retryQueueShutDownFlag.Load() {
+		return false
+	}
+	producerBatch.rateReserved = true
+	wait := rateLimiter.reserve(producerBatch)
+	if wait <= 0 {
+		return false
+	}
+	level.Debug(threadPool.logger).Log("msg", "batch is rate limited", "project", producerBatch.getProject(),
+		"logstore", producerBatch.getLogstore(), "waitMs", wait.Milliseconds())
+	producerBatch.nextRetryMs = time.Now().Add(wait).UnixMilli()
+	threadPool.ioworker.retryQueue.sendToRetryQueue(producerBatch, threadPool.logger)
+	return true
+}
+
 func (threadPool *IoThreadPool) ShutDown() {
 	old := threadPool.threadPoolShutDownFlag.Swap(true)
 	if !old {
 		close(threadPool.taskCh)
+		close(threadPool.highPriorityTaskCh)
 	}
 }
 
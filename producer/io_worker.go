@@ -8,6 +8,7 @@ import (
 	sls "github.com/aliyun/aliyun-log-go-sdk"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/gogo/protobuf/proto"
 	uberatomic "go.uber.org/atomic"
 )
 
@@ -22,7 +23,8 @@ type IoWorker struct {
 	retryQueue             *RetryQueue
 	retryQueueShutDownFlag *uberatomic.Bool
 	logger                 log.Logger
-	maxIoWorker            chan int64
+	maxIoWorker            *ioWorkerLimiter
+	normalIoWorker         *ioWorkerLimiter // limits the io workers of normal priority batches, nil if none is reserved
 	noRetryStatusCodeMap   map[int]*string
 	producer               *Producer
 }
@@ -34,7 +36,7 @@ func initIoWorker(client sls.ClientInterface, retryQueue *RetryQueue, logger log
 		taskCount:              0,
 		retryQueueShutDownFlag: uberatomic.NewBool(false),
 		logger:                 logger,
-		maxIoWorker:            make(chan int64, maxIoWorkerCount),
+		maxIoWorker:            newIoWorkerLimiter(maxIoWorkerCount),
 		noRetryStatusCodeMap:   errorStatusMap,
 		producer:               producer,
 	}
@@ -42,29 +44,69 @@ func initIoWorker(client sls.ClientInterface, retryQueue *RetryQueue, logger log
 
 func (ioWorker *IoWorker) sendToServer(producerBatch *ProducerBatch) {
 	level.Debug(ioWorker.logger).Log("msg", "ioworker send data to server")
+	producerBatch.rateReserved = false
+	if producerBatch.reroute {
+		ioWorker.rerouteBatch(producerBatch)
+	}
+	client := ioWorker.client
+	if producerBatch.client != nil {
+		client = producerBatch.client
+	}
 	sendBegin := time.Now()
 	var err error
-	if producerBatch.isUseMetricStoreUrl() {
-		// not use compress type now
-		err = ioWorker.client.PutLogsWithMetricStoreURL(producerBatch.getProject(), producerBatch.getLogstore(), producerBatch.logGroup)
-	} else {
+	switch {
+	case producerBatch.rawData != nil && sendsRawLogGroup(client):
 		req := &sls.PostLogStoreLogsRequest{
-			LogGroup:     producerBatch.logGroup,
+			RawLogGroup:  producerBatch.rawData,
 			HashKey:      producerBatch.getShardHash(),
-			CompressType: ioWorker.producer.producerConfig.CompressType,
+			CompressType: ioWorker.producer.config().CompressType,
 			Processor:    ioWorker.producer.producerConfig.Processor,
 		}
-		err = ioWorker.client.PostLogStoreLogsV2(producerBatch.getProject(), producerBatch.getLogstore(), req)
+		err = client.PostLogStoreLogsV2(producerBatch.getProject(), producerBatch.getLogstore(), req)
+	case producerBatch.rawData != nil && !producerBatch.isRawDataPooled():
+		// sent by SendRawLogGroup to a custom client
+		err = client.PostRawLogWithCompressType(producerBatch.getProject(), producerBatch.getLogstore(), producerBatch.rawData,
+			ioWorker.producer.config().CompressType, producerBatch.getShardHash())
+	case producerBatch.isUseMetricStoreUrl():
+		// not use compress type now
+		err = client.PutLogsWithMetricStoreURL(producerBatch.getProject(), producerBatch.getLogstore(), producerBatch.logGroup)
+	default:
+		logGroup := producerBatch.logGroup
+		if producerBatch.rawData != nil {
+			// custom clients, eg. FanoutDestination.Client, only get the LogGroup
+			logGroup = &sls.LogGroup{}
+			err = proto.Unmarshal(producerBatch.rawData, logGroup)
+		}
+		if err == nil {
+			req := &sls.PostLogStoreLogsRequest{
+				LogGroup:     logGroup,
+				HashKey:      producerBatch.getShardHash(),
+				CompressType: ioWorker.producer.config().CompressType,
+				Processor:    ioWorker.producer.producerConfig.Processor,
+			}
+			err = client.PostLogStoreLogsV2(producerBatch.getProject(), producerBatch.getLogstore(), req)
+		}
 	}
 	sendEnd := time.Now()
+	if adaptiveBatcher := ioWorker.producer.adaptiveBatcher; adaptiveBatcher != nil {
+		adaptiveBatcher.record(producerBatch, sendEnd.Sub(sendBegin), err != nil)
+	}
+
+	if rateLimiter := ioWorker.producer.rateLimiter; rateLimiter != nil {
+		if err == nil {
+			rateLimiter.onSuccess(producerBatch.getProject(), producerBatch.getLogstore())
+		} else if slsError, ok := err.(*sls.Error); ok && isQuotaExceededError(slsError) {
+			rateLimiter.onQuotaExceeded(producerBatch.getProject(), producerBatch.getLogstore())
+		}
+	}
 
 	// send ok
 	if err == nil {
 		level.Debug(ioWorker.logger).Log("msg", "sendToServer success")
 		defer ioWorker.producer.monitor.recordSuccess(sendBegin, sendEnd)
-		producerBatch.OnSuccess(sendBegin)
+		ioWorker.producer.stats.recordSuccess(producerBatch, sendEnd.Sub(sendBegin))
 		// After successful delivery, producer removes the batch size sent out
-		atomic.AddInt64(&ioWorker.producer.producerLogGroupSize, -producerBatch.totalDataSize)
+		ioWorker.finishBatch(producerBatch, nil, sendBegin)
 		return
 	}
 
@@ -75,23 +117,72 @@ func (ioWorker *IoWorker) sendToServer(producerBatch *ProducerBatch) {
 		"requestId", slsError.RequestID,
 		"errorCode", slsError.Code,
 		"errorMessage", slsError.Message,
-		"logs", len(producerBatch.logGroup.Logs),
+		"logs", producerBatch.getLogCount(),
 		"canRetry", canRetry)
 	if !canRetry {
 		defer ioWorker.producer.monitor.recordFailure(sendBegin, sendEnd)
-		producerBatch.OnFail(slsError, sendBegin)
-		atomic.AddInt64(&ioWorker.producer.producerLogGroupSize, -producerBatch.totalDataSize)
+		ioWorker.producer.stats.recordFailure(producerBatch, slsError, sendEnd.Sub(sendBegin))
+		if collector := ioWorker.producer.undelivered.Load(); collector != nil {
+			collector.add(producerBatch, slsError)
+		}
+		ioWorker.finishBatch(producerBatch, slsError, sendBegin)
 		return
 	}
 
+	// the target shard was split or merged, the shards are listed again in the background,
+	// and the retry is sent to the shard owning the hash key by then
+	if ioWorker.producer.shardRouter != nil && producerBatch.shardHash != nil && isShardRoutingError(slsError) {
+		ioWorker.producer.shardRouter.invalidate(producerBatch.getProject(), producerBatch.getLogstore())
+		producerBatch.reroute = true
+	}
+
 	// do retry
 	ioWorker.producer.monitor.recordRetry(sendEnd.Sub(sendBegin))
+	ioWorker.producer.stats.recordRetry(producerBatch, slsError, sendEnd.Sub(sendBegin))
 	producerBatch.addAttempt(slsError, sendBegin)
 	producerBatch.nextRetryMs = producerBatch.getRetryBackoffIntervalMs() + time.Now().UnixMilli()
 	level.Debug(ioWorker.logger).Log("msg", "Submit to the retry queue after meeting the retry criteria。")
 	ioWorker.retryQueue.sendToRetryQueue(producerBatch, ioWorker.logger)
 }
 
+// rerouteBatch sends the batch to the shard owning its hash key in the cached shards,
+// the batch keeps its hash key if the shards are not listed yet
+func (ioWorker *IoWorker) rerouteBatch(producerBatch *ProducerBatch) {
+	if routed, ok := ioWorker.producer.shardRouter.reroute(producerBatch.getProject(), producerBatch.getLogstore(), *producerBatch.shardHash); ok {
+		producerBatch.shardHash = &routed
+	}
+}
+
+// finishBatch calls the callbacks of the batch and releases its memory, err is nil if the batch is sent successfully
+func (ioWorker *IoWorker) finishBatch(producerBatch *ProducerBatch, err *sls.Error, begin time.Time) {
+	if producerBatch.fanout != nil {
+		producerBatch.fanout.finish(producerBatch, err, begin, ioWorker)
+		return
+	}
+	if err == nil {
+		producerBatch.OnSuccess(begin)
+	} else {
+		producerBatch.OnFail(err, begin)
+	}
+	ioWorker.releaseBatch(producerBatch)
+}
+
+func (ioWorker *IoWorker) releaseBatch(producerBatch *ProducerBatch) {
+	atomic.AddInt64(&ioWorker.producer.producerLogGroupSize, -producerBatch.totalDataSize)
+	atomic.AddInt64(&ioWorker.producer.producerLogCount, -int64(producerBatch.getLogCount()))
+	producerBatch.releaseRawData()
+}
+
+// sendsRawLogGroup reports whether the client sends PostLogStoreLogsRequest.RawLogGroup,
+// which is only supported by the clients of the SDK, other implementations only get the LogGroup.
+func sendsRawLogGroup(client sls.ClientInterface) bool {
+	switch client.(type) {
+	case *sls.Client, *sls.TokenAutoUpdateClient:
+		return true
+	}
+	return false
+}
+
 func parseSlsError(err error) *sls.Error {
 	if slsError, ok := err.(*sls.Error); ok {
 		return slsError
@@ -105,20 +196,103 @@ func (ioWorker *IoWorker) canRetry(producerBatch *ProducerBatch, err *sls.Error)
 	if ioWorker.retryQueueShutDownFlag.Load() {
 		return false
 	}
-	if _, ok := ioWorker.noRetryStatusCodeMap[int(err.HTTPCode)]; ok {
+	// the batches sent to a split or merged shard are retried on the shard owning the hash key now
+	routable := ioWorker.producer.shardRouter != nil && producerBatch.shardHash != nil && isShardRoutingError(err)
+	if _, ok := ioWorker.noRetryStatusCodeMap[int(err.HTTPCode)]; ok && !routable {
 		return false
 	}
 	return producerBatch.attemptCount < producerBatch.maxRetryTimes
 }
 
-func (ioWorker *IoWorker) closeSendTask(ioWorkerWaitGroup *sync.WaitGroup) {
-	<-ioWorker.maxIoWorker
+// reserveForHighPriority keeps part of the io workers for high priority batches
+func (ioWorker *IoWorker) reserveForHighPriority(ratio float64) {
+	if ratio > 0 {
+		ioWorker.normalIoWorker = newIoWorkerLimiter(normalIoWorkerCount(ioWorker.maxIoWorker.getLimit(), ratio))
+	}
+}
+
+// normalIoWorkerCount returns the io workers usable by normal priority batches, at least one is left for them
+func normalIoWorkerCount(maxIoWorkerCount int64, ratio float64) int64 {
+	count := maxIoWorkerCount - reservedForHighPriority(maxIoWorkerCount, ratio)
+	if count < 1 {
+		return 1
+	}
+	return count
+}
+
+// resize changes the count of io workers, batches being sent are not affected
+func (ioWorker *IoWorker) resize(maxIoWorkerCount int64, ratio float64) {
+	ioWorker.maxIoWorker.setLimit(maxIoWorkerCount)
+	if ioWorker.normalIoWorker != nil {
+		ioWorker.normalIoWorker.setLimit(normalIoWorkerCount(maxIoWorkerCount, ratio))
+	}
+}
+
+func (ioWorker *IoWorker) closeSendTask(producerBatch *ProducerBatch, ioWorkerWaitGroup *sync.WaitGroup) {
+	ioWorker.maxIoWorker.release()
+	if ioWorker.normalIoWorker != nil && producerBatch.priority == PriorityNormal {
+		ioWorker.normalIoWorker.release()
+	}
 	atomic.AddInt64(&ioWorker.taskCount, -1)
 	ioWorkerWaitGroup.Done()
 }
 
+// startSendTask counts the batch in flight, the caller must have taken an io worker,
+// and a normal io worker for normal priority batches
 func (ioWorker *IoWorker) startSendTask(ioWorkerWaitGroup *sync.WaitGroup) {
 	atomic.AddInt64(&ioWorker.taskCount, 1)
-	ioWorker.maxIoWorker <- 1
 	ioWorkerWaitGroup.Add(1)
 }
+
+// ioWorkerLimiter is a semaphore whose size can be changed at runtime.
+// It supports only one goroutine waiting to acquire, which is the io thread pool.
+type ioWorkerLimiter struct {
+	mutex    sync.Mutex
+	limit    int64
+	inUse    int64
+	released chan struct{} // notifies the waiter when a worker is released or the limit is raised
+}
+
+func newIoWorkerLimiter(limit int64) *ioWorkerLimiter {
+	return &ioWorkerLimiter{
+		limit:    limit,
+		released: make(chan struct{}, 1),
+	}
+}
+
+func (l *ioWorkerLimiter) tryAcquire() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.inUse >= l.limit {
+		return false
+	}
+	l.inUse++
+	return true
+}
+
+func (l *ioWorkerLimiter) release() {
+	l.mutex.Lock()
+	l.inUse--
+	l.mutex.Unlock()
+	l.notify()
+}
+
+func (l *ioWorkerLimiter) setLimit(limit int64) {
+	l.mutex.Lock()
+	l.limit = limit
+	l.mutex.Unlock()
+	l.notify()
+}
+
+func (l *ioWorkerLimiter) getLimit() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.limit
+}
+
+func (l *ioWorkerLimiter) notify() {
+	select {
+	case l.released <- struct{}{}:
+	default:
+	}
+}
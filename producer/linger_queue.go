@@ -0,0 +1,62 @@
+package producer
+
+import "container/heap"
+
+// lingerQueue is a min-heap of the open batches ordered by the time their linger expires,
+// so that the mover only takes the expired batches instead of checking all batches of the accumulator.
+// It is guarded by the lock of the LogAccumulator.
+type lingerQueue []*ProducerBatch
+
+func (q lingerQueue) Len() int {
+	return len(q)
+}
+
+func (q lingerQueue) Less(i, j int) bool {
+	return q[i].lingerExpireMs() < q[j].lingerExpireMs()
+}
+
+func (q lingerQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].lingerIndex = i
+	q[j].lingerIndex = j
+}
+
+func (q *lingerQueue) Push(x interface{}) {
+	batch := x.(*ProducerBatch)
+	batch.lingerIndex = len(*q)
+	*q = append(*q, batch)
+}
+
+func (q *lingerQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	batch := old[n-1]
+	old[n-1] = nil
+	batch.lingerIndex = -1
+	*q = old[:n-1]
+	return batch
+}
+
+func (q *lingerQueue) add(batch *ProducerBatch) {
+	heap.Push(q, batch)
+}
+
+// remove takes the batch out of the queue if it is in it
+func (q *lingerQueue) remove(batch *ProducerBatch) {
+	if i := batch.lingerIndex; i >= 0 && i < len(*q) && (*q)[i] == batch {
+		heap.Remove(q, i)
+	}
+}
+
+// popExpired removes and returns the batches whose linger expires at nowMs,
+// and the milliseconds until the next batch expires, -1 if the queue is empty.
+func (q *lingerQueue) popExpired(nowMs int64) ([]*ProducerBatch, int64) {
+	var expired []*ProducerBatch
+	for len(*q) > 0 {
+		if waitMs := (*q)[0].lingerExpireMs() - nowMs; waitMs > 0 {
+			return expired, waitMs
+		}
+		expired = append(expired, heap.Pop(q).(*ProducerBatch))
+	}
+	return expired, -1
+}
@@ -2,19 +2,23 @@ package producer
 
 import (
 	"errors"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 
 	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/internal"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/gogo/protobuf/proto"
 	uberatomic "go.uber.org/atomic"
 )
 
 type LogAccumulator struct {
 	lock           sync.Mutex
 	logGroupData   map[string]*ProducerBatch
+	lingerQueue    lingerQueue // the open batches of logGroupData ordered by linger expiration
 	producerConfig *ProducerConfig
 	ioWorker       *IoWorker
 	shutDownFlag   *uberatomic.Bool
@@ -22,9 +26,18 @@ type LogAccumulator struct {
 	threadPool     *IoThreadPool
 	producer       *Producer
 	packIdGenrator *PackIdGenerator
+	logProcessor   LogProcessor
 }
 
 func initLogAccumulator(config *ProducerConfig, ioWorker *IoWorker, logger log.Logger, threadPool *IoThreadPool, producer *Producer) *LogAccumulator {
+	var logProcessor LogProcessor
+	if len(config.LogProcessors) > 0 {
+		logProcessor = ChainLogProcessors(config.LogProcessors...)
+	}
+	packIdGenerator := newPackIdGenerator()
+	if config.PackIdStore != nil {
+		packIdGenerator = newPackIdGeneratorWithStore(config.PackIdStore, logger)
+	}
 	return &LogAccumulator{
 		logGroupData:   make(map[string]*ProducerBatch),
 		producerConfig: config,
@@ -33,84 +46,203 @@ func initLogAccumulator(config *ProducerConfig, ioWorker *IoWorker, logger log.L
 		logger:         logger,
 		threadPool:     threadPool,
 		producer:       producer,
-		packIdGenrator: newPackIdGenerator(),
+		packIdGenrator: packIdGenerator,
+		logProcessor:   logProcessor,
 	}
 }
 
 func (logAccumulator *LogAccumulator) addLogToProducerBatch(project, logstore, shardHash, logTopic, logSource string,
-	logData interface{}, callback CallBack) error {
+	logTags []*sls.LogTag, priority Priority, logData interface{}, callback CallBack) error {
 	if logAccumulator.shutDownFlag.Load() {
 		level.Warn(logAccumulator.logger).Log("msg", "Producer has started and shut down and cannot write to new logs")
 		return errors.New("Producer has started and shut down and cannot write to new logs")
 	}
 	if log, ok := logData.(*sls.Log); ok {
-		logAccumulator.addLog(project, logstore, shardHash, logTopic, logSource, log, callback)
-		return nil
+		if log, ok = logAccumulator.processLog(log); !ok {
+			logAccumulator.onAllLogsDropped(callback)
+			return nil
+		}
+		return logAccumulator.addLog(project, logstore, shardHash, logTopic, logSource, logTags, priority, log, callback)
 	}
 	if logList, ok := logData.([]*sls.Log); ok {
-		logAccumulator.addLogList(project, logstore, shardHash, logTopic, logSource, logList, callback)
-		return nil
+		if logList, ok = logAccumulator.processLogList(logList); !ok {
+			logAccumulator.onAllLogsDropped(callback)
+			return nil
+		}
+		return logAccumulator.addLogList(project, logstore, shardHash, logTopic, logSource, logTags, priority, logList, callback)
 	}
 	level.Error(logAccumulator.logger).Log("msg", "Invalid logType")
 	return errors.New("invalid logType")
 }
 
 func (logAccumulator *LogAccumulator) addLog(project, logstore, shardHash, logTopic, logSource string,
-	log *sls.Log, callback CallBack) {
-	key := logAccumulator.getKeyString(project, logstore, logTopic, shardHash, logSource)
-	logSize := int64(GetLogSizeCalculate(log))
-	atomic.AddInt64(&logAccumulator.producer.producerLogGroupSize, logSize)
+	logTags []*sls.LogTag, priority Priority, log *sls.Log, callback CallBack) error {
+	// encode out of the lock, only the encoded data is copied into the batch with the lock held
+	encoded, err := appendEncodedLogs(internal.GetBuffer(0), log)
+	defer internal.PutBuffer(encoded)
+	if err != nil {
+		return err
+	}
+	key := logAccumulator.getKeyString(project, logstore, logTopic, shardHash, logSource, logTags, priority)
+	atomic.AddInt64(&logAccumulator.producer.producerLogGroupSize, int64(len(encoded)))
+	atomic.AddInt64(&logAccumulator.producer.producerLogCount, 1)
 
 	logAccumulator.lock.Lock()
-	producerBatch := logAccumulator.getOrCreateProducerBatch(key, project, logstore, logTopic, logSource, shardHash)
-	producerBatch.addLog(log, logSize, callback)
+	producerBatch := logAccumulator.getOrCreateProducerBatch(key, project, logstore, logTopic, logSource, shardHash, logTags, priority)
+	producerBatch.addLog(log, encoded, callback)
 
-	if !producerBatch.meetSendCondition(logAccumulator.producerConfig) {
+	if !producerBatch.meetSendCondition() {
 		logAccumulator.lock.Unlock()
-		return
+		return nil
 	}
 
-	logAccumulator.logGroupData[key] = nil
+	logAccumulator.sealBatch(producerBatch)
 	logAccumulator.lock.Unlock()
 
 	logAccumulator.threadPool.addTask(producerBatch)
+	return nil
 }
 
 func (logAccumulator *LogAccumulator) addLogList(project, logstore, shardHash, logTopic, logSource string,
-	logList []*sls.Log, callback CallBack) {
-	key := logAccumulator.getKeyString(project, logstore, logTopic, shardHash, logSource)
-	logListSize := int64(GetLogListSize(logList))
-	atomic.AddInt64(&logAccumulator.producer.producerLogGroupSize, logListSize)
+	logTags []*sls.LogTag, priority Priority, logList []*sls.Log, callback CallBack) error {
+	encoded, err := appendEncodedLogs(internal.GetBuffer(0), logList...)
+	defer internal.PutBuffer(encoded)
+	if err != nil {
+		return err
+	}
+	key := logAccumulator.getKeyString(project, logstore, logTopic, shardHash, logSource, logTags, priority)
+	atomic.AddInt64(&logAccumulator.producer.producerLogGroupSize, int64(len(encoded)))
+	atomic.AddInt64(&logAccumulator.producer.producerLogCount, int64(len(logList)))
 
 	logAccumulator.lock.Lock()
-	producerBatch := logAccumulator.getOrCreateProducerBatch(key, project, logstore, logTopic, logSource, shardHash)
-	producerBatch.addLogList(logList, logListSize, callback)
+	producerBatch := logAccumulator.getOrCreateProducerBatch(key, project, logstore, logTopic, logSource, shardHash, logTags, priority)
+	producerBatch.addLogList(logList, encoded, callback)
 
-	if !producerBatch.meetSendCondition(logAccumulator.producerConfig) {
+	if !producerBatch.meetSendCondition() {
 		logAccumulator.lock.Unlock()
-		return
+		return nil
 	}
 
-	logAccumulator.logGroupData[key] = nil
+	logAccumulator.sealBatch(producerBatch)
 	logAccumulator.lock.Unlock()
 
 	logAccumulator.threadPool.addTask(producerBatch)
+	return nil
 }
 
-func (logAccumulator *LogAccumulator) getOrCreateProducerBatch(key, project, logstore, logTopic, logSource, shardHash string) *ProducerBatch {
+func (logAccumulator *LogAccumulator) processLog(log *sls.Log) (*sls.Log, bool) {
+	if logAccumulator.logProcessor == nil {
+		return log, true
+	}
+	return logAccumulator.logProcessor(log)
+}
+
+func (logAccumulator *LogAccumulator) processLogList(logList []*sls.Log) ([]*sls.Log, bool) {
+	if logAccumulator.logProcessor == nil {
+		return logList, true
+	}
+	result := make([]*sls.Log, 0, len(logList))
+	for _, log := range logList {
+		if processed, ok := logAccumulator.logProcessor(log); ok {
+			result = append(result, processed)
+		}
+	}
+	return result, len(result) > 0
+}
+
+// processLogGroup applies the processors to copies of the logs, the LogGroup passed by the caller isn't modified
+func (logAccumulator *LogAccumulator) processLogGroup(logGroup *sls.LogGroup) (*sls.LogGroup, bool) {
+	if logAccumulator.logProcessor == nil {
+		return logGroup, true
+	}
+	logs := make([]*sls.Log, 0, len(logGroup.Logs))
+	for _, log := range logGroup.Logs {
+		if processed, ok := logAccumulator.logProcessor(proto.Clone(log).(*sls.Log)); ok {
+			logs = append(logs, processed)
+		}
+	}
+	if len(logs) == 0 {
+		return nil, false
+	}
+	return &sls.LogGroup{
+		Logs:        logs,
+		Category:    logGroup.Category,
+		Topic:       logGroup.Topic,
+		Source:      logGroup.Source,
+		MachineUUID: logGroup.MachineUUID,
+		LogTags:     logGroup.LogTags,
+	}, true
+}
+
+// nothing is left to send, report success directly as the logs are dropped on purpose
+func (logAccumulator *LogAccumulator) onAllLogsDropped(callback CallBack) {
+	if callback == nil {
+		return
+	}
+	result := initResult()
+	result.successful = true
+	callback.Success(result)
+}
+
+func (logAccumulator *LogAccumulator) getOrCreateProducerBatch(key, project, logstore, logTopic, logSource, shardHash string,
+	logTags []*sls.LogTag, priority Priority) *ProducerBatch {
 	if producerBatch, ok := logAccumulator.logGroupData[key]; ok && producerBatch != nil {
 		return producerBatch
 	}
 
 	logAccumulator.producer.monitor.incCreateBatch()
-	batch := newProducerBatch(logAccumulator.packIdGenrator, project, logstore, logTopic, logSource, shardHash, logAccumulator.producerConfig)
+	batch := newProducerBatch(logAccumulator.packIdGenrator, project, logstore, logTopic, logSource, shardHash, logTags, logAccumulator.producer.config())
+	batch.priority = priority
+	if adaptiveBatcher := logAccumulator.producer.adaptiveBatcher; adaptiveBatcher != nil {
+		params := adaptiveBatcher.params(project, logstore)
+		batch.lingerMs, batch.maxBatchSize, batch.maxBatchCount = params.LingerMs, params.MaxBatchSize, params.MaxBatchCount
+	}
+	batch.accumulatorKey = key
+	atomic.AddInt64(&logAccumulator.producer.producerLogGroupSize, batch.totalDataSize)
 	logAccumulator.logGroupData[key] = batch
+	logAccumulator.lingerQueue.add(batch)
 	return batch
 }
 
-func (logAccumulator *LogAccumulator) getKeyString(project, logstore, logTopic, shardHash, logSource string) string {
+// sealBatch takes the open batch out of the accumulator, the lock must be held
+func (logAccumulator *LogAccumulator) sealBatch(batch *ProducerBatch) {
+	delete(logAccumulator.logGroupData, batch.accumulatorKey)
+	logAccumulator.lingerQueue.remove(batch)
+}
+
+// takeLingerExpiredBatches seals the batches whose linger expires at nowMs, the lock must be held.
+// It also returns the milliseconds until the next batch expires, -1 if no batch is open.
+func (logAccumulator *LogAccumulator) takeLingerExpiredBatches(nowMs int64) ([]*ProducerBatch, int64) {
+	expired, waitMs := logAccumulator.lingerQueue.popExpired(nowMs)
+	for _, batch := range expired {
+		delete(logAccumulator.logGroupData, batch.accumulatorKey)
+	}
+	return expired, waitMs
+}
+
+// takeAllBatches seals all the open batches, the lock must be held
+func (logAccumulator *LogAccumulator) takeAllBatches() []*ProducerBatch {
+	batches := make([]*ProducerBatch, 0, len(logAccumulator.lingerQueue))
+	for _, batch := range logAccumulator.logGroupData {
+		if batch != nil {
+			batches = append(batches, batch)
+		}
+	}
+	logAccumulator.logGroupData = make(map[string]*ProducerBatch)
+	logAccumulator.lingerQueue = nil
+	return batches
+}
+
+func (logAccumulator *LogAccumulator) getKeyString(project, logstore, logTopic, shardHash, logSource string,
+	logTags []*sls.LogTag, priority Priority) string {
 	var key strings.Builder
 	key.Grow(len(project) + len(logstore) + len(logTopic) + len(shardHash) + len(logSource) + len(Delimiter)*4)
+	// project names never start with "!", so the keys of other priorities never collide with normal ones
+	if priority != PriorityNormal {
+		key.WriteString("!")
+		key.WriteString(strconv.Itoa(int(priority)))
+		key.WriteString(Delimiter)
+	}
 	key.WriteString(project)
 	key.WriteString(Delimiter)
 	key.WriteString(logstore)
@@ -120,5 +252,15 @@ func (logAccumulator *LogAccumulator) getKeyString(project, logstore, logTopic,
 	key.WriteString(shardHash)
 	key.WriteString(Delimiter)
 	key.WriteString(logSource)
+	// tags are length prefixed, so that different tags never share a key whatever characters they contain
+	for _, tag := range logTags {
+		key.WriteString(Delimiter)
+		key.WriteString(strconv.Itoa(len(tag.GetKey())))
+		key.WriteString(Delimiter)
+		key.WriteString(tag.GetKey())
+		key.WriteString(strconv.Itoa(len(tag.GetValue())))
+		key.WriteString(Delimiter)
+		key.WriteString(tag.GetValue())
+	}
 	return key.String()
 }
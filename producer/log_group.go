@@ -0,0 +1,168 @@
+package producer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/internal"
+	"github.com/go-kit/kit/log/level"
+)
+
+// field number of LogGroup.Logs in the protobuf encoding
+const logGroupLogsField = 1
+
+// SendLogGroup sends a LogGroup built by the caller as is, its topic, source and tags are kept.
+// The LogGroup is not merged with other logs, it is sent in a batch of its own and retried like other batches.
+// The LogGroup must not be modified after it is passed to the producer.
+// If LogProcessors are set, they are applied to copies of the logs, the LogGroup passed in isn't modified.
+func (producer *Producer) SendLogGroup(project, logstore, shardHash string, logGroup *sls.LogGroup) error {
+	return producer.SendLogGroupWithCallBack(project, logstore, shardHash, logGroup, nil)
+}
+
+func (producer *Producer) SendLogGroupWithCallBack(project, logstore, shardHash string, logGroup *sls.LogGroup, callback CallBack) error {
+	if logGroup == nil || len(logGroup.Logs) == 0 {
+		return errors.New("empty log group")
+	}
+	logGroup, ok := producer.logAccumulator.processLogGroup(logGroup)
+	if !ok {
+		producer.logAccumulator.onAllLogsDropped(callback)
+		return nil
+	}
+	err := producer.waitTime()
+	if err != nil {
+		return err
+	}
+	if shardHash != "" {
+		shardHash, err = producer.adjustShardHash(project, logstore, shardHash)
+		if err != nil {
+			return err
+		}
+	}
+	size := int64(logGroup.Size())
+	batch := newSealedProducerBatch(project, logstore, shardHash, producer.config())
+	batch.logGroup = logGroup
+	return producer.logAccumulator.addSealedBatch(batch, size, callback)
+}
+
+// SendRawLogGroup sends a LogGroup already encoded in protobuf, eg. read from a spill file or forwarded
+// from another system. The data is compressed and sent without being decoded and encoded again.
+// The data must not be modified after it is passed to the producer.
+// LogProcessors can't be applied to the data, so an error is returned if they are set.
+func (producer *Producer) SendRawLogGroup(project, logstore, shardHash string, data []byte) error {
+	return producer.SendRawLogGroupWithCallBack(project, logstore, shardHash, data, nil)
+}
+
+func (producer *Producer) SendRawLogGroupWithCallBack(project, logstore, shardHash string, data []byte, callback CallBack) error {
+	if producer.logAccumulator.logProcessor != nil {
+		return errors.New("SendRawLogGroup doesn't support LogProcessors, use SendLogGroup instead")
+	}
+	logCount, err := countRawLogs(data)
+	if err != nil {
+		return err
+	}
+	if logCount == 0 {
+		return errors.New("empty log group")
+	}
+	err = producer.waitTime()
+	if err != nil {
+		return err
+	}
+	if shardHash != "" {
+		shardHash, err = producer.adjustShardHash(project, logstore, shardHash)
+		if err != nil {
+			return err
+		}
+	}
+	batch := newSealedProducerBatch(project, logstore, shardHash, producer.config())
+	batch.logGroup = &sls.LogGroup{}
+	batch.rawData = data
+	batch.rawLogCount = logCount
+	return producer.logAccumulator.addSealedBatch(batch, int64(len(data)), callback)
+}
+
+// addSealedBatch hands a batch built outside of the accumulator to the io workers directly
+func (logAccumulator *LogAccumulator) addSealedBatch(producerBatch *ProducerBatch, size int64, callback CallBack) error {
+	if logAccumulator.shutDownFlag.Load() {
+		level.Warn(logAccumulator.logger).Log("msg", "Producer has started and shut down and cannot write to new logs")
+		return errors.New("Producer has started and shut down and cannot write to new logs")
+	}
+	producerBatch.totalDataSize = size
+	if callback != nil {
+		producerBatch.callBackList = append(producerBatch.callBackList, callback)
+	}
+	atomic.AddInt64(&logAccumulator.producer.producerLogGroupSize, size)
+	atomic.AddInt64(&logAccumulator.producer.producerLogCount, int64(producerBatch.getLogCount()))
+	logAccumulator.producer.monitor.incCreateBatch()
+	logAccumulator.threadPool.addTask(producerBatch)
+	return nil
+}
+
+// countRawLogs walks the top level fields of an encoded LogGroup, returns the count of logs in it,
+// or an error if the data is not a valid protobuf message.
+func countRawLogs(data []byte) (int, error) {
+	count := 0
+	for i := 0; i < len(data); {
+		tag, n := binary.Uvarint(data[i:])
+		if n <= 0 {
+			return 0, fmt.Errorf("invalid log group data: bad tag at offset %d", i)
+		}
+		i += n
+		switch wireType := tag & 0x7; wireType {
+		case 0: // varint
+			if _, n = binary.Uvarint(data[i:]); n <= 0 {
+				return 0, fmt.Errorf("invalid log group data: bad varint at offset %d", i)
+			}
+			i += n
+		case 1: // fixed64
+			i += 8
+		case 2: // length delimited
+			length, n := binary.Uvarint(data[i:])
+			if n <= 0 || length > uint64(len(data)-i-n) {
+				return 0, fmt.Errorf("invalid log group data: bad length at offset %d", i)
+			}
+			i += n + int(length)
+			if tag>>3 == logGroupLogsField {
+				count++
+			}
+		case 5: // fixed32
+			i += 4
+		default:
+			return 0, fmt.Errorf("invalid log group data: unsupported wire type %d at offset %d", wireType, i)
+		}
+		if i > len(data) {
+			return 0, errors.New("invalid log group data: unexpected end of data")
+		}
+	}
+	return count, nil
+}
+
+// encodeLogGroupHeader encodes the LogGroup without its logs into a pooled buffer, the logs can be appended to it later
+func encodeLogGroupHeader(logGroup *sls.LogGroup) ([]byte, error) {
+	size := logGroup.Size()
+	buf := internal.GetBuffer(size)[:size]
+	if _, err := logGroup.MarshalToSizedBuffer(buf); err != nil {
+		internal.PutBuffer(buf)
+		return nil, err
+	}
+	return buf, nil
+}
+
+// appendEncodedLogs appends the logs to buf encoded as the Logs field of a LogGroup,
+// the length of the data appended is the exact size the logs take in the request.
+func appendEncodedLogs(buf []byte, logs ...*sls.Log) ([]byte, error) {
+	for _, log := range logs {
+		size := log.Size()
+		buf = internal.GrowBuffer(buf, 1+binary.MaxVarintLen64+size)
+		buf = append(buf, logGroupLogsField<<3|2)
+		buf = binary.AppendUvarint(buf, uint64(size))
+		offset := len(buf)
+		buf = buf[:offset+size]
+		if _, err := log.MarshalToSizedBuffer(buf[offset:]); err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
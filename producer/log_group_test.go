@@ -0,0 +1,205 @@
+package producer
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogGroup(logs int) *sls.LogGroup {
+	logGroup := &sls.LogGroup{
+		Topic:   proto.String("topic"),
+		Source:  proto.String("10.0.0.1"),
+		LogTags: []*sls.LogTag{{Key: proto.String("tag"), Value: proto.String("value")}},
+	}
+	for i := 0; i < logs; i++ {
+		logGroup.Logs = append(logGroup.Logs, GenerateLog(1, map[string]string{"k": "v"}))
+	}
+	return logGroup
+}
+
+func TestSendLogGroup(t *testing.T) {
+	client := &mockSendClient{}
+	producer := newMockProducer(client, nil)
+	producer.Start()
+
+	logGroup := newTestLogGroup(2)
+	require.NoError(t, producer.SendLogGroup("p", "l", "", logGroup))
+	require.NoError(t, producer.SendLog("p", "l", "topic", "10.0.0.1", GenerateLog(1, map[string]string{"k": "v"})))
+	assert.Error(t, producer.SendLogGroup("p", "l", "", &sls.LogGroup{}))
+	producer.SafeClose()
+
+	requests := client.sentRequests()
+	require.Len(t, requests, 2)
+	var sent *sls.LogGroup
+	for _, r := range requests {
+		if r.req.LogGroup == logGroup {
+			sent = r.req.LogGroup
+		} else {
+			assert.Len(t, r.req.LogGroup.Logs, 1)
+		}
+	}
+	require.NotNil(t, sent, "the log group is sent as is, not merged with other logs")
+	assert.Equal(t, "tag", sent.LogTags[0].GetKey())
+	assert.Len(t, sent.LogTags, 1)
+
+	stats := producer.Stats()
+	assert.Equal(t, int64(3), stats.SuccessLogs)
+	assert.Equal(t, int64(0), stats.PendingBytes)
+	assert.Equal(t, int64(0), stats.PendingLogs)
+}
+
+func TestSendRawLogGroup(t *testing.T) {
+	client := &mockSendClient{
+		errorFunc: func(project, logstore string, n int) error {
+			if n == 0 {
+				return &sls.Error{HTTPCode: 500, Code: sls.INTERNAL_SERVER_ERROR}
+			}
+			return nil
+		},
+	}
+	config := GetDefaultProducerConfig()
+	config.BaseRetryBackoffMs = 1
+	config.LingerMs = 100
+	producer := newMockProducer(client, config)
+	producer.Start()
+
+	data, err := proto.Marshal(newTestLogGroup(3))
+	require.NoError(t, err)
+	callback := &resultCollector{}
+	require.NoError(t, producer.SendRawLogGroupWithCallBack("p", "l", "key", data, callback))
+	assert.Eventually(t, func() bool { return len(callback.get()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	producer.SafeClose()
+
+	requests := client.sentRequests()
+	require.Len(t, requests, 2)
+	for _, r := range requests {
+		assert.Equal(t, data, r.rawData)
+		assert.Len(t, r.req.LogGroup.Logs, 3)
+		require.NotNil(t, r.hashKey)
+	}
+	results := callback.get()
+	assert.True(t, results[0].IsSuccessful())
+	assert.Len(t, results[0].GetReservedAttempts(), 2)
+
+	stats := producer.Stats()
+	assert.Equal(t, int64(3), stats.SuccessLogs)
+	assert.Equal(t, int64(1), stats.RetryCount)
+	assert.Equal(t, int64(0), stats.PendingBytes)
+	assert.Equal(t, int64(0), stats.PendingLogs)
+}
+
+func TestSendLogGroupWithProcessors(t *testing.T) {
+	client := &mockSendClient{}
+	config := GetDefaultProducerConfig()
+	config.LogProcessors = []LogProcessor{
+		NewMaskProcessor(regexp.MustCompile(`password=\S+`), "password=***"),
+		NewSamplingProcessor(0, ContentEquals("level", "debug")),
+	}
+	producer := newMockProducer(client, config)
+	producer.Start()
+
+	logGroup := newTestLogGroup(0)
+	logGroup.Logs = []*sls.Log{
+		GenerateLog(1, map[string]string{"message": "password=123456"}),
+		GenerateLog(1, map[string]string{"level": "debug"}),
+	}
+	require.NoError(t, producer.SendLogGroup("p", "l", "", logGroup))
+	callback := &resultCollector{}
+	require.NoError(t, producer.SendLogGroupWithCallBack("p", "l", "", &sls.LogGroup{Logs: []*sls.Log{GenerateLog(1, map[string]string{"level": "debug"})}}, callback))
+	assert.Error(t, producer.SendRawLogGroup("p", "l", "", []byte{}))
+	producer.SafeClose()
+
+	requests := client.sentRequests()
+	require.Len(t, requests, 1)
+	sent := requests[0].req.LogGroup
+	require.Len(t, sent.Logs, 1)
+	assert.Equal(t, "password=***", sent.Logs[0].Contents[0].GetValue())
+	assert.Equal(t, "topic", sent.GetTopic())
+	assert.Equal(t, "tag", sent.LogTags[0].GetKey())
+	// the log group of the caller isn't modified
+	assert.Len(t, logGroup.Logs, 2)
+	assert.Equal(t, "password=123456", logGroup.Logs[0].Contents[0].GetValue())
+	// the log group whose logs are all dropped is reported as successful
+	require.Len(t, callback.get(), 1)
+	assert.True(t, callback.get()[0].IsSuccessful())
+
+	stats := producer.Stats()
+	assert.Equal(t, int64(1), stats.SuccessLogs)
+	assert.Equal(t, int64(0), stats.PendingBytes)
+	assert.Equal(t, int64(0), stats.PendingLogs)
+}
+
+func TestCountRawLogs(t *testing.T) {
+	data, err := proto.Marshal(newTestLogGroup(3))
+	require.NoError(t, err)
+	for _, tt := range []struct {
+		name    string
+		data    []byte
+		want    int
+		wantErr bool
+	}{
+		{"log group", data, 3, false},
+		{"empty", nil, 0, false},
+		{"truncated", data[:len(data)-1], 0, true},
+		{"bad length", []byte{0x0a, 0x7f}, 0, true},
+		{"bad wire type", []byte{0x0f}, 0, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := countRawLogs(tt.data)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEncodeProducerBatch(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.GeneratePackId = true
+	expected := newTestLogGroup(0)
+	batch := newProducerBatch(newPackIdGenerator(), "p", "l", expected.GetTopic(), expected.GetSource(), "", expected.LogTags, config)
+	require.True(t, batch.pooledRawData)
+	// the topic, source and tags are counted along with the logs
+	assert.Equal(t, int64(len(batch.rawData)), batch.totalDataSize)
+	size := int(batch.totalDataSize)
+
+	// a long value makes the varint of the log size take more than one byte
+	logs := []*sls.Log{GenerateLog(1, map[string]string{"k": "v"}), GenerateLog(2, map[string]string{"k": string(make([]byte, 300))})}
+	for _, log := range logs {
+		encoded, err := appendEncodedLogs(nil, log)
+		require.NoError(t, err)
+		assert.Equal(t, GetLogEncodedSize(log), len(encoded))
+		size += len(encoded)
+		batch.addLog(log, encoded, nil)
+	}
+	assert.Equal(t, int64(size), batch.totalDataSize)
+	assert.Equal(t, 2, batch.getLogCount())
+
+	decoded := &sls.LogGroup{}
+	require.NoError(t, proto.Unmarshal(batch.rawData, decoded))
+	expected.Logs = logs
+	expected.LogTags = batch.logGroup.LogTags // with the pack id
+	assert.Equal(t, expected.String(), decoded.String())
+	assert.Equal(t, expected.Size(), len(batch.rawData))
+	assert.Equal(t, int64(expected.Size()), batch.totalDataSize)
+
+	batch.releaseRawData()
+	assert.Nil(t, batch.rawData)
+	assert.Equal(t, 2, batch.getLogCount())
+}
+
+func TestSendInvalidLog(t *testing.T) {
+	producer := newMockProducer(&mockSendClient{}, nil)
+	assert.Error(t, producer.SendLog("p", "l", "", "", &sls.Log{}))
+	assert.Error(t, producer.SendLogList("p", "l", "", "", []*sls.Log{GenerateLog(1, map[string]string{"k": "v"}), {}}))
+	assert.Equal(t, int64(0), producer.Stats().PendingBytes)
+}
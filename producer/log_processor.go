@@ -0,0 +1,166 @@
+package producer
+
+import (
+	"math/rand"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+// LogProcessor is applied to every log passed to the send methods, before it is added to a batch
+// and accounted into TotalSizeLnBytes. It returns the log to send, or false to drop the log.
+// The producer owns the logs after they are sent, so processors may modify them in place.
+// Processors are called concurrently by the goroutines calling the send methods, they must be thread safe.
+type LogProcessor func(log *sls.Log) (*sls.Log, bool)
+
+// ChainLogProcessors returns a processor applying processors in order, it stops at the first processor dropping the log.
+func ChainLogProcessors(processors ...LogProcessor) LogProcessor {
+	return func(log *sls.Log) (*sls.Log, bool) {
+		for _, processor := range processors {
+			var keep bool
+			if log, keep = processor(log); !keep || log == nil {
+				return nil, false
+			}
+		}
+		return log, true
+	}
+}
+
+// NewMaskProcessor replaces the parts of values matching pattern with replacement,
+// eg. to redact secrets. Only values of keys are masked, or all values if no key is given.
+func NewMaskProcessor(pattern *regexp.Regexp, replacement string, keys ...string) LogProcessor {
+	keySet := toKeySet(keys)
+	return func(log *sls.Log) (*sls.Log, bool) {
+		for _, content := range log.Contents {
+			if len(keySet) > 0 && !keySet[content.GetKey()] {
+				continue
+			}
+			if pattern.MatchString(content.GetValue()) {
+				content.Value = proto.String(pattern.ReplaceAllString(content.GetValue(), replacement))
+			}
+		}
+		return log, true
+	}
+}
+
+// NewDropFieldsProcessor removes the contents with the given keys.
+func NewDropFieldsProcessor(keys ...string) LogProcessor {
+	keySet := toKeySet(keys)
+	return func(log *sls.Log) (*sls.Log, bool) {
+		log.Contents = filterContents(log.Contents, func(content *sls.LogContent) bool {
+			return !keySet[content.GetKey()]
+		})
+		return log, true
+	}
+}
+
+// NewAllowKeysProcessor keeps only the contents with the given keys.
+func NewAllowKeysProcessor(keys ...string) LogProcessor {
+	keySet := toKeySet(keys)
+	return func(log *sls.Log) (*sls.Log, bool) {
+		log.Contents = filterContents(log.Contents, func(content *sls.LogContent) bool {
+			return keySet[content.GetKey()]
+		})
+		return log, true
+	}
+}
+
+// NewRenameFieldsProcessor renames the keys of contents, renames maps old keys to new keys.
+func NewRenameFieldsProcessor(renames map[string]string) LogProcessor {
+	return func(log *sls.Log) (*sls.Log, bool) {
+		for _, content := range log.Contents {
+			if newKey, ok := renames[content.GetKey()]; ok {
+				content.Key = proto.String(newKey)
+			}
+		}
+		return log, true
+	}
+}
+
+// NewTruncateProcessor truncates values longer than maxValueBytes, without breaking utf-8 characters.
+// A negative maxValueBytes is treated as 0, which truncates all values to empty.
+func NewTruncateProcessor(maxValueBytes int) LogProcessor {
+	if maxValueBytes < 0 {
+		maxValueBytes = 0
+	}
+	return func(log *sls.Log) (*sls.Log, bool) {
+		for _, content := range log.Contents {
+			value := content.GetValue()
+			if len(value) <= maxValueBytes {
+				continue
+			}
+			end := maxValueBytes
+			for end > 0 && !utf8.RuneStart(value[end]) {
+				end--
+			}
+			content.Value = proto.String(value[:end])
+		}
+		return log, true
+	}
+}
+
+// NewAddFieldsProcessor appends static fields to every log, eg. hostname or environment.
+func NewAddFieldsProcessor(fields map[string]string) LogProcessor {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return func(log *sls.Log) (*sls.Log, bool) {
+		for _, key := range keys {
+			log.Contents = append(log.Contents, &sls.LogContent{
+				Key:   proto.String(key),
+				Value: proto.String(fields[key]),
+			})
+		}
+		return log, true
+	}
+}
+
+// NewSamplingProcessor keeps the logs matched by match with the probability of rate, in range [0, 1].
+// Logs not matched are always kept, a nil match matches all logs.
+//
+// Example, keep 10% of debug logs:
+//
+//	NewSamplingProcessor(0.1, ContentEquals("level", "debug"))
+func NewSamplingProcessor(rate float64, match func(log *sls.Log) bool) LogProcessor {
+	return func(log *sls.Log) (*sls.Log, bool) {
+		if match != nil && !match(log) {
+			return log, true
+		}
+		return log, rand.Float64() < rate
+	}
+}
+
+// ContentEquals returns a matcher for logs having a content with the given key and value.
+func ContentEquals(key, value string) func(log *sls.Log) bool {
+	return func(log *sls.Log) bool {
+		for _, content := range log.Contents {
+			if content.GetKey() == key && content.GetValue() == value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func toKeySet(keys []string) map[string]bool {
+	keySet := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		keySet[key] = true
+	}
+	return keySet
+}
+
+func filterContents(contents []*sls.LogContent, keep func(content *sls.LogContent) bool) []*sls.LogContent {
+	result := contents[:0]
+	for _, content := range contents {
+		if keep(content) {
+			result = append(result, content)
+		}
+	}
+	return result
+}
@@ -0,0 +1,103 @@
+package producer
+
+import (
+	"regexp"
+	"testing"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func contentsOf(log *sls.Log) map[string]string {
+	m := make(map[string]string)
+	for _, content := range log.Contents {
+		m[content.GetKey()] = content.GetValue()
+	}
+	return m
+}
+
+func TestLogProcessors(t *testing.T) {
+	processor := ChainLogProcessors(
+		NewMaskProcessor(regexp.MustCompile(`password=\S+`), "password=***", "message"),
+		NewDropFieldsProcessor("noise"),
+		NewRenameFieldsProcessor(map[string]string{"msg_level": "level"}),
+		NewTruncateProcessor(8),
+		NewAddFieldsProcessor(map[string]string{"env": "prod"}),
+	)
+	log := GenerateLog(1, map[string]string{
+		"message":   "password=123456",
+		"noise":     "xxx",
+		"msg_level": "info",
+		"payload":   "你好世界",
+	})
+	log, ok := processor(log)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{
+		"message": "password",
+		"level":   "info",
+		"payload": "你好",
+		"env":     "prod",
+	}, contentsOf(log))
+
+	log = GenerateLog(1, map[string]string{"message": "password=123456", "other": "password=1"})
+	log, _ = NewMaskProcessor(regexp.MustCompile(`password=\S+`), "password=***", "message")(log)
+	assert.Equal(t, map[string]string{"message": "password=***", "other": "password=1"}, contentsOf(log))
+
+	log = GenerateLog(1, map[string]string{"a": "1", "b": "2", "c": "3"})
+	log, _ = NewAllowKeysProcessor("a", "c")(log)
+	assert.Equal(t, map[string]string{"a": "1", "c": "3"}, contentsOf(log))
+}
+
+func TestSamplingProcessor(t *testing.T) {
+	dropDebug := ChainLogProcessors(NewSamplingProcessor(0, ContentEquals("level", "debug")))
+	_, ok := dropDebug(GenerateLog(1, map[string]string{"level": "debug"}))
+	assert.False(t, ok)
+	_, ok = dropDebug(GenerateLog(1, map[string]string{"level": "error"}))
+	assert.True(t, ok)
+
+	keepAll := NewSamplingProcessor(1, nil)
+	_, ok = keepAll(GenerateLog(1, map[string]string{"level": "debug"}))
+	assert.True(t, ok)
+}
+
+func TestTruncateProcessor(t *testing.T) {
+	// "a" is 1 byte, "你" and "好" are 3 bytes each
+	for maxValueBytes, expected := range map[int]string{-1: "", 0: "", 1: "a", 2: "a", 3: "a", 4: "a你", 6: "a你", 7: "a你好", 8: "a你好"} {
+		log, ok := NewTruncateProcessor(maxValueBytes)(GenerateLog(1, map[string]string{"message": "a你好", "empty": ""}))
+		assert.True(t, ok)
+		assert.Equal(t, map[string]string{"message": expected, "empty": ""}, contentsOf(log), "maxValueBytes %d", maxValueBytes)
+	}
+}
+
+type countCallback struct {
+	success int
+	fail    int
+}
+
+func (c *countCallback) Success(result *Result) { c.success++ }
+func (c *countCallback) Fail(result *Result)    { c.fail++ }
+
+func TestLogAccumulatorProcessLogs(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.LogProcessors = []LogProcessor{NewSamplingProcessor(0, ContentEquals("level", "debug"))}
+	producer := &Producer{producerConfig: config, monitor: newProducerMonitor()}
+	accumulator := initLogAccumulator(config, nil, nil, nil, producer)
+
+	callback := &countCallback{}
+	err := accumulator.addLogToProducerBatch("p", "l", "", "", "", nil, PriorityNormal, GenerateLog(1, map[string]string{"level": "debug"}), callback)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, callback.success)
+	assert.Equal(t, int64(0), producer.producerLogGroupSize)
+
+	logList := []*sls.Log{
+		GenerateLog(1, map[string]string{"level": "debug"}),
+		GenerateLog(1, map[string]string{"level": "info"}),
+	}
+	err = accumulator.addLogToProducerBatch("p", "l", "", "", "", nil, PriorityNormal, logList, callback)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, callback.success)
+	assert.Equal(t, logGroupHeaderSize()+int64(GetLogEncodedSize(logList[1])), producer.producerLogGroupSize)
+	for _, batch := range accumulator.logGroupData {
+		assert.Equal(t, 1, batch.getLogCount())
+	}
+}
@@ -16,6 +16,7 @@ type Mover struct {
 	logAccumulator    *LogAccumulator
 	logger            log.Logger
 	threadPool        *IoThreadPool
+	wakeUpCh          chan struct{} // interrupts the sleep of the mover when the producer is closing
 }
 
 func initMover(logAccumulator *LogAccumulator, retryQueue *RetryQueue, ioWorker *IoWorker, logger log.Logger, threadPool *IoThreadPool) *Mover {
@@ -26,34 +27,28 @@ func initMover(logAccumulator *LogAccumulator, retryQueue *RetryQueue, ioWorker
 		logAccumulator:    logAccumulator,
 		logger:            logger,
 		threadPool:        threadPool,
+		wakeUpCh:          make(chan struct{}, 1),
 	}
 	return mover
 
 }
 
-func (mover *Mover) run(moverWaitGroup *sync.WaitGroup, config *ProducerConfig) {
+func (mover *Mover) run(moverWaitGroup *sync.WaitGroup) {
 	defer moverWaitGroup.Done()
+	defer mover.sendRetries()
 	defer mover.sendRemaining()
 
 	for !mover.moverShutDownFlag.Load() {
-		sleepMs := config.LingerMs
-		nowTimeMs := time.Now().UnixMilli()
-		toSendBatches := make([]*ProducerBatch, 0)
-
-		mover.logAccumulator.lock.Lock()
-		for key, batch := range mover.logAccumulator.logGroupData {
-			if batch == nil {
-				continue
-			}
-			timeInterval := batch.createTimeMs + config.LingerMs - nowTimeMs
-			if timeInterval <= 0 {
-				toSendBatches = append(toSendBatches, batch)
-				mover.logAccumulator.logGroupData[key] = nil
-			} else if sleepMs > timeInterval {
-				sleepMs = timeInterval
-			}
+		sleepMs := mover.ioWorker.producer.config().LingerMs
+		if adaptiveBatcher := mover.ioWorker.producer.adaptiveBatcher; adaptiveBatcher != nil {
+			sleepMs = adaptiveBatcher.minLingerMs()
 		}
+		mover.logAccumulator.lock.Lock()
+		toSendBatches, waitMs := mover.logAccumulator.takeLingerExpiredBatches(time.Now().UnixMilli())
 		mover.logAccumulator.lock.Unlock()
+		if waitMs >= 0 && waitMs < sleepMs {
+			sleepMs = waitMs
+		}
 
 		for _, batch := range toSendBatches {
 			mover.threadPool.addTask(batch)
@@ -64,42 +59,59 @@ func (mover *Mover) run(moverWaitGroup *sync.WaitGroup, config *ProducerConfig)
 			for _, batch := range retryBatches {
 				mover.threadPool.addTask(batch)
 			}
-			time.Sleep(time.Millisecond)
+			mover.sleep(time.Millisecond)
 		} else {
-			time.Sleep(time.Duration(sleepMs) * time.Millisecond)
+			mover.sleep(time.Duration(sleepMs) * time.Millisecond)
 		}
-
-		mover.clearEmptyKeys()
 	}
 
 }
 
-func (mover *Mover) clearEmptyKeys() {
-	mover.logAccumulator.lock.Lock()
-	if len(mover.logAccumulator.logGroupData) > 1000 {
-		for key, batch := range mover.logAccumulator.logGroupData {
-			if batch == nil {
-				delete(mover.logAccumulator.logGroupData, key)
-			}
-		}
-	}
-	mover.logAccumulator.lock.Unlock()
-}
-
 func (mover *Mover) sendRemaining() {
 	mover.logAccumulator.lock.Lock()
-	for _, batch := range mover.logAccumulator.logGroupData {
-		if batch != nil && batch.totalDataSize > 0 {
+	batches := mover.logAccumulator.takeAllBatches()
+	mover.logAccumulator.lock.Unlock()
+	for _, batch := range batches {
+		if batch.totalDataSize > 0 {
 			mover.threadPool.addTask(batch)
 		}
 	}
-	mover.logAccumulator.logGroupData = make(map[string]*ProducerBatch)
-	mover.logAccumulator.lock.Unlock()
 
-	producerBatchList := mover.retryQueue.getRetryBatch(mover.moverShutDownFlag.Load())
+	// all the batches are sent once more if the retries are disabled, otherwise they are sent by sendRetries after the backoff
+	producerBatchList := mover.retryQueue.getRetryBatch(mover.ioWorker.retryQueueShutDownFlag.Load())
 	for _, batch := range producerBatchList {
 		mover.threadPool.addTask(batch)
 	}
 
 	level.Info(mover.logger).Log("msg", "mover thread send remaining complete")
 }
+
+// sendRetries keeps sending the batches to retry after the mover is shut down by Shutdown,
+// until all the batches are sent or failed finally, or the retries are disabled when Shutdown times out
+func (mover *Mover) sendRetries() {
+	for !mover.ioWorker.retryQueueShutDownFlag.Load() && mover.ioWorker.producer.hasPendingData() {
+		for _, batch := range mover.retryQueue.getRetryBatch(false) {
+			mover.threadPool.addTask(batch)
+		}
+		mover.sleep(10 * time.Millisecond)
+	}
+}
+
+func (mover *Mover) sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-mover.wakeUpCh:
+	}
+}
+
+func (mover *Mover) wakeUp() {
+	select {
+	case mover.wakeUpCh <- struct{}{}:
+	default:
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMoverRange(t *testing.T) {
@@ -28,3 +32,45 @@ func syncMapCount(syncMap *sync.Map) int {
 	})
 	return count
 }
+
+func TestLingerQueue(t *testing.T) {
+	newBatch := func(createTimeMs, lingerMs int64) *ProducerBatch {
+		return &ProducerBatch{createTimeMs: createTimeMs, lingerMs: lingerMs}
+	}
+	var queue lingerQueue
+	first, second, third, removed := newBatch(100, 200), newBatch(0, 400), newBatch(300, 200), newBatch(0, 100)
+	for _, batch := range []*ProducerBatch{third, removed, second, first} {
+		queue.add(batch)
+	}
+	queue.remove(removed)
+	queue.remove(newBatch(0, 100)) // not in the queue
+
+	expired, waitMs := queue.popExpired(50)
+	assert.Empty(t, expired)
+	assert.Equal(t, int64(250), waitMs)
+
+	expired, waitMs = queue.popExpired(400)
+	assert.Equal(t, []*ProducerBatch{first, second}, expired)
+	assert.Equal(t, int64(100), waitMs)
+
+	expired, waitMs = queue.popExpired(1000)
+	assert.Equal(t, []*ProducerBatch{third}, expired)
+	assert.Equal(t, int64(-1), waitMs)
+}
+
+func TestMoverSendsLingerExpiredBatches(t *testing.T) {
+	client := &mockSendClient{}
+	producer := newMockProducer(client, nil)
+	producer.Start()
+	defer producer.SafeClose()
+
+	callback := &resultCollector{}
+	require.NoError(t, producer.SendLogWithCallBack("p", "l", "", "", GenerateLog(1, map[string]string{"k": "v"}), callback))
+	require.NoError(t, producer.SendLogWithCallBack("p", "other", "", "", GenerateLog(1, map[string]string{"k": "v"}), callback))
+	assert.Eventually(t, func() bool { return len(callback.get()) == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	producer.logAccumulator.lock.Lock()
+	defer producer.logAccumulator.lock.Unlock()
+	assert.Empty(t, producer.logAccumulator.logGroupData)
+	assert.Empty(t, producer.logAccumulator.lingerQueue)
+}
@@ -6,46 +6,151 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	// the count of sequence numbers reserved by each save to the PackIdStore
+	packIdReserveSize = 10000
+	// the interval to save to the PackIdStore again after a failure
+	packIdSaveRetryBackoff = time.Second
 )
 
 type PackIdGenerator struct {
 	mutex                   sync.RWMutex
 	logstorePackIdGenerator map[string]*LogStorePackIdGenerator
 	count                   atomic.Int32
+	store                   PackIdStore // optional
+	logger                  log.Logger
+	saving                  sync.WaitGroup
 }
 
 func newPackIdGenerator() *PackIdGenerator {
 	return &PackIdGenerator{
 		logstorePackIdGenerator: make(map[string]*LogStorePackIdGenerator),
+		logger:                  log.NewNopLogger(),
 	}
 }
 
+func newPackIdGeneratorWithStore(store PackIdStore, logger log.Logger) *PackIdGenerator {
+	g := newPackIdGenerator()
+	g.store = store
+	g.logger = logger
+	return g
+}
+
 func (g *PackIdGenerator) GeneratePackId(project, logstore string) string {
 	key := project + "|" + logstore
 
 	// fast path, logstore already has a generator
 	g.mutex.RLock()
 	if l, ok := g.logstorePackIdGenerator[key]; ok {
-		packNumber := l.packNumber.Add(1)
 		g.mutex.RUnlock()
-		return fmt.Sprintf("%s%X", l.prefix, packNumber-1)
+		return l.next(g, project, logstore)
 	}
 	g.mutex.RUnlock()
 
 	// slow path
 	g.mutex.Lock()
 	if _, ok := g.logstorePackIdGenerator[key]; !ok {
-		g.logstorePackIdGenerator[key] = newLogStorePackIdGenerator(g.count.Add(1))
+		g.logstorePackIdGenerator[key] = g.newLogStorePackIdGenerator(project, logstore)
 	}
 	l := g.logstorePackIdGenerator[key]
-	packNumber := l.packNumber.Add(1)
 	g.mutex.Unlock()
-	return fmt.Sprintf("%s%X", l.prefix, packNumber-1)
+	return l.next(g, project, logstore)
+}
+
+// waitSaving waits for the saves to the store running in the background
+func (g *PackIdGenerator) waitSaving() {
+	g.saving.Wait()
+}
+
+// newLogStorePackIdGenerator continues the prefix and sequence saved in the store if there is one,
+// so that pack ids of a logstore keep increasing across restarts.
+// The first block of sequence numbers is reserved at once when the prefix is continued, the later ones are reserved
+// in the background before they are used up.
+func (g *PackIdGenerator) newLogStorePackIdGenerator(project, logstore string) *LogStorePackIdGenerator {
+	l := newLogStorePackIdGenerator(g.count.Add(1))
+	if g.store == nil {
+		return l
+	}
+	state, err := g.store.Load(project, logstore)
+	if err != nil {
+		level.Warn(g.logger).Log("msg", "failed to load pack id state, use a new prefix", "project", project, "logstore", logstore, "error", err)
+		return l
+	}
+	if state == nil || state.Prefix == "" {
+		return l
+	}
+	reserved := state.NextSequence + packIdReserveSize
+	if err = g.store.Save(project, logstore, &PackIdState{Prefix: state.Prefix, NextSequence: reserved}); err != nil {
+		level.Warn(g.logger).Log("msg", "failed to save pack id state, use a new prefix", "project", project, "logstore", logstore, "error", err)
+		return l
+	}
+	l.prefix = state.Prefix + "-"
+	l.packNumber.Store(state.NextSequence)
+	l.persisted, l.reserved = true, reserved
+	return l
 }
 
 type LogStorePackIdGenerator struct {
 	packNumber atomic.Int64
 	prefix     string // with "-"
+
+	// only used with a PackIdStore.
+	// A prefix never saved can't be continued after a restart, so all of its sequence numbers can be used.
+	// Once the prefix is saved, only the sequence numbers below reserved can be used.
+	mutex     sync.Mutex
+	persisted bool
+	reserved  int64
+	saving    bool
+	retryTime time.Time // no save is tried before it after a failure
+}
+
+func (l *LogStorePackIdGenerator) next(g *PackIdGenerator, project, logstore string) string {
+	if g.store == nil {
+		return fmt.Sprintf("%s%X", l.prefix, l.packNumber.Add(1)-1)
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	packNumber := l.packNumber.Add(1) - 1
+	if l.persisted && packNumber >= l.reserved {
+		// the reservation is not extended in time, the sequence numbers after it may be used again after a restart
+		level.Warn(g.logger).Log("msg", "reserved pack id sequence is used up, use a new prefix", "project", project, "logstore", logstore)
+		l.prefix = newLogStorePackIdGenerator(g.count.Add(1)).prefix
+		l.persisted = false
+		l.packNumber.Store(1)
+		packNumber = 0
+	}
+	if !l.saving && !time.Now().Before(l.retryTime) && (!l.persisted || packNumber >= l.reserved-packIdReserveSize/2) {
+		l.saving = true
+		g.saving.Add(1)
+		go l.reserve(g, project, logstore, l.prefix, packNumber+packIdReserveSize)
+	}
+	return fmt.Sprintf("%s%X", l.prefix, packNumber)
+}
+
+// reserve saves the prefix with a block of sequence numbers to the store before they are used,
+// so a restarted producer never reuses them even if it is killed. It runs in the background,
+// the sequence numbers of a saved prefix are not used beyond the last reservation saved successfully.
+func (l *LogStorePackIdGenerator) reserve(g *PackIdGenerator, project, logstore, prefix string, reserved int64) {
+	defer g.saving.Done()
+	err := g.store.Save(project, logstore, &PackIdState{Prefix: strings.TrimSuffix(prefix, "-"), NextSequence: reserved})
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.saving = false
+	if err != nil {
+		level.Warn(g.logger).Log("msg", "failed to save pack id state", "project", project, "logstore", logstore, "error", err)
+		l.retryTime = time.Now().Add(packIdSaveRetryBackoff)
+		return
+	}
+	// the prefix is changed, or the sequence numbers are used up while saving, it is saved again by the next call
+	if l.prefix != prefix || l.packNumber.Load() >= reserved {
+		return
+	}
+	l.persisted, l.reserved = true, reserved
 }
 
 func newLogStorePackIdGenerator(id int32) *LogStorePackIdGenerator {
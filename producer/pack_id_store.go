@@ -0,0 +1,93 @@
+package producer
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// PackIdState is the persisted pack id state of a logstore.
+type PackIdState struct {
+	Prefix       string `json:"prefix"`        // without the trailing "-"
+	NextSequence int64  `json:"next_sequence"` // sequence numbers below it may have been used
+}
+
+// PackIdStore persists the pack id prefix and sequence of each logstore, so that a restarted producer
+// keeps generating increasing pack ids with the same prefix, and consumers can tell duplicates by them.
+// Implementations must be thread safe.
+type PackIdStore interface {
+	// Load returns nil if nothing is saved for the logstore
+	Load(project, logstore string) (*PackIdState, error)
+	Save(project, logstore string, state *PackIdState) error
+}
+
+type filePackIdStore struct {
+	mutex sync.Mutex
+	path  string
+}
+
+// NewFilePackIdStore returns a PackIdStore saving the states of all logstores in a JSON file.
+// The file must not be shared by producers running at the same time.
+func NewFilePackIdStore(path string) PackIdStore {
+	return &filePackIdStore{path: path}
+}
+
+func (s *filePackIdStore) Load(project, logstore string) (*PackIdState, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	states, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	return states[project+Delimiter+logstore], nil
+}
+
+func (s *filePackIdStore) Save(project, logstore string, state *PackIdState) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	states, err := s.read()
+	if err != nil {
+		return err
+	}
+	states[project+Delimiter+logstore] = state
+	data, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	// write to a temp file and rename, so that the file is never half written
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *filePackIdStore) read() (map[string]*PackIdState, error) {
+	states := make(map[string]*PackIdState)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return states, nil
+	}
+	if err = json.Unmarshal(data, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
@@ -1,11 +1,21 @@
 package producer
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
 )
 
 func TestPackIdGenerator(t *testing.T) {
@@ -43,3 +53,149 @@ func BenchmarkPackIdGenerator(b *testing.B) {
 		g.GeneratePackId("test", "test")
 	}
 }
+
+func TestPackIdGeneratorWithStore(t *testing.T) {
+	store := NewFilePackIdStore(filepath.Join(t.TempDir(), "pack_id.json"))
+	g := newPackIdGeneratorWithStore(store, log.NewNopLogger())
+	defer g.waitSaving()
+	first := g.GeneratePackId("p", "l")
+	second := g.GeneratePackId("p", "l")
+	prefix, sequence := splitPackId(t, second)
+	assert.Equal(t, int64(1), sequence)
+	assert.Equal(t, prefix, first[:16])
+
+	// the prefix is saved in the background
+	assert.Eventually(t, func() bool {
+		state, err := store.Load("p", "l")
+		return err == nil && state != nil && state.Prefix == prefix
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// a restarted producer keeps the prefix, and never reuses a sequence number
+	restarted := newPackIdGeneratorWithStore(store, log.NewNopLogger())
+	defer restarted.waitSaving()
+	restartedPrefix, restartedSequence := splitPackId(t, restarted.GeneratePackId("p", "l"))
+	assert.Equal(t, prefix, restartedPrefix)
+	assert.Greater(t, restartedSequence, sequence)
+
+	other := restarted.GeneratePackId("p", "other")
+	assert.NotEqual(t, prefix, other[:16])
+}
+
+// failingPackIdStore fails to save when failing is true
+type failingPackIdStore struct {
+	PackIdStore
+	failing atomic.Bool
+}
+
+func (s *failingPackIdStore) Save(project, logstore string, state *PackIdState) error {
+	if s.failing.Load() {
+		return errors.New("disk full")
+	}
+	return s.PackIdStore.Save(project, logstore, state)
+}
+
+func TestPackIdGeneratorSaveFailed(t *testing.T) {
+	store := &failingPackIdStore{PackIdStore: NewFilePackIdStore(filepath.Join(t.TempDir(), "pack_id.json"))}
+	g := newPackIdGeneratorWithStore(store, log.NewNopLogger())
+	defer g.waitSaving()
+	prefix, _ := splitPackId(t, g.GeneratePackId("p", "l"))
+	assert.Eventually(t, func() bool {
+		state, err := store.Load("p", "l")
+		return err == nil && state != nil && state.Prefix == prefix
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the reservation can't be extended, a new prefix is used once it is used up
+	store.failing.Store(true)
+	used := map[string]bool{}
+	for i := 0; i < 2*packIdReserveSize; i++ {
+		packId := g.GeneratePackId("p", "l")
+		assert.False(t, used[packId], packId)
+		used[packId] = true
+	}
+	lastPrefix, _ := splitPackId(t, g.GeneratePackId("p", "l"))
+	assert.NotEqual(t, prefix, lastPrefix)
+
+	// a restarted producer continues the saved prefix after the sequence numbers used
+	store.failing.Store(false)
+	restarted := newPackIdGeneratorWithStore(store, log.NewNopLogger())
+	defer restarted.waitSaving()
+	for i := 0; i < 10; i++ {
+		packId := restarted.GeneratePackId("p", "l")
+		assert.False(t, used[packId], packId)
+		restartedPrefix, _ := splitPackId(t, packId)
+		assert.Equal(t, prefix, restartedPrefix)
+	}
+}
+
+func TestPackIdStableAcrossRetries(t *testing.T) {
+	client := &mockSendClient{
+		errorFunc: func(project, logstore string, n int) error {
+			if logstore == "bad" {
+				return &sls.Error{HTTPCode: 404, Code: sls.LOGSTORE_NOT_EXIST}
+			}
+			if n == 0 {
+				return &sls.Error{HTTPCode: 500, Code: sls.INTERNAL_SERVER_ERROR}
+			}
+			return nil
+		},
+	}
+	config := GetDefaultProducerConfig()
+	config.GeneratePackId = true
+	config.BaseRetryBackoffMs = 1
+	config.LingerMs = 100
+	config.ReportUndeliveredBatches = true
+	producer := newMockProducer(client, config)
+	producer.Start()
+
+	callback := &resultCollector{}
+	require.NoError(t, producer.SendLogWithCallBack("p", "l", "", "", GenerateLog(1, map[string]string{"k": "v"}), callback))
+	// retry is disabled once the producer is shutting down, wait for the result first
+	assert.Eventually(t, func() bool { return len(callback.get()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, producer.SendLog("p", "bad", "", "", GenerateLog(1, map[string]string{"k": "v"})))
+	report, err := producer.Shutdown(context.Background())
+	require.NoError(t, err)
+
+	// a retried batch is sent with the same pack id
+	var packIds []string
+	for _, r := range client.sentRequests() {
+		if r.logstore == "l" {
+			packIds = append(packIds, getPackIdTag(r.req.LogGroup))
+		}
+	}
+	require.Len(t, packIds, 2)
+	assert.NotEmpty(t, packIds[0])
+	assert.Equal(t, packIds[0], packIds[1])
+
+	// an undelivered batch sent again, eg. by a restarted producer, keeps its pack id
+	require.Len(t, report.UndeliveredBatches, 1)
+	undelivered := report.UndeliveredBatches[0]
+	packId := getPackIdTag(undelivered.LogGroup)
+	assert.NotEmpty(t, packId)
+
+	resendClient := &mockSendClient{}
+	resendProducer := newMockProducer(resendClient, config)
+	resendProducer.Start()
+	require.NoError(t, resendProducer.SendLogGroup(undelivered.Project, "l", "", undelivered.LogGroup))
+	resendProducer.SafeClose()
+	requests := resendClient.sentRequests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, packId, getPackIdTag(requests[0].req.LogGroup))
+	assert.Len(t, requests[0].req.LogGroup.LogTags, 1)
+}
+
+func getPackIdTag(logGroup *sls.LogGroup) string {
+	for _, tag := range logGroup.LogTags {
+		if tag.GetKey() == PACK_ID_KEY {
+			return tag.GetValue()
+		}
+	}
+	return ""
+}
+
+func splitPackId(t *testing.T, packId string) (string, int64) {
+	i := strings.LastIndex(packId, "-")
+	require.True(t, i > 0, packId)
+	sequence, err := strconv.ParseInt(packId[i+1:], 16, 64)
+	require.NoError(t, err)
+	return packId[:i], sequence
+}
@@ -0,0 +1,34 @@
+package producer
+
+import (
+	"math"
+)
+
+// Priority is the class of logs sharing the memory, io workers and retry queue of a producer.
+type Priority int
+
+const (
+	// PriorityNormal is the priority of logs sent without SendOptions.Priority, eg. bulk debug logs.
+	PriorityNormal Priority = iota
+	// PriorityHigh is for critical logs such as audit or error logs. Their batches are dispatched and retried before
+	// normal ones, and can use the memory and io workers reserved by ProducerConfig.HighPriorityReservedRatio.
+	PriorityHigh
+)
+
+// memoryLimit returns the size of cached logs above which sending logs of the priority blocks.
+// Normal logs can't use the memory reserved for high priority logs, so they are rejected first under backpressure.
+func (producer *Producer) memoryLimit(priority Priority) int64 {
+	config := producer.config()
+	if priority == PriorityHigh {
+		return config.TotalSizeLnBytes
+	}
+	return config.TotalSizeLnBytes - reservedForHighPriority(config.TotalSizeLnBytes, config.HighPriorityReservedRatio)
+}
+
+// reservedForHighPriority returns the part of total reserved for high priority logs
+func reservedForHighPriority(total int64, ratio float64) int64 {
+	if ratio <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(float64(total) * ratio))
+}
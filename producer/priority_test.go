@@ -0,0 +1,89 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriorityDispatch(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		reservedRatio float64
+		check         func(t *testing.T, highIndex int)
+	}{
+		// the thread pool may wait for an io worker with a normal batch in hand, the high priority one is the next
+		{"dispatched first", 0, func(t *testing.T, highIndex int) { assert.LessOrEqual(t, highIndex, 3) }},
+		// normal batches can't use the reserved io worker, the high priority one is sent at once
+		{"reserved io worker", 0.5, func(t *testing.T, highIndex int) { assert.Equal(t, 1, highIndex) }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockSendClient{latency: 100 * time.Millisecond}
+			config := GetDefaultProducerConfig()
+			config.MaxIoWorkerCount = 2
+			config.MaxBatchCount = 1 // every log is sealed at once
+			config.HighPriorityReservedRatio = tt.reservedRatio
+			producer := newMockProducer(client, config)
+			producer.Start()
+
+			for i := 0; i < 6; i++ {
+				require.NoError(t, producer.SendLog("p", "bulk", "", "", GenerateLog(1, map[string]string{"k": "v"})))
+			}
+			assert.Eventually(t, func() bool { return len(client.sentRequests()) > 0 }, time.Second, time.Millisecond)
+			require.NoError(t, producer.SendLogListWithOptions("p", "audit", "", "", []*sls.Log{GenerateLog(1, map[string]string{"k": "v"})}, &SendOptions{Priority: PriorityHigh}))
+			producer.SafeClose()
+
+			requests := client.sentRequests()
+			require.Len(t, requests, 7)
+			highIndex := -1
+			for i, r := range requests {
+				if r.logstore == "audit" {
+					highIndex = i
+				}
+			}
+			tt.check(t, highIndex)
+		})
+	}
+}
+
+func TestPriorityReservedMemory(t *testing.T) {
+	logSize := int64(GetLogEncodedSize(GenerateLog(1, map[string]string{"k": "v"})))
+	config := GetDefaultProducerConfig()
+	// each logstore takes one batch, whose header is counted as well
+	config.TotalSizeLnBytes = logSize*10 + logGroupHeaderSize()*2
+	config.HighPriorityReservedRatio = 0.5
+	config.MaxBlockSec = 0
+	producer := newMockProducer(&mockSendClient{}, config)
+
+	normal := 0
+	for producer.SendLog("p", "bulk", "", "", GenerateLog(1, map[string]string{"k": "v"})) == nil {
+		normal++
+	}
+	assert.Equal(t, 6, normal) // blocked once more than half of the memory is used
+	high := 0
+	for producer.SendLogListWithOptions("p", "audit", "", "", []*sls.Log{GenerateLog(1, map[string]string{"k": "v"})}, &SendOptions{Priority: PriorityHigh}) == nil {
+		high++
+	}
+	assert.Equal(t, 5, high) // the reserved memory is only used by high priority logs
+	assert.Equal(t, 2, len(producer.logAccumulator.logGroupData))
+}
+
+func TestRetryQueuePriority(t *testing.T) {
+	now := time.Now().UnixMilli()
+	normal := &ProducerBatch{priority: PriorityNormal, nextRetryMs: now - 200}
+	high := &ProducerBatch{priority: PriorityHigh, nextRetryMs: now - 100}
+	later := &ProducerBatch{priority: PriorityHigh, nextRetryMs: now + 60*1000}
+	queue := initRetryQueue()
+	for _, batch := range []*ProducerBatch{normal, later, high} {
+		queue.sendToRetryQueue(batch, log.NewNopLogger())
+	}
+	assert.Equal(t, 3, queue.size())
+	// the high priority batches are taken first
+	assert.Equal(t, []*ProducerBatch{high, normal}, queue.getRetryBatch(false))
+	assert.Equal(t, []*ProducerBatch{later}, queue.getRetryBatch(true))
+	assert.Equal(t, 0, queue.size())
+}
@@ -27,14 +27,30 @@ type Producer struct {
 	buckets               int
 	logger                log.Logger
 	producerLogGroupSize  int64
+	producerLogCount      int64
 	monitor               *ProducerMonitor
+	stats                 *statsCollector
 	stsCloseOnce          sync.Once
+	shardRouter           *ShardRouter
+	rateLimiter           *RateLimiter
+	undelivered           atomic.Pointer[undeliveredCollector] // only set during Shutdown
+	fanoutDestinations    []*fanoutDestination
+	adaptiveBatcher       *adaptiveBatcher
+	liveConfig            atomic.Pointer[ProducerConfig] // the latest config set by UpdateConfig
+	configMutex           sync.Mutex
 }
 
 func NewProducer(producerConfig *ProducerConfig) (*Producer, error) {
 	logger := getProducerLogger(producerConfig)
 	finalProducerConfig := validateProducerConfig(producerConfig, logger)
 
+	// logs are sent by the clients of the destinations in fan-out mode
+	if len(finalProducerConfig.FanoutDestinations) > 0 {
+		if err := validateFanoutDestinations(finalProducerConfig.FanoutDestinations); err != nil {
+			return nil, err
+		}
+		return createProducerInternal(nil, finalProducerConfig, logger), nil
+	}
 	client, err := createClient(finalProducerConfig, false, logger)
 	if err != nil {
 		return nil, err
@@ -43,16 +59,26 @@ func NewProducer(producerConfig *ProducerConfig) (*Producer, error) {
 }
 
 // Deprecated: use NewProducer instead.
+// InitProducer panics if the FanoutDestinations are invalid.
 func InitProducer(producerConfig *ProducerConfig) *Producer {
 	logger := getProducerLogger(producerConfig)
 	finalProducerConfig := validateProducerConfig(producerConfig, logger)
 
+	if len(finalProducerConfig.FanoutDestinations) > 0 {
+		if err := validateFanoutDestinations(finalProducerConfig.FanoutDestinations); err != nil {
+			panic(err)
+		}
+		return createProducerInternal(nil, finalProducerConfig, logger)
+	}
 	client, _ := createClient(finalProducerConfig, true, logger)
 	return createProducerInternal(client, finalProducerConfig, logger)
 }
 
+// client is nil in fan-out mode
 func createProducerInternal(client sls.ClientInterface, finalProducerConfig *ProducerConfig, logger log.Logger) *Producer {
-	configureClient(client, finalProducerConfig)
+	if client != nil {
+		configureClient(client, finalProducerConfig)
+	}
 	retryQueue := initRetryQueue()
 	errorStatusMap := func() map[int]*string {
 		errorCodeMap := map[int]*string{}
@@ -65,7 +91,21 @@ func createProducerInternal(client sls.ClientInterface, finalProducerConfig *Pro
 		producerConfig: finalProducerConfig,
 		buckets:        finalProducerConfig.Buckets,
 	}
+	producer.liveConfig.Store(finalProducerConfig)
+	if len(finalProducerConfig.FanoutDestinations) > 0 {
+		producer.fanoutDestinations = initFanoutDestinations(finalProducerConfig, logger)
+	}
+	if finalProducerConfig.ShardAwareRouting {
+		producer.shardRouter = initShardRouter(client, finalProducerConfig, logger)
+	}
+	if finalProducerConfig.AdaptiveBatching != nil {
+		producer.adaptiveBatcher = newAdaptiveBatcher(finalProducerConfig, logger)
+	}
+	if len(finalProducerConfig.RateLimits) > 0 {
+		producer.rateLimiter = initRateLimiter(finalProducerConfig.RateLimits, logger)
+	}
 	ioWorker := initIoWorker(client, retryQueue, logger, finalProducerConfig.MaxIoWorkerCount, errorStatusMap, producer)
+	ioWorker.reserveForHighPriority(finalProducerConfig.HighPriorityReservedRatio)
 	threadPool := initIoThreadPool(ioWorker, logger)
 	logAccumulator := initLogAccumulator(finalProducerConfig, ioWorker, logger, threadPool, producer)
 	mover := initMover(logAccumulator, retryQueue, ioWorker, logger, threadPool)
@@ -78,6 +118,7 @@ func createProducerInternal(client sls.ClientInterface, finalProducerConfig *Pro
 	producer.ioThreadPoolWaitGroup = &sync.WaitGroup{}
 	producer.logger = logger
 	producer.monitor = newProducerMonitor()
+	producer.stats = newStatsCollector()
 	return producer
 }
 
@@ -147,6 +188,20 @@ func validateProducerConfig(producerConfig *ProducerConfig, logger log.Logger) *
 		level.Warn(logger).Log("msg", "The LingerMs parameter cannot be less than 100 milliseconds and has been reset to the default value of 2000 milliseconds")
 		producerConfig.LingerMs = 2000
 	}
+	if producerConfig.HighPriorityReservedRatio < 0 || producerConfig.HighPriorityReservedRatio >= 1 {
+		level.Warn(logger).Log("msg", "The HighPriorityReservedRatio parameter must be in [0, 1), and has been reset to 0")
+		producerConfig.HighPriorityReservedRatio = 0
+	}
+	if producerConfig.AdaptiveBatching != nil {
+		validateAdaptiveBatchingConfig(producerConfig, logger)
+	}
+	if producerConfig.ShardAwareRouting && len(producerConfig.FanoutDestinations) > 0 {
+		level.Warn(logger).Log("msg", "ShardAwareRouting is not supported with FanoutDestinations, and has been disabled")
+		producerConfig.ShardAwareRouting = false
+	}
+	if producerConfig.ShardAwareRouting && producerConfig.ShardRoutingRefreshIntervalMs <= 0 {
+		producerConfig.ShardRoutingRefreshIntervalMs = 60 * 1000
+	}
 	return producerConfig
 }
 
@@ -155,13 +210,11 @@ func (producer *Producer) HashSendLogWithCallBack(project, logstore, shardHash,
 	if err != nil {
 		return err
 	}
-	if producer.producerConfig.AdjustShargHash {
-		shardHash, err = AdjustHash(shardHash, producer.buckets)
-		if err != nil {
-			return err
-		}
+	shardHash, err = producer.adjustShardHash(project, logstore, shardHash)
+	if err != nil {
+		return err
 	}
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, shardHash, topic, source, log, callback)
+	return producer.logAccumulator.addLogToProducerBatch(project, logstore, shardHash, topic, source, nil, PriorityNormal, log, callback)
 }
 
 func (producer *Producer) HashSendLogListWithCallBack(project, logstore, shardHash, topic, source string, logList []*sls.Log, callback CallBack) (err error) {
@@ -170,13 +223,11 @@ func (producer *Producer) HashSendLogListWithCallBack(project, logstore, shardHa
 	if err != nil {
 		return err
 	}
-	if producer.producerConfig.AdjustShargHash {
-		shardHash, err = AdjustHash(shardHash, producer.buckets)
-		if err != nil {
-			return err
-		}
+	shardHash, err = producer.adjustShardHash(project, logstore, shardHash)
+	if err != nil {
+		return err
 	}
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, shardHash, topic, source, logList, callback)
+	return producer.logAccumulator.addLogToProducerBatch(project, logstore, shardHash, topic, source, nil, PriorityNormal, logList, callback)
 }
 
 func (producer *Producer) SendLog(project, logstore, topic, source string, log *sls.Log) error {
@@ -184,7 +235,7 @@ func (producer *Producer) SendLog(project, logstore, topic, source string, log *
 	if err != nil {
 		return err
 	}
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, "", topic, source, log, nil)
+	return producer.logAccumulator.addLogToProducerBatch(project, logstore, producer.unkeyedShardHash(project, logstore), topic, source, nil, PriorityNormal, log, nil)
 }
 
 func (producer *Producer) SendLogList(project, logstore, topic, source string, logList []*sls.Log) (err error) {
@@ -193,7 +244,7 @@ func (producer *Producer) SendLogList(project, logstore, topic, source string, l
 		return err
 	}
 
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, "", topic, source, logList, nil)
+	return producer.logAccumulator.addLogToProducerBatch(project, logstore, producer.unkeyedShardHash(project, logstore), topic, source, nil, PriorityNormal, logList, nil)
 
 }
 
@@ -202,13 +253,11 @@ func (producer *Producer) HashSendLog(project, logstore, shardHash, topic, sourc
 	if err != nil {
 		return err
 	}
-	if producer.producerConfig.AdjustShargHash {
-		shardHash, err = AdjustHash(shardHash, producer.buckets)
-		if err != nil {
-			return err
-		}
+	shardHash, err = producer.adjustShardHash(project, logstore, shardHash)
+	if err != nil {
+		return err
 	}
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, shardHash, topic, source, log, nil)
+	return producer.logAccumulator.addLogToProducerBatch(project, logstore, shardHash, topic, source, nil, PriorityNormal, log, nil)
 }
 
 func (producer *Producer) HashSendLogList(project, logstore, shardHash, topic, source string, logList []*sls.Log) (err error) {
@@ -216,13 +265,11 @@ func (producer *Producer) HashSendLogList(project, logstore, shardHash, topic, s
 	if err != nil {
 		return err
 	}
-	if producer.producerConfig.AdjustShargHash {
-		shardHash, err = AdjustHash(shardHash, producer.buckets)
-		if err != nil {
-			return err
-		}
+	shardHash, err = producer.adjustShardHash(project, logstore, shardHash)
+	if err != nil {
+		return err
 	}
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, shardHash, topic, source, logList, nil)
+	return producer.logAccumulator.addLogToProducerBatch(project, logstore, shardHash, topic, source, nil, PriorityNormal, logList, nil)
 
 }
 
@@ -231,7 +278,7 @@ func (producer *Producer) SendLogWithCallBack(project, logstore, topic, source s
 	if err != nil {
 		return err
 	}
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, "", topic, source, log, callback)
+	return producer.logAccumulator.addLogToProducerBatch(project, logstore, producer.unkeyedShardHash(project, logstore), topic, source, nil, PriorityNormal, log, callback)
 }
 
 func (producer *Producer) SendLogListWithCallBack(project, logstore, topic, source string, logList []*sls.Log, callback CallBack) (err error) {
@@ -239,19 +286,81 @@ func (producer *Producer) SendLogListWithCallBack(project, logstore, topic, sour
 	if err != nil {
 		return err
 	}
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, "", topic, source, logList, callback)
+	return producer.logAccumulator.addLogToProducerBatch(project, logstore, producer.unkeyedShardHash(project, logstore), topic, source, nil, PriorityNormal, logList, callback)
+
+}
+
+// SendOptions are the optional settings of a send call of SendLogListWithOptions.
+type SendOptions struct {
+	// Optional, defaults to "".
+	// If set, the logs are sent to the shard of the hash as by HashSendLogList.
+	ShardHash string
+	// Optional, defaults to nil.
+	// Tags of the send call, eg. tenant or trace id, added in addition to ProducerConfig.LogTags.
+	// Logs with different tags are sent in different LogGroups. The tags must not be modified after the call.
+	Tags []*sls.LogTag
+	// Optional, defaults to PriorityNormal.
+	// Logs of different priorities are sent in different LogGroups.
+	Priority Priority
+	// Optional, defaults to nil.
+	CallBack CallBack
+}
+
+// SendLogListWithOptions sends the logs with the options, nil options works like SendLogList.
+func (producer *Producer) SendLogListWithOptions(project, logstore, topic, source string, logList []*sls.Log, options *SendOptions) error {
+	if options == nil {
+		options = &SendOptions{}
+	}
+	err := producer.waitMemory(options.Priority)
+	if err != nil {
+		return err
+	}
+	shardHash := producer.unkeyedShardHash(project, logstore)
+	if options.ShardHash != "" {
+		shardHash, err = producer.adjustShardHash(project, logstore, options.ShardHash)
+		if err != nil {
+			return err
+		}
+	}
+	return producer.logAccumulator.addLogToProducerBatch(project, logstore, shardHash, topic, source, options.Tags, options.Priority, logList, options.CallBack)
+}
+
+func (producer *Producer) adjustShardHash(project, logstore, shardHash string) (string, error) {
+	if producer.shardRouter != nil {
+		if routed, ok := producer.shardRouter.route(project, logstore, shardHash); ok {
+			return routed, nil
+		}
+	}
+	if producer.producerConfig.AdjustShargHash {
+		return AdjustHash(shardHash, producer.buckets)
+	}
+	return shardHash, nil
+}
 
+func (producer *Producer) unkeyedShardHash(project, logstore string) string {
+	if producer.shardRouter == nil {
+		return ""
+	}
+	routed, _ := producer.shardRouter.route(project, logstore, "")
+	return routed
 }
 
 // todo: refactor this
 func (producer *Producer) waitTime() error {
-	if atomic.LoadInt64(&producer.producerLogGroupSize) <= producer.producerConfig.TotalSizeLnBytes {
+	return producer.waitMemory(PriorityNormal)
+}
+
+// waitMemory waits until the cached logs are within the memory limit of the priority
+func (producer *Producer) waitMemory(priority Priority) error {
+	limit := producer.memoryLimit(priority)
+	if atomic.LoadInt64(&producer.producerLogGroupSize) <= limit {
 		return nil
 	}
 
 	// no wait
-	if producer.producerConfig.MaxBlockSec == 0 {
-		if atomic.LoadInt64(&producer.producerLogGroupSize) > producer.producerConfig.TotalSizeLnBytes {
+	maxBlockSec := producer.config().MaxBlockSec
+	if maxBlockSec == 0 {
+		if atomic.LoadInt64(&producer.producerLogGroupSize) > limit {
 			level.Error(producer.logger).Log("msg", "Over producer set maximum blocking time")
 			return errors.New(TimeoutExecption)
 		}
@@ -261,16 +370,16 @@ func (producer *Producer) waitTime() error {
 	defer producer.monitor.recordWaitMemory(time.Now())
 
 	// infinite wait
-	if producer.producerConfig.MaxBlockSec < 0 {
-		for atomic.LoadInt64(&producer.producerLogGroupSize) > producer.producerConfig.TotalSizeLnBytes {
+	if maxBlockSec < 0 {
+		for atomic.LoadInt64(&producer.producerLogGroupSize) > limit {
 			time.Sleep(waitTimeUnit)
 		}
 		return nil
 	}
 
 	// todo: refine this, limited wait
-	for i := 0; i < producer.producerConfig.MaxBlockSec*waitUnitPerSec; i++ {
-		if atomic.LoadInt64(&producer.producerLogGroupSize) > producer.producerConfig.TotalSizeLnBytes {
+	for i := 0; i < maxBlockSec*waitUnitPerSec; i++ {
+		if atomic.LoadInt64(&producer.producerLogGroupSize) > limit {
 			time.Sleep(waitTimeUnit)
 		} else {
 			return nil
@@ -288,7 +397,7 @@ const waitUnitPerSec = int(time.Second / waitTimeUnit)
 func (producer *Producer) Start() {
 	producer.moverWaitGroup.Add(1)
 	level.Info(producer.logger).Log("msg", "producer mover start")
-	go producer.mover.run(producer.moverWaitGroup, producer.producerConfig)
+	go producer.mover.run(producer.moverWaitGroup)
 	producer.ioThreadPoolWaitGroup.Add(1)
 	go producer.threadPool.start(producer.ioWorkerWaitGroup, producer.ioThreadPoolWaitGroup)
 	if !producer.producerConfig.DisableRuntimeMetrics {
@@ -327,11 +436,18 @@ func (producer *Producer) SafeClose() {
 }
 
 func (producer *Producer) sendCloseProdcerSignal() {
+	producer.stopAcceptingLogs()
+	producer.mover.ioWorker.retryQueueShutDownFlag.Store(true)
+}
+
+// stopAcceptingLogs stops accepting new logs and lets the mover send the cached batches,
+// the failed batches are still retried until the retries are disabled
+func (producer *Producer) stopAcceptingLogs() {
 	level.Info(producer.logger).Log("msg", "producer start closing")
 	producer.closeStstokenChannel()
 	producer.mover.moverShutDownFlag.Store(true)
 	producer.logAccumulator.shutDownFlag.Store(true)
-	producer.mover.ioWorker.retryQueueShutDownFlag.Store(true)
+	producer.mover.wakeUp()
 }
 
 func (producer *Producer) closeStstokenChannel() {
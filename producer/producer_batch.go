@@ -5,6 +5,7 @@ import (
 	"time"
 
 	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/internal"
 	"github.com/gogo/protobuf/proto"
 )
 
@@ -21,37 +22,76 @@ type ProducerBatch struct {
 	shardHash            *string
 	maxReservedAttempts  int
 	useMetricStoreUrl    bool
+	lingerMs             int64
+	maxBatchSize         int64
+	maxBatchCount        int
+	priority             Priority
 
 	// read only after seal
 	totalDataSize int64
 	logGroup      *sls.LogGroup
 	callBackList  []CallBack
+	rawData       []byte // encoded LogGroup sent as is, logGroup has no logs if it is set
+	rawLogCount   int
+	pooledRawData bool // rawData is a pooled buffer the logs are encoded into as they are added
 
 	// transient fields, but rw by at most one thread
 	attemptCount int
 	nextRetryMs  int64
 	result       *Result
+	rateReserved bool // tokens of rate limiter are already taken for the next send
+	reroute      bool // the target shard was split or merged, the retries are sent to the shard owning the hash key now
+
+	// only accessed with the lock of the LogAccumulator held while the batch is open
+	accumulatorKey string
+	lingerIndex    int // index in the lingerQueue
+
+	// only set for the copies of a batch in fan-out mode
+	client      sls.ClientInterface
+	destination string
+	fanout      *fanoutBatch
 }
 
-func newProducerBatch(packIdGenerator *PackIdGenerator, project, logstore, logTopic, logSource, shardHash string, config *ProducerConfig) *ProducerBatch {
+func newProducerBatch(packIdGenerator *PackIdGenerator, project, logstore, logTopic, logSource, shardHash string,
+	logTags []*sls.LogTag, config *ProducerConfig) *ProducerBatch {
 	logGroup := &sls.LogGroup{
 		Topic:  proto.String(logTopic),
 		Source: proto.String(logSource),
-		Logs:   make([]*sls.Log, 0, config.MaxBatchCount+4),
 	}
 
+	if config.GeneratePackId || len(logTags) > 0 {
+		logGroup.LogTags = make([]*sls.LogTag, 0, len(config.LogTags)+len(logTags)+1)
+		logGroup.LogTags = append(logGroup.LogTags, config.LogTags...)
+		logGroup.LogTags = append(logGroup.LogTags, logTags...)
+	} else {
+		logGroup.LogTags = config.LogTags
+	}
 	if config.GeneratePackId {
-		logGroup.LogTags = append(make([]*sls.LogTag, 0, len(config.LogTags)+1), config.LogTags...)
 		logGroup.LogTags = append(logGroup.LogTags, &sls.LogTag{
 			Key:   &PACK_ID_KEY,
 			Value: proto.String(packIdGenerator.GeneratePackId(project, logstore)),
 		})
-	} else {
-		logGroup.LogTags = config.LogTags
 	}
 
+	producerBatch := newSealedProducerBatch(project, logstore, shardHash, config)
+	producerBatch.logGroup = logGroup
+	if !config.UseMetricStoreURL {
+		// if the tags can't be encoded, the LogGroup is sent instead and the error is returned by the client
+		if header, err := encodeLogGroupHeader(logGroup); err == nil {
+			producerBatch.rawData, producerBatch.pooledRawData = header, true
+		}
+	}
+	if !producerBatch.pooledRawData {
+		logGroup.Logs = make([]*sls.Log, 0, config.MaxBatchCount+4)
+	}
+	// the topic, source and tags are sent along with the logs
+	producerBatch.totalDataSize = int64(logGroup.Size())
+	return producerBatch
+}
+
+// newSealedProducerBatch creates a batch without data, the caller fills in a LogGroup or raw data.
+func newSealedProducerBatch(project, logstore, shardHash string, config *ProducerConfig) *ProducerBatch {
 	producerBatch := &ProducerBatch{
-		logGroup:             logGroup,
 		maxRetryIntervalInMs: config.MaxRetryBackoffMs,
 		callBackList:         []CallBack{},
 		createTimeMs:         time.Now().UnixMilli(),
@@ -62,6 +102,9 @@ func newProducerBatch(packIdGenerator *PackIdGenerator, project, logstore, logTo
 		result:               initResult(),
 		maxReservedAttempts:  config.MaxReservedAttempts,
 		useMetricStoreUrl:    config.UseMetricStoreURL,
+		lingerMs:             config.LingerMs,
+		maxBatchSize:         config.MaxBatchSize,
+		maxBatchCount:        config.MaxBatchCount,
 	}
 	if shardHash != "" {
 		producerBatch.shardHash = &shardHash
@@ -81,30 +124,72 @@ func (producerBatch *ProducerBatch) getShardHash() *string {
 	return producerBatch.shardHash
 }
 
+func (producerBatch *ProducerBatch) getLogCount() int {
+	if producerBatch.rawLogCount > 0 {
+		return producerBatch.rawLogCount
+	}
+	return len(producerBatch.logGroup.Logs)
+}
+
 func (producerBatch *ProducerBatch) isUseMetricStoreUrl() bool {
 	return producerBatch.useMetricStoreUrl
 }
 
-func (producerBatch *ProducerBatch) meetSendCondition(producerConfig *ProducerConfig) bool {
-	return producerBatch.totalDataSize >= producerConfig.MaxBatchSize || len(producerBatch.logGroup.Logs) >= producerConfig.MaxBatchCount
+func (producerBatch *ProducerBatch) meetSendCondition() bool {
+	return producerBatch.totalDataSize >= producerBatch.maxBatchSize || producerBatch.getLogCount() >= producerBatch.maxBatchCount
+}
+
+// lingerExpireMs is the time the batch is sent by the mover if it isn't full by then
+func (producerBatch *ProducerBatch) lingerExpireMs() int64 {
+	return producerBatch.createTimeMs + producerBatch.lingerMs
 }
 
-func (producerBatch *ProducerBatch) addLog(log *sls.Log, size int64, callback CallBack) {
-	producerBatch.logGroup.Logs = append(producerBatch.logGroup.Logs, log)
-	producerBatch.totalDataSize += size
+// addLog adds a log, encoded is the log encoded by appendEncodedLogs
+func (producerBatch *ProducerBatch) addLog(log *sls.Log, encoded []byte, callback CallBack) {
+	if producerBatch.pooledRawData {
+		producerBatch.appendEncoded(encoded, 1)
+	} else {
+		producerBatch.logGroup.Logs = append(producerBatch.logGroup.Logs, log)
+	}
+	producerBatch.totalDataSize += int64(len(encoded))
 	if callback != nil {
 		producerBatch.callBackList = append(producerBatch.callBackList, callback)
 	}
 }
 
-func (producerBatch *ProducerBatch) addLogList(logList []*sls.Log, size int64, callback CallBack) {
-	producerBatch.logGroup.Logs = append(producerBatch.logGroup.Logs, logList...)
-	producerBatch.totalDataSize += size
+func (producerBatch *ProducerBatch) addLogList(logList []*sls.Log, encoded []byte, callback CallBack) {
+	if producerBatch.pooledRawData {
+		producerBatch.appendEncoded(encoded, len(logList))
+	} else {
+		producerBatch.logGroup.Logs = append(producerBatch.logGroup.Logs, logList...)
+	}
+	producerBatch.totalDataSize += int64(len(encoded))
 	if callback != nil {
 		producerBatch.callBackList = append(producerBatch.callBackList, callback)
 	}
 }
 
+func (producerBatch *ProducerBatch) appendEncoded(encoded []byte, logCount int) {
+	producerBatch.rawData = append(internal.GrowBuffer(producerBatch.rawData, len(encoded)), encoded...)
+	producerBatch.rawLogCount += logCount
+}
+
+// isRawDataPooled reports whether rawData is a pooled buffer, which is reused after the batch is finished
+func (producerBatch *ProducerBatch) isRawDataPooled() bool {
+	if producerBatch.fanout != nil {
+		return producerBatch.fanout.parent.pooledRawData
+	}
+	return producerBatch.pooledRawData
+}
+
+// releaseRawData puts the pooled buffer back after the batch is finished
+func (producerBatch *ProducerBatch) releaseRawData() {
+	if producerBatch.pooledRawData {
+		internal.PutBuffer(producerBatch.rawData)
+		producerBatch.rawData, producerBatch.pooledRawData = nil, false
+	}
+}
+
 func (producerBatch *ProducerBatch) OnSuccess(begin time.Time) {
 	producerBatch.addAttempt(nil, begin)
 	if len(producerBatch.callBackList) > 0 {
@@ -0,0 +1,123 @@
+package producer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/internal"
+	"github.com/aliyun/aliyun-log-go-sdk/internal/testutil"
+	"github.com/aliyun/aliyun-log-go-sdk/internal/testutil/clienthelper"
+	"github.com/gogo/protobuf/proto"
+	"github.com/pierrec/lz4/v4"
+)
+
+// newBenchmarkLog returns a log of about 550 bytes, the same as the one in PERFORMANCE_TEST.md
+func newBenchmarkLog(i int) *sls.Log {
+	contents := make(map[string]string, 8)
+	for k := 1; k <= 8; k++ {
+		contents[fmt.Sprintf("content_key_%d", k)] = fmt.Sprintf("%dabcdefghijklmnopqrstuvwxyz!@#$%%^&*()_0123456789-%d", k, i)
+	}
+	return GenerateLog(1700000000, contents)
+}
+
+// newFakeServerClient returns a client sending to a fake server, which reads the request body and returns 200
+func newFakeServerClient() sls.ClientInterface {
+	transport := testutil.NewMockTransport()
+	transport.RegisterResponder("POST", `=~^http://[^/]+/logstores/`,
+		func(req *http.Request) (*http.Response, error) {
+			io.Copy(io.Discard, req.Body)
+			return &http.Response{StatusCode: 200, Body: http.NoBody, Header: make(http.Header), Request: req}, nil
+		})
+	return clienthelper.NewMockedClient(transport)
+}
+
+func BenchmarkProducerSendLog(b *testing.B) {
+	for _, tt := range []struct {
+		name         string
+		compressType int
+	}{
+		{"lz4", sls.Compress_LZ4},
+		{"zstd", sls.Compress_ZSTD},
+		{"none", sls.Compress_None},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			config := GetDefaultProducerConfig()
+			config.CompressType = tt.compressType
+			config.MaxBatchSize = 512 * 1024
+			producer := newMockProducer(newFakeServerClient(), config)
+			producer.Start()
+			logs := make([]*sls.Log, 1024)
+			for i := range logs {
+				logs[i] = newBenchmarkLog(i)
+			}
+			b.SetBytes(int64(GetLogEncodedSize(logs[0])))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if err := producer.SendLog("p", "l", "topic", "source", logs[i%len(logs)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+			producer.SafeClose()
+			if stats := producer.Stats(); stats.FailedBatches > 0 || stats.RetryCount > 0 {
+				b.Fatalf("send to the fake server failed: %+v", stats.Destinations)
+			}
+		})
+	}
+}
+
+// BenchmarkEncodeBatch compares building a batch as a LogGroup marshaled and compressed into new buffers,
+// with encoding the logs as they are added into a pooled buffer and compressing through a pooled scratch buffer.
+func BenchmarkEncodeBatch(b *testing.B) {
+	logs := make([]*sls.Log, 1024)
+	for i := range logs {
+		logs[i] = newBenchmarkLog(i)
+	}
+	header := &sls.LogGroup{Topic: proto.String("topic"), Source: proto.String("source")}
+
+	b.Run("marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logGroup := &sls.LogGroup{Topic: header.Topic, Source: header.Source}
+			for _, log := range logs {
+				logGroup.Logs = append(logGroup.Logs, log)
+			}
+			body, err := proto.Marshal(logGroup)
+			if err != nil {
+				b.Fatal(err)
+			}
+			out := make([]byte, lz4.CompressBlockBound(len(body)))
+			if _, err = lz4.CompressBlock(body, out, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			body, err := encodeLogGroupHeader(header)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, log := range logs {
+				if body, err = appendEncodedLogs(body, log); err != nil {
+					b.Fatal(err)
+				}
+			}
+			out := internal.GetBuffer(lz4.CompressBlockBound(len(body)))
+			n, err := lz4.CompressBlock(body, out[:cap(out)], nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			// the request body is a copy, it may be read by net/http after the request returns
+			_ = append([]byte(nil), out[:n]...)
+			internal.PutBuffer(out)
+			internal.PutBuffer(body)
+		}
+	})
+}
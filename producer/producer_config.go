@@ -76,6 +76,58 @@ type ProducerConfig struct {
 	AuthVersion      sls.AuthVersionType
 	CompressType     int    // only work for logstore now
 	Processor        string // ingest processor
+
+	// Optional, defaults to false.
+	// If true, the producer lists the shards of each logstore and routes shard hashes onto
+	// the writable shard owning them, so batches are grouped per actual shard and follow
+	// shard splits and merges. Buckets is ignored when it is enabled.
+	// The shards are listed in the background, the logs are sent without routing until then,
+	// or while ListShards keeps failing, eg. the RAM policy only allows PutLogs.
+	ShardAwareRouting bool
+	// Optional, defaults to 60000 (1 minute), only works when ShardAwareRouting is true.
+	// The interval in milliseconds to refresh the cached shard ranges of a logstore.
+	ShardRoutingRefreshIntervalMs int64
+	// Optional, defaults to false, only works when ShardAwareRouting is true.
+	// If true, logs sent without shardHash are spread across writable shards in turn.
+	SpreadUnkeyedLogs bool
+	// Optional, defaults to nil.
+	// Client side token bucket limits of bytes/logs per second for projects or logstores, shared by all io workers.
+	// The send rate is lowered automatically when the server returns write quota errors and then recovers gradually.
+	RateLimits []*RateLimit
+	// Optional, defaults to nil.
+	// Processors applied in order to every log before it is added to a batch, eg. to mask secrets,
+	// drop or rename fields, truncate values or sample logs. See NewMaskProcessor etc. for built-in processors.
+	// If all logs of a send call are dropped, its callback is called with a successful Result without attempts.
+	// SendLogGroup applies them to copies of the logs, SendRawLogGroup returns an error if they are set.
+	LogProcessors []LogProcessor
+	// Optional, defaults to false.
+	// If true, the ShutdownReport returned by Shutdown contains the undelivered LogGroups.
+	ReportUndeliveredBatches bool
+	// Optional, defaults to nil.
+	// Called by Shutdown for each LogGroup not delivered, eg. to persist them and send them again later.
+	SpillUndelivered func(batch *UndeliveredBatch)
+	// Optional, defaults to nil.
+	// If set, every log is delivered to all the destinations instead of Endpoint, eg. to dual-write during a migration.
+	// Each destination retries independently, while the cached logs and TotalSizeLnBytes are shared.
+	// ShardAwareRouting is not supported in fan-out mode, and Endpoint and the credentials are not used.
+	// NewProducer returns an error if the Name of a destination is empty or duplicate.
+	FanoutDestinations []*FanoutDestination
+	// Optional, defaults to FanoutSuccessWhenAll.
+	// Decides whether the callback reports success when some of the FanoutDestinations fail.
+	FanoutPolicy int
+	// Optional, defaults to nil.
+	// If set, LingerMs / MaxBatchSize / MaxBatchCount are only the initial values, and are tuned per logstore
+	// within the configured bounds from the observed throughput, send latency and retry rate.
+	// The current values are returned by Producer.BatchingParams.
+	AdaptiveBatching *AdaptiveBatchingConfig
+	// Optional, defaults to nil, only works when GeneratePackId is true.
+	// Persists the pack id prefix and sequence of each logstore, so that pack ids keep increasing with the same
+	// prefix after the producer restarts. See NewFilePackIdStore for a file based implementation.
+	PackIdStore PackIdStore
+	// Optional, defaults to 0, must be less than 1.
+	// The ratio of TotalSizeLnBytes and MaxIoWorkerCount reserved for logs sent with PriorityHigh,
+	// logs of PriorityNormal block or are rejected once the rest is used up.
+	HighPriorityReservedRatio float64
 }
 
 func GetDefaultProducerConfig() *ProducerConfig {
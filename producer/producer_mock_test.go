@@ -0,0 +1,85 @@
+package producer
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+	"github.com/gogo/protobuf/proto"
+)
+
+// mockSendClient records the log groups sent by the producer instead of sending them to a server.
+type mockSendClient struct {
+	sls.ClientInterface
+	mutex    sync.Mutex
+	requests []*mockSendRequest
+	// returns the error of the n-th (from 0) request to a logstore, nil means success
+	errorFunc func(project, logstore string, n int) error
+	latency   time.Duration
+}
+
+type mockSendRequest struct {
+	project  string
+	logstore string
+	req      *sls.PostLogStoreLogsRequest // LogGroup is decoded from the raw data if it is sent by PostRawLogWithCompressType
+	rawData  []byte                       // a copy of the data sent by PostRawLogWithCompressType
+	hashKey  *string
+}
+
+// PostLogStoreLogsV2 gets the LogGroup, as the RawLogGroup is only sent to the clients of the SDK
+func (c *mockSendClient) PostLogStoreLogsV2(project, logstore string, req *sls.PostLogStoreLogsRequest) error {
+	if req.RawLogGroup != nil {
+		return errors.New("RawLogGroup is sent to a custom client")
+	}
+	return c.record(&mockSendRequest{project: project, logstore: logstore, req: req, hashKey: req.HashKey})
+}
+
+func (c *mockSendClient) PostRawLogWithCompressType(project, logstore string, rawLogData []byte, compressType int, hashKey *string) error {
+	request := &mockSendRequest{project: project, logstore: logstore, rawData: append([]byte{}, rawLogData...), hashKey: hashKey}
+	request.req = &sls.PostLogStoreLogsRequest{LogGroup: &sls.LogGroup{}, HashKey: hashKey, CompressType: compressType}
+	if err := proto.Unmarshal(request.rawData, request.req.LogGroup); err != nil {
+		return err
+	}
+	return c.record(request)
+}
+
+func (c *mockSendClient) record(request *mockSendRequest) error {
+	project, logstore := request.project, request.logstore
+	// requests are recorded in the order they are sent
+	c.mutex.Lock()
+	n := 0
+	for _, r := range c.requests {
+		if r.project == project && r.logstore == logstore {
+			n++
+		}
+	}
+	c.requests = append(c.requests, request)
+	c.mutex.Unlock()
+	time.Sleep(c.latency)
+	if c.errorFunc != nil {
+		return c.errorFunc(project, logstore, n)
+	}
+	return nil
+}
+
+func (c *mockSendClient) sentRequests() []*mockSendRequest {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]*mockSendRequest{}, c.requests...)
+}
+
+func newMockProducer(client sls.ClientInterface, config *ProducerConfig) *Producer {
+	if config == nil {
+		config = GetDefaultProducerConfig()
+	}
+	config.DisableRuntimeMetrics = true
+	logger := log.NewNopLogger()
+	return createProducerInternal(client, validateProducerConfig(config, logger), logger)
+}
+
+// logGroupHeaderSize returns the size of a batch without logs, whose topic and source are empty
+func logGroupHeaderSize() int64 {
+	return int64((&sls.LogGroup{Topic: proto.String(""), Source: proto.String("")}).Size())
+}
@@ -0,0 +1,202 @@
+package producer
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	// the rate is halved on every quota error, but never below 10% of the configured limit
+	rateLimitDecreaseFactor = 0.5
+	rateLimitMinRatio       = 0.1
+	// the rate grows back by 10% of the configured limit every second without quota errors
+	rateLimitRecoverRatio    = 0.1
+	rateLimitRecoverInterval = time.Second
+)
+
+// RateLimit limits the write throughput of a project, or of one logstore of it.
+// A zero BytesPerSec or LogsPerSec means no limit on that dimension.
+type RateLimit struct {
+	Project     string
+	Logstore    string // optional, empty means the limit is shared by all logstores of the project
+	BytesPerSec int64
+	LogsPerSec  int64
+}
+
+// RateLimiter paces the dispatch of batches with token buckets shared by all io workers.
+// The rate is lowered when the server returns quota errors and recovers gradually afterwards.
+type RateLimiter struct {
+	projectLimiters  map[string]*destinationLimiter
+	logstoreLimiters map[string]*destinationLimiter
+	logger           log.Logger
+}
+
+type destinationLimiter struct {
+	bytes *tokenBucket
+	logs  *tokenBucket
+}
+
+func initRateLimiter(rateLimits []*RateLimit, logger log.Logger) *RateLimiter {
+	limiter := &RateLimiter{
+		projectLimiters:  make(map[string]*destinationLimiter),
+		logstoreLimiters: make(map[string]*destinationLimiter),
+		logger:           logger,
+	}
+	for _, rateLimit := range rateLimits {
+		if rateLimit == nil || (rateLimit.BytesPerSec <= 0 && rateLimit.LogsPerSec <= 0) {
+			continue
+		}
+		d := &destinationLimiter{
+			bytes: newTokenBucket(rateLimit.BytesPerSec),
+			logs:  newTokenBucket(rateLimit.LogsPerSec),
+		}
+		if rateLimit.Logstore == "" {
+			limiter.projectLimiters[rateLimit.Project] = d
+		} else {
+			limiter.logstoreLimiters[rateLimit.Project+Delimiter+rateLimit.Logstore] = d
+		}
+	}
+	return limiter
+}
+
+// reserve takes the tokens needed by the batch and returns how long the batch must wait before being sent.
+// A batch of PriorityHigh only waits for its own tokens, not for the tokens of the normal batches deferred before it.
+func (limiter *RateLimiter) reserve(producerBatch *ProducerBatch) time.Duration {
+	now := time.Now()
+	bytes := float64(producerBatch.totalDataSize)
+	logs := float64(producerBatch.getLogCount())
+	var wait time.Duration
+	for _, d := range limiter.getLimiters(producerBatch.getProject(), producerBatch.getLogstore()) {
+		if w := d.bytes.reserve(bytes, now, producerBatch.priority); w > wait {
+			wait = w
+		}
+		if w := d.logs.reserve(logs, now, producerBatch.priority); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+func (limiter *RateLimiter) onQuotaExceeded(project, logstore string) {
+	now := time.Now()
+	for _, d := range limiter.getLimiters(project, logstore) {
+		d.bytes.decrease(now)
+		d.logs.decrease(now)
+	}
+	level.Warn(limiter.logger).Log("msg", "write quota exceeded, lower the send rate", "project", project, "logstore", logstore)
+}
+
+func (limiter *RateLimiter) onSuccess(project, logstore string) {
+	now := time.Now()
+	for _, d := range limiter.getLimiters(project, logstore) {
+		d.bytes.recover(now)
+		d.logs.recover(now)
+	}
+}
+
+func (limiter *RateLimiter) getLimiters(project, logstore string) []*destinationLimiter {
+	limiters := make([]*destinationLimiter, 0, 2)
+	if d, ok := limiter.projectLimiters[project]; ok {
+		limiters = append(limiters, d)
+	}
+	if d, ok := limiter.logstoreLimiters[project+Delimiter+logstore]; ok {
+		limiters = append(limiters, d)
+	}
+	return limiters
+}
+
+func isQuotaExceededError(err *sls.Error) bool {
+	return err.Code == sls.WRITE_QUOTA_EXCEED || err.Code == sls.SHARD_WRITE_QUOTA_EXCEED || err.Code == sls.PROJECT_QUOTA_EXCEED
+}
+
+// tokenBucket is a token bucket allowing a burst of one second, a nil bucket never limits.
+type tokenBucket struct {
+	mutex      sync.Mutex
+	limit      float64 // configured tokens per second
+	rate       float64 // current tokens per second
+	tokens     float64
+	last       time.Time
+	lastAdjust time.Time
+}
+
+func newTokenBucket(limit int64) *tokenBucket {
+	if limit <= 0 {
+		return nil
+	}
+	now := time.Now()
+	return &tokenBucket{
+		limit:      float64(limit),
+		rate:       float64(limit),
+		tokens:     float64(limit),
+		last:       now,
+		lastAdjust: now,
+	}
+}
+
+func (b *tokenBucket) reserve(n float64, now time.Time, priority Priority) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.advance(now)
+	missing := n - b.tokens
+	if priority == PriorityHigh {
+		// the tokens owed by the batches waiting are still taken, so that the normal batches deferred later wait longer
+		missing = n - math.Max(b.tokens, 0)
+	}
+	b.tokens -= n
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) decrease(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.advance(now)
+	b.rate = math.Max(b.rate*rateLimitDecreaseFactor, b.limit*rateLimitMinRatio)
+	b.tokens = math.Min(b.tokens, 0)
+	b.lastAdjust = now
+}
+
+func (b *tokenBucket) recover(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.rate >= b.limit || now.Sub(b.lastAdjust) < rateLimitRecoverInterval {
+		return
+	}
+	b.advance(now)
+	b.rate = math.Min(b.rate+b.limit*rateLimitRecoverRatio, b.limit)
+	b.lastAdjust = now
+}
+
+func (b *tokenBucket) advance(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	b.tokens = math.Min(b.tokens+elapsed*b.rate, b.rate)
+	b.last = now
+}
+
+func (b *tokenBucket) currentRate() float64 {
+	if b == nil {
+		return 0
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.rate
+}
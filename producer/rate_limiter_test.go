@@ -0,0 +1,84 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	bucket := newTokenBucket(100)
+	now := time.Now()
+	assert.Equal(t, time.Duration(0), bucket.reserve(100, now, PriorityNormal))
+	assert.Equal(t, 500*time.Millisecond, bucket.reserve(50, now, PriorityNormal))
+	// tokens are refilled over time
+	assert.Equal(t, time.Duration(0), bucket.reserve(50, now.Add(time.Second), PriorityNormal))
+	assert.Nil(t, newTokenBucket(0))
+	assert.Equal(t, time.Duration(0), newTokenBucket(0).reserve(100, now, PriorityNormal))
+}
+
+func TestTokenBucketReserveHighPriority(t *testing.T) {
+	bucket := newTokenBucket(100)
+	now := time.Now()
+	assert.Equal(t, time.Duration(0), bucket.reserve(100, now, PriorityNormal))
+	assert.Equal(t, 500*time.Millisecond, bucket.reserve(50, now, PriorityNormal))
+	// doesn't wait for the normal batch deferred before it
+	assert.Equal(t, 200*time.Millisecond, bucket.reserve(20, now, PriorityHigh))
+	// but its tokens are taken
+	assert.Equal(t, 800*time.Millisecond, bucket.reserve(10, now, PriorityNormal))
+}
+
+func TestTokenBucketAdapt(t *testing.T) {
+	bucket := newTokenBucket(1000)
+	now := time.Now()
+	bucket.decrease(now)
+	assert.Equal(t, 500.0, bucket.currentRate())
+	for i := 0; i < 10; i++ {
+		bucket.decrease(now)
+	}
+	assert.Equal(t, 100.0, bucket.currentRate())
+
+	// no recovery within one second after the last quota error
+	bucket.recover(now.Add(time.Millisecond * 500))
+	assert.Equal(t, 100.0, bucket.currentRate())
+	bucket.recover(now.Add(time.Second))
+	assert.Equal(t, 200.0, bucket.currentRate())
+	for i := 2; i < 20; i++ {
+		bucket.recover(now.Add(time.Second * time.Duration(i)))
+	}
+	assert.Equal(t, 1000.0, bucket.currentRate())
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	limiter := initRateLimiter([]*RateLimit{
+		{Project: "p", BytesPerSec: 1000},
+		{Project: "p", Logstore: "l", LogsPerSec: 10},
+		{Project: "ignored"},
+	}, log.NewNopLogger())
+	assert.Len(t, limiter.projectLimiters, 1)
+	assert.Len(t, limiter.logstoreLimiters, 1)
+
+	batch := &ProducerBatch{
+		project:       "p",
+		logstore:      "l",
+		totalDataSize: 500,
+		logGroup:      &sls.LogGroup{Logs: make([]*sls.Log, 20)},
+	}
+	// bytes are within the project limit, but logs exceed the logstore limit
+	wait := limiter.reserve(batch)
+	assert.True(t, wait > 900*time.Millisecond && wait <= time.Second, wait)
+
+	other := &ProducerBatch{
+		project:       "p",
+		logstore:      "other",
+		totalDataSize: 400,
+		logGroup:      &sls.LogGroup{Logs: make([]*sls.Log, 20)},
+	}
+	assert.Equal(t, time.Duration(0), limiter.reserve(other))
+
+	assert.True(t, isQuotaExceededError(&sls.Error{Code: sls.WRITE_QUOTA_EXCEED}))
+	assert.False(t, isQuotaExceededError(&sls.Error{Code: sls.SERVER_BUSY}))
+}
@@ -23,8 +23,9 @@ func createAttempt(success bool,
 }
 
 type Result struct {
-	attemptList []*Attempt
-	successful  bool
+	attemptList        []*Attempt
+	successful         bool
+	destinationResults map[string]*Result
 }
 
 func (result *Result) IsSuccessful() bool {
@@ -35,6 +36,12 @@ func (result *Result) GetReservedAttempts() []*Attempt {
 	return result.attemptList
 }
 
+// GetDestinationResults returns the result of each destination by FanoutDestination.Name in fan-out mode, or nil otherwise.
+// The attempts of the combined result are the attempts of all destinations.
+func (result *Result) GetDestinationResults() map[string]*Result {
+	return result.destinationResults
+}
+
 func (result *Result) GetErrorCode() string {
 	if len(result.attemptList) == 0 {
 		return ""
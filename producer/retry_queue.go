@@ -11,8 +11,9 @@ import (
 
 // RetryQueue cache ProducerBatch and retry latter
 type RetryQueue struct {
-	batch []*ProducerBatch
-	mutex sync.Mutex
+	batch        []*ProducerBatch
+	highPriority retryHeap // batches of PriorityHigh, they are taken before the normal ones
+	mutex        sync.Mutex
 }
 
 func initRetryQueue() *RetryQueue {
@@ -25,33 +26,50 @@ func (retryQueue *RetryQueue) sendToRetryQueue(producerBatch *ProducerBatch, log
 	level.Debug(logger).Log("msg", "Send to retry queue")
 	retryQueue.mutex.Lock()
 	defer retryQueue.mutex.Unlock()
-	if producerBatch != nil {
-		heap.Push(retryQueue, producerBatch)
+	if producerBatch == nil {
+		return
 	}
+	if producerBatch.priority == PriorityHigh {
+		heap.Push(&retryQueue.highPriority, producerBatch)
+		return
+	}
+	heap.Push(retryQueue, producerBatch)
 }
 
 func (retryQueue *RetryQueue) getRetryBatch(moverShutDownFlag bool) (producerBatchList []*ProducerBatch) {
 	retryQueue.mutex.Lock()
 	defer retryQueue.mutex.Unlock()
-	if !moverShutDownFlag {
-		for retryQueue.Len() > 0 {
-			producerBatch := heap.Pop(retryQueue)
+	producerBatchList = popRetryBatches(&retryQueue.highPriority, moverShutDownFlag, producerBatchList)
+	return popRetryBatches(retryQueue, moverShutDownFlag, producerBatchList)
+}
+
+// popRetryBatches appends the batches whose retry time has come to producerBatchList, or all batches if all is true
+func popRetryBatches(h heap.Interface, all bool, producerBatchList []*ProducerBatch) []*ProducerBatch {
+	if !all {
+		for h.Len() > 0 {
+			producerBatch := heap.Pop(h)
 			if producerBatch.(*ProducerBatch).nextRetryMs < time.Now().UnixMilli() {
 				producerBatchList = append(producerBatchList, producerBatch.(*ProducerBatch))
 			} else {
-				heap.Push(retryQueue, producerBatch.(*ProducerBatch))
+				heap.Push(h, producerBatch.(*ProducerBatch))
 				break
 			}
 		}
 	} else {
-		for retryQueue.Len() > 0 {
-			producerBatch := heap.Pop(retryQueue)
+		for h.Len() > 0 {
+			producerBatch := heap.Pop(h)
 			producerBatchList = append(producerBatchList, producerBatch.(*ProducerBatch))
 		}
 	}
 	return producerBatchList
 }
 
+func (retryQueue *RetryQueue) size() int {
+	retryQueue.mutex.Lock()
+	defer retryQueue.mutex.Unlock()
+	return retryQueue.Len() + retryQueue.highPriority.Len()
+}
+
 func (retryQueue *RetryQueue) Len() int {
 	return len(retryQueue.batch)
 }
@@ -74,3 +92,31 @@ func (retryQueue *RetryQueue) Pop() interface{} {
 	retryQueue.batch = old[0 : n-1]
 	return item
 }
+
+// retryHeap orders the batches by the time to retry
+type retryHeap []*ProducerBatch
+
+func (h retryHeap) Len() int {
+	return len(h)
+}
+
+func (h retryHeap) Less(i, j int) bool {
+	return h[i].nextRetryMs < h[j].nextRetryMs
+}
+
+func (h retryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *retryHeap) Push(x interface{}) {
+	*h = append(*h, x.(*ProducerBatch))
+}
+
+func (h *retryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
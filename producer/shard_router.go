@@ -0,0 +1,220 @@
+package producer
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	uberatomic "go.uber.org/atomic"
+)
+
+const shardStatusReadWrite = "readwrite"
+
+// the backoff of listing the shards after failures, eg. the RAM policy only allows PutLogs
+const (
+	shardRoutingMinRetryBackoff = time.Second
+	shardRoutingMaxRetryBackoff = 5 * time.Minute
+)
+
+// ShardRouter caches the shard key ranges of each logstore and maps a shard hash
+// onto the writable shard that owns it, so that batches are grouped per real shard.
+// The cache is refreshed periodically and whenever a send reports that the target
+// shard no longer exists or has become read-only (after SplitShard / MergeShards).
+// The shards are listed in the background, the logs are sent without routing until they are listed,
+// and failures are retried with backoff, so sending never waits for ListShards.
+type ShardRouter struct {
+	client          sls.ClientInterface
+	logger          log.Logger
+	refreshInterval time.Duration
+	hashShardKey    bool
+	spreadUnkeyed   bool
+
+	mutex     sync.RWMutex
+	logstores map[string]*logstoreShards
+}
+
+type logstoreShards struct {
+	mutex      sync.RWMutex
+	shards     []*sls.Shard // writable shards, sorted by InclusiveBeginKey
+	updateTime time.Time    // zero if the shards are never listed
+	failures   int          // the successive failures of listing the shards
+	retryTime  time.Time    // no refresh is tried before it after failures
+	refreshing *uberatomic.Bool
+	next       *uberatomic.Uint32
+}
+
+func initShardRouter(client sls.ClientInterface, config *ProducerConfig, logger log.Logger) *ShardRouter {
+	return &ShardRouter{
+		client:          client,
+		logger:          logger,
+		refreshInterval: time.Duration(config.ShardRoutingRefreshIntervalMs) * time.Millisecond,
+		hashShardKey:    config.AdjustShargHash,
+		spreadUnkeyed:   config.SpreadUnkeyedLogs,
+		logstores:       make(map[string]*logstoreShards),
+	}
+}
+
+// route returns the hash key that should be used to send logs with shardHash,
+// which is the InclusiveBeginKey of the shard owning it.
+// If shardHash is empty and SpreadUnkeyedLogs is enabled, writable shards are used in turn.
+// The second return value is false if the shard ranges are not available.
+func (router *ShardRouter) route(project, logstore, shardHash string) (string, bool) {
+	if shardHash == "" && !router.spreadUnkeyed {
+		return "", true
+	}
+	entry := router.getLogstoreShards(project, logstore)
+	if shardHash == "" {
+		return entry.nextWritableShard()
+	}
+	hashKey := shardHash
+	if router.hashShardKey {
+		hashKey = ToMd5(shardHash)
+	}
+	return entry.locate(strings.ToLower(hashKey))
+}
+
+// invalidate lists the shards of the logstore again in the background, it is called by the io workers
+// when a send fails because the target shard no longer exists or is read-only.
+// The refreshes of a logstore are coalesced, and they are skipped until the backoff passes after failures.
+func (router *ShardRouter) invalidate(project, logstore string) {
+	entry := router.getOrCreate(project, logstore)
+	if entry.canRetry() && entry.refreshing.CompareAndSwap(false, true) {
+		go func() {
+			defer entry.refreshing.Store(false)
+			router.refresh(entry, project, logstore)
+		}()
+	}
+}
+
+// reroute returns the hash key of the writable shard that owns hashKey in the cached shards,
+// it doesn't list the shards, so the io workers never wait for ListShards.
+func (router *ShardRouter) reroute(project, logstore, hashKey string) (string, bool) {
+	return router.getOrCreate(project, logstore).locate(strings.ToLower(hashKey))
+}
+
+// getLogstoreShards returns the cached shards of the logstore, and refreshes them in the background if they expire.
+// The entry has no shards until the first refresh succeeds.
+func (router *ShardRouter) getLogstoreShards(project, logstore string) *logstoreShards {
+	entry := router.getOrCreate(project, logstore)
+	if entry.expired(router.refreshInterval) && entry.canRetry() && entry.refreshing.CompareAndSwap(false, true) {
+		go func() {
+			defer entry.refreshing.Store(false)
+			router.refresh(entry, project, logstore)
+		}()
+	}
+	return entry
+}
+
+func (router *ShardRouter) getOrCreate(project, logstore string) *logstoreShards {
+	key := project + Delimiter + logstore
+	router.mutex.RLock()
+	entry, ok := router.logstores[key]
+	router.mutex.RUnlock()
+	if ok {
+		return entry
+	}
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	if entry, ok = router.logstores[key]; !ok {
+		entry = &logstoreShards{
+			refreshing: uberatomic.NewBool(false),
+			next:       uberatomic.NewUint32(0),
+		}
+		router.logstores[key] = entry
+	}
+	return entry
+}
+
+func (router *ShardRouter) refresh(entry *logstoreShards, project, logstore string) {
+	shards, err := router.client.ListShards(project, logstore)
+	if err != nil {
+		backoff := entry.fail()
+		level.Warn(router.logger).Log("msg", "failed to list shards, keep using the cached ones or send without routing",
+			"project", project, "logstore", logstore, "error", err, "retryAfter", backoff)
+		return
+	}
+	writable := make([]*sls.Shard, 0, len(shards))
+	for _, shard := range shards {
+		if strings.EqualFold(shard.Status, shardStatusReadWrite) {
+			writable = append(writable, shard)
+		}
+	}
+	sort.Slice(writable, func(i, j int) bool {
+		return strings.ToLower(writable[i].InclusiveBeginKey) < strings.ToLower(writable[j].InclusiveBeginKey)
+	})
+	level.Debug(router.logger).Log("msg", "shards refreshed", "project", project, "logstore", logstore, "writableShards", len(writable))
+
+	entry.mutex.Lock()
+	entry.shards = writable
+	entry.updateTime = time.Now()
+	entry.failures = 0
+	entry.retryTime = time.Time{}
+	entry.mutex.Unlock()
+}
+
+// fail records a failure of listing the shards, and returns the backoff before the next try
+func (entry *logstoreShards) fail() time.Duration {
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	backoff := shardRoutingMinRetryBackoff << entry.failures
+	if backoff > shardRoutingMaxRetryBackoff || backoff <= 0 {
+		backoff = shardRoutingMaxRetryBackoff
+	} else {
+		entry.failures++
+	}
+	entry.retryTime = time.Now().Add(backoff)
+	return backoff
+}
+
+// canRetry returns false if the shards failed to be listed and the backoff doesn't pass
+func (entry *logstoreShards) canRetry() bool {
+	entry.mutex.RLock()
+	defer entry.mutex.RUnlock()
+	return !time.Now().Before(entry.retryTime)
+}
+
+func (entry *logstoreShards) expired(refreshInterval time.Duration) bool {
+	entry.mutex.RLock()
+	defer entry.mutex.RUnlock()
+	return time.Since(entry.updateTime) > refreshInterval
+}
+
+// locate finds the writable shard whose range [InclusiveBeginKey, ExclusiveBeginKey) contains hashKey.
+func (entry *logstoreShards) locate(hashKey string) (string, bool) {
+	entry.mutex.RLock()
+	defer entry.mutex.RUnlock()
+	if len(entry.shards) == 0 {
+		return "", false
+	}
+	// index of the first shard beginning after hashKey
+	i := sort.Search(len(entry.shards), func(i int) bool {
+		return strings.ToLower(entry.shards[i].InclusiveBeginKey) > hashKey
+	})
+	if i == 0 {
+		return "", false
+	}
+	shard := entry.shards[i-1]
+	// the last shard ends with "ffffffffffffffffffffffffffffffff", treat it as inclusive
+	if i < len(entry.shards) && hashKey >= strings.ToLower(shard.ExclusiveBeginKey) {
+		return "", false
+	}
+	return strings.ToLower(shard.InclusiveBeginKey), true
+}
+
+func (entry *logstoreShards) nextWritableShard() (string, bool) {
+	entry.mutex.RLock()
+	defer entry.mutex.RUnlock()
+	if len(entry.shards) == 0 {
+		return "", false
+	}
+	i := int(entry.next.Inc()-1) % len(entry.shards)
+	return strings.ToLower(entry.shards[i].InclusiveBeginKey), true
+}
+
+func isShardRoutingError(err *sls.Error) bool {
+	return err.Code == sls.SHARD_NOT_EXIST || err.Code == sls.SHARD_READ_ONLY
+}
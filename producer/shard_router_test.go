@@ -0,0 +1,219 @@
+package producer
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockShardClient struct {
+	sls.ClientInterface
+	mutex         sync.Mutex
+	shards        []*sls.Shard
+	err           error
+	listCallCount int
+	listing       chan struct{} // ListShards waits until it is closed if it is not nil
+}
+
+func (c *mockShardClient) ListShards(project, logstore string) ([]*sls.Shard, error) {
+	if c.listing != nil {
+		<-c.listing
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.listCallCount++
+	return c.shards, c.err
+}
+
+func (c *mockShardClient) setShards(shards []*sls.Shard) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.shards = shards
+}
+
+func (c *mockShardClient) getListCallCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.listCallCount
+}
+
+// waitShardsListed waits until the shards of the logstore are listed in the background
+func waitShardsListed(t *testing.T, router *ShardRouter, project, logstore string) {
+	entry := router.getLogstoreShards(project, logstore)
+	assert.Eventually(t, func() bool { return !entry.expired(time.Hour) }, time.Second, time.Millisecond)
+}
+
+func newTestShardRouter(client sls.ClientInterface, spreadUnkeyed bool) *ShardRouter {
+	config := GetDefaultProducerConfig()
+	config.ShardAwareRouting = true
+	config.SpreadUnkeyedLogs = spreadUnkeyed
+	config.AdjustShargHash = false
+	validateProducerConfig(config, log.NewLogfmtLogger(os.Stderr))
+	return initShardRouter(client, config, log.NewNopLogger())
+}
+
+func TestShardRouterRoute(t *testing.T) {
+	client := &mockShardClient{shards: []*sls.Shard{
+		{ShardID: 1, Status: "readwrite", InclusiveBeginKey: "80000000000000000000000000000000", ExclusiveBeginKey: "ffffffffffffffffffffffffffffffff"},
+		{ShardID: 0, Status: "readwrite", InclusiveBeginKey: "00000000000000000000000000000000", ExclusiveBeginKey: "80000000000000000000000000000000"},
+	}}
+	router := newTestShardRouter(client, false)
+
+	// the logs are sent without routing until the shards are listed
+	_, ok := router.route("p", "l", "12000000000000000000000000000000")
+	assert.False(t, ok)
+	waitShardsListed(t, router, "p", "l")
+
+	routed, ok := router.route("p", "l", "12000000000000000000000000000000")
+	assert.True(t, ok)
+	assert.Equal(t, "00000000000000000000000000000000", routed)
+
+	routed, ok = router.route("p", "l", "A0000000000000000000000000000000")
+	assert.True(t, ok)
+	assert.Equal(t, "80000000000000000000000000000000", routed)
+
+	routed, ok = router.route("p", "l", "ffffffffffffffffffffffffffffffff")
+	assert.True(t, ok)
+	assert.Equal(t, "80000000000000000000000000000000", routed)
+
+	routed, ok = router.route("p", "l", "")
+	assert.True(t, ok)
+	assert.Equal(t, "", routed)
+	assert.Equal(t, 1, client.getListCallCount())
+}
+
+func TestShardRouterFollowSplit(t *testing.T) {
+	client := &mockShardClient{shards: []*sls.Shard{
+		{ShardID: 0, Status: "readwrite", InclusiveBeginKey: "00000000000000000000000000000000", ExclusiveBeginKey: "ffffffffffffffffffffffffffffffff"},
+	}}
+	router := newTestShardRouter(client, true)
+	waitShardsListed(t, router, "p", "l")
+
+	routed, ok := router.route("p", "l", "c0000000000000000000000000000000")
+	assert.True(t, ok)
+	assert.Equal(t, "00000000000000000000000000000000", routed)
+
+	// shard 0 is split into shard 1 and 2
+	client.setShards([]*sls.Shard{
+		{ShardID: 0, Status: "readonly", InclusiveBeginKey: "00000000000000000000000000000000", ExclusiveBeginKey: "ffffffffffffffffffffffffffffffff"},
+		{ShardID: 1, Status: "readwrite", InclusiveBeginKey: "00000000000000000000000000000000", ExclusiveBeginKey: "80000000000000000000000000000000"},
+		{ShardID: 2, Status: "readwrite", InclusiveBeginKey: "80000000000000000000000000000000", ExclusiveBeginKey: "ffffffffffffffffffffffffffffffff"},
+	})
+	// the shards are listed again in the background once for all the failed sends, the cached ones are used until then
+	client.listing = make(chan struct{})
+	for i := 0; i < 10; i++ {
+		router.invalidate("p", "l")
+		routed, ok = router.reroute("p", "l", "c0000000000000000000000000000000")
+		assert.True(t, ok)
+		assert.Equal(t, "00000000000000000000000000000000", routed)
+	}
+	close(client.listing)
+	assert.Eventually(t, func() bool {
+		routed, ok = router.reroute("p", "l", "c0000000000000000000000000000000")
+		return ok && routed == "80000000000000000000000000000000"
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 2, client.getListCallCount())
+
+	spread := map[string]int{}
+	for i := 0; i < 4; i++ {
+		routed, ok = router.route("p", "l", "")
+		assert.True(t, ok)
+		spread[routed]++
+	}
+	assert.Equal(t, map[string]int{
+		"00000000000000000000000000000000": 2,
+		"80000000000000000000000000000000": 2,
+	}, spread)
+}
+
+func TestShardRouterListFailed(t *testing.T) {
+	client := &mockShardClient{err: &sls.Error{Code: sls.UN_AUTHORIZED}}
+	router := newTestShardRouter(client, false)
+
+	// the failure is cached, the next sends neither wait for nor trigger ListShards until the backoff passes
+	for i := 0; i < 10; i++ {
+		_, ok := router.route("p", "l", "12000000000000000000000000000000")
+		assert.False(t, ok)
+	}
+	entry := router.getLogstoreShards("p", "l")
+	assert.Eventually(t, func() bool { return !entry.canRetry() }, time.Second, time.Millisecond)
+	router.invalidate("p", "l")
+	_, ok := router.reroute("p", "l", "12000000000000000000000000000000")
+	assert.False(t, ok)
+	assert.Equal(t, 1, client.getListCallCount())
+	assert.Equal(t, 2*shardRoutingMinRetryBackoff, entry.fail())
+}
+
+func TestIsShardRoutingError(t *testing.T) {
+	assert.True(t, isShardRoutingError(&sls.Error{Code: sls.SHARD_NOT_EXIST}))
+	assert.True(t, isShardRoutingError(&sls.Error{Code: sls.SHARD_READ_ONLY}))
+	assert.False(t, isShardRoutingError(&sls.Error{Code: sls.POST_BODY_INVALID, Message: "shard is readonly"}))
+	assert.False(t, isShardRoutingError(&sls.Error{Code: sls.WRITE_QUOTA_EXCEED}))
+}
+
+// shardRoutingTestClient lists the shards by mockShardClient and records the sends by mockSendClient
+type shardRoutingTestClient struct {
+	*mockSendClient
+	shardClient *mockShardClient
+}
+
+func (c *shardRoutingTestClient) ListShards(project, logstore string) ([]*sls.Shard, error) {
+	return c.shardClient.ListShards(project, logstore)
+}
+
+// PostLogStoreLogsV2 fails unless the hash key is the begin key of a writable shard
+func (c *shardRoutingTestClient) PostLogStoreLogsV2(project, logstore string, req *sls.PostLogStoreLogsRequest) error {
+	if err := c.mockSendClient.PostLogStoreLogsV2(project, logstore, req); err != nil {
+		return err
+	}
+	shards, _ := c.shardClient.ListShards(project, logstore)
+	for _, shard := range shards {
+		if shard.Status == "readwrite" && shard.InclusiveBeginKey == *req.HashKey {
+			return nil
+		}
+	}
+	return &sls.Error{HTTPCode: 400, Code: sls.SHARD_READ_ONLY}
+}
+
+func TestProducerRerouteAfterMerge(t *testing.T) {
+	shardClient := &mockShardClient{shards: []*sls.Shard{
+		{ShardID: 0, Status: "readwrite", InclusiveBeginKey: "00000000000000000000000000000000", ExclusiveBeginKey: "80000000000000000000000000000000"},
+		{ShardID: 1, Status: "readwrite", InclusiveBeginKey: "80000000000000000000000000000000", ExclusiveBeginKey: "ffffffffffffffffffffffffffffffff"},
+	}}
+	sendClient := &mockSendClient{errorFunc: func(project, logstore string, n int) error {
+		if n == 0 {
+			// shard 0 and 1 are merged into shard 2 before the first send
+			shardClient.setShards([]*sls.Shard{
+				{ShardID: 0, Status: "readonly", InclusiveBeginKey: "00000000000000000000000000000000", ExclusiveBeginKey: "80000000000000000000000000000000"},
+				{ShardID: 1, Status: "readonly", InclusiveBeginKey: "80000000000000000000000000000000", ExclusiveBeginKey: "ffffffffffffffffffffffffffffffff"},
+				{ShardID: 2, Status: "readwrite", InclusiveBeginKey: "00000000000000000000000000000000", ExclusiveBeginKey: "ffffffffffffffffffffffffffffffff"},
+			})
+		}
+		return nil
+	}}
+	config := GetDefaultProducerConfig()
+	config.ShardAwareRouting = true
+	config.AdjustShargHash = false
+	config.BaseRetryBackoffMs = 10
+	config.LingerMs = 100
+	producer := newMockProducer(&shardRoutingTestClient{mockSendClient: sendClient, shardClient: shardClient}, config)
+	producer.Start()
+	waitShardsListed(t, producer.shardRouter, "p", "l")
+
+	callback := &countCallback{}
+	assert.NoError(t, producer.HashSendLogWithCallBack("p", "l", "c0000000000000000000000000000000", "", "", GenerateLog(1, map[string]string{"k": "v"}), callback))
+	// the retries are sent to the merged shard once it is listed in the background
+	assert.Eventually(t, func() bool {
+		requests := sendClient.sentRequests()
+		return len(requests) > 1 && *requests[len(requests)-1].hashKey == "00000000000000000000000000000000"
+	}, time.Second, time.Millisecond)
+	producer.SafeClose()
+
+	assert.Equal(t, "80000000000000000000000000000000", *sendClient.sentRequests()[0].hashKey)
+	assert.Equal(t, 1, callback.success)
+}
@@ -0,0 +1,206 @@
+package producer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gogo/protobuf/proto"
+)
+
+// UndeliveredBatch is a LogGroup the producer failed to deliver before Shutdown returns.
+type UndeliveredBatch struct {
+	Project   string
+	Logstore  string
+	ShardHash *string
+	LogGroup  *sls.LogGroup
+	RawData   []byte     // set instead of LogGroup if the batch is sent by SendRawLogGroup
+	LastError *sls.Error // nil if the batch has never been sent
+}
+
+// UndeliveredCount counts the undelivered data of one logstore.
+type UndeliveredCount struct {
+	Project  string
+	Logstore string
+	Batches  int
+	Logs     int
+	Bytes    int64
+}
+
+// ShutdownReport describes the data not delivered when Producer.Shutdown returns.
+type ShutdownReport struct {
+	// true if all data has been sent successfully or failed finally before the context is done
+	Completed bool
+	// batches still being sent when the context is done, their results are unknown
+	InFlightBatches int64
+	// key is project + "|" + logstore
+	Undelivered map[string]*UndeliveredCount
+	// only set if ProducerConfig.ReportUndeliveredBatches is true
+	UndeliveredBatches []*UndeliveredBatch
+}
+
+type undeliveredCollector struct {
+	mutex        sync.Mutex
+	closed       bool // the report is returned, ignore batches failed later
+	keepBatches  bool
+	report       *ShutdownReport
+	spillBatches []*UndeliveredBatch
+}
+
+func newUndeliveredCollector(config *ProducerConfig) *undeliveredCollector {
+	return &undeliveredCollector{
+		keepBatches: config.ReportUndeliveredBatches || config.SpillUndelivered != nil,
+		report: &ShutdownReport{
+			Undelivered: make(map[string]*UndeliveredCount),
+		},
+	}
+}
+
+func (c *undeliveredCollector) add(producerBatch *ProducerBatch, err *sls.Error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return
+	}
+	key := producerBatch.getProject() + Delimiter + producerBatch.getLogstore()
+	count, ok := c.report.Undelivered[key]
+	if !ok {
+		count = &UndeliveredCount{
+			Project:  producerBatch.getProject(),
+			Logstore: producerBatch.getLogstore(),
+		}
+		c.report.Undelivered[key] = count
+	}
+	count.Batches++
+	count.Logs += producerBatch.getLogCount()
+	count.Bytes += producerBatch.totalDataSize
+	if c.keepBatches {
+		batch := &UndeliveredBatch{
+			Project:   producerBatch.getProject(),
+			Logstore:  producerBatch.getLogstore(),
+			ShardHash: producerBatch.getShardHash(),
+			LogGroup:  producerBatch.logGroup,
+			LastError: err,
+		}
+		if producerBatch.isRawDataPooled() {
+			// the pooled buffer is reused once the batch is finished, hand out a decoded copy
+			logGroup := &sls.LogGroup{}
+			if proto.Unmarshal(producerBatch.rawData, logGroup) == nil {
+				batch.LogGroup = logGroup
+			} else {
+				batch.LogGroup, batch.RawData = nil, append([]byte{}, producerBatch.rawData...)
+			}
+		} else if producerBatch.rawData != nil {
+			batch.LogGroup, batch.RawData = nil, producerBatch.rawData
+		}
+		c.spillBatches = append(c.spillBatches, batch)
+	}
+}
+
+// Shutdown stops accepting new logs, flushes the cached batches and the retry queue,
+// and waits for the in-flight sends until ctx is done.
+// The failed batches are retried as usual until ctx is done, then the retries are disabled.
+// Batches that fail finally during the shutdown, and batches not sent yet when ctx is done,
+// are reported in the returned ShutdownReport and passed to ProducerConfig.SpillUndelivered.
+// The callbacks of batches not sent when ctx is done are called with error code TimeoutExecption.
+// The returned error is ctx.Err() if ctx is done before all data is handled.
+func (producer *Producer) Shutdown(ctx context.Context) (*ShutdownReport, error) {
+	collector := newUndeliveredCollector(producer.producerConfig)
+	producer.undelivered.Store(collector)
+	producer.monitor.Stop()
+	producer.stopAcceptingLogs()
+
+	// the io thread pool is drained once all the batches are handed to io workers or abandoned
+	drained := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		producer.moverWaitGroup.Wait()
+		producer.threadPool.ShutDown()
+		producer.ioThreadPoolWaitGroup.Wait()
+		close(drained)
+		producer.ioWorkerWaitGroup.Wait()
+		close(done)
+	}()
+
+	var err error
+	var inFlightBatches int64
+	select {
+	case <-done:
+		level.Info(producer.logger).Log("msg", "Producer shutdown finish")
+	case <-ctx.Done():
+		err = ctx.Err()
+		producer.abandonUnsentBatches(collector)
+		// the batches handed to the io thread pool by the mover are abandoned after the pool is drained,
+		// keep collecting them until then
+		<-drained
+		inFlightBatches = atomic.LoadInt64(&producer.threadPool.ioworker.taskCount)
+		level.Warn(producer.logger).Log("msg", "Producer shutdown timeout, some of the cached data is not sent",
+			"inFlightBatches", inFlightBatches)
+	}
+	producer.undelivered.Store(nil)
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	collector.closed = true
+	collector.report.Completed = err == nil
+	collector.report.InFlightBatches = inFlightBatches
+	if producer.producerConfig.SpillUndelivered != nil {
+		for _, batch := range collector.spillBatches {
+			producer.producerConfig.SpillUndelivered(batch)
+		}
+	}
+	if producer.producerConfig.ReportUndeliveredBatches {
+		collector.report.UndeliveredBatches = collector.spillBatches
+	}
+	return collector.report, err
+}
+
+// hasPendingData returns whether some batches are neither sent successfully nor failed finally
+func (producer *Producer) hasPendingData() bool {
+	return atomic.LoadInt64(&producer.producerLogGroupSize) > 0 || atomic.LoadInt64(&producer.producerLogCount) > 0
+}
+
+// abandonUnsentBatches takes all batches not handed to io workers yet, and fails them
+func (producer *Producer) abandonUnsentBatches(collector *undeliveredCollector) {
+	producer.threadPool.abandon()
+
+	producer.logAccumulator.lock.Lock()
+	batches := producer.logAccumulator.takeAllBatches()
+	producer.logAccumulator.lock.Unlock()
+	batches = append(batches, producer.threadPool.ioworker.retryQueue.getRetryBatch(true)...)
+	for _, taskCh := range []chan *ProducerBatch{producer.threadPool.highPriorityTaskCh, producer.threadPool.taskCh} {
+		for drained := false; !drained; {
+			select {
+			case batch, ok := <-taskCh:
+				if !ok {
+					drained = true
+				} else {
+					batches = append(batches, batch)
+				}
+			default:
+				drained = true
+			}
+		}
+	}
+
+	for _, batch := range batches {
+		producer.threadPool.ioworker.abandonBatch(batch, collector)
+	}
+}
+
+func (ioWorker *IoWorker) abandonBatch(producerBatch *ProducerBatch, collector *undeliveredCollector) {
+	var lastError *sls.Error
+	if producerBatch.attemptCount > 0 && len(producerBatch.result.attemptList) > 0 {
+		attempt := producerBatch.result.attemptList[len(producerBatch.result.attemptList)-1]
+		lastError = &sls.Error{Code: attempt.ErrorCode, Message: attempt.ErrorMessage, RequestID: attempt.RequestId}
+	}
+	if collector != nil {
+		collector.add(producerBatch, lastError)
+	}
+	err := &sls.Error{Code: TimeoutExecption, Message: "producer is shut down before the batch is sent"}
+	ioWorker.producer.stats.recordAbandoned(producerBatch, err)
+	ioWorker.finishBatch(producerBatch, err, time.Now())
+}
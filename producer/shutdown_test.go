@@ -0,0 +1,186 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type resultCollector struct {
+	mutex   sync.Mutex
+	results []*Result
+}
+
+func (c *resultCollector) Success(result *Result) { c.add(result) }
+func (c *resultCollector) Fail(result *Result)    { c.add(result) }
+
+func (c *resultCollector) add(result *Result) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.results = append(c.results, result)
+}
+
+func (c *resultCollector) get() []*Result {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]*Result{}, c.results...)
+}
+
+func TestProducerShutdownCompleted(t *testing.T) {
+	client := &mockSendClient{
+		errorFunc: func(project, logstore string, n int) error {
+			if logstore == "bad" {
+				return &sls.Error{HTTPCode: 404, Code: sls.LOGSTORE_NOT_EXIST, Message: "logstore not exist"}
+			}
+			return nil
+		},
+	}
+	config := GetDefaultProducerConfig()
+	config.ReportUndeliveredBatches = true
+	producer := newMockProducer(client, config)
+	producer.Start()
+
+	require.NoError(t, producer.SendLog("p", "good", "", "", GenerateLog(1, map[string]string{"k": "v"})))
+	require.NoError(t, producer.SendLog("p", "bad", "", "", GenerateLog(1, map[string]string{"k": "v"})))
+
+	report, err := producer.Shutdown(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.Completed)
+	assert.Equal(t, int64(0), report.InFlightBatches)
+	assert.Len(t, report.Undelivered, 1)
+	assert.Equal(t, 1, report.Undelivered["p|bad"].Logs)
+	require.Len(t, report.UndeliveredBatches, 1)
+	assert.Equal(t, "bad", report.UndeliveredBatches[0].Logstore)
+	assert.Equal(t, sls.LOGSTORE_NOT_EXIST, report.UndeliveredBatches[0].LastError.Code)
+
+	assert.Error(t, producer.SendLog("p", "good", "", "", GenerateLog(1, map[string]string{"k": "v"})))
+}
+
+func TestProducerShutdownTimeout(t *testing.T) {
+	client := &mockSendClient{latency: 300 * time.Millisecond}
+	config := GetDefaultProducerConfig()
+	config.MaxIoWorkerCount = 1
+	var spilled []*UndeliveredBatch
+	config.SpillUndelivered = func(batch *UndeliveredBatch) {
+		spilled = append(spilled, batch)
+	}
+	producer := newMockProducer(client, config)
+	producer.Start()
+
+	callback := &resultCollector{}
+	for i := 0; i < 3; i++ {
+		logstore := fmt.Sprintf("logstore-%d", i)
+		require.NoError(t, producer.SendLogWithCallBack("p", logstore, "", "", GenerateLog(1, map[string]string{"k": "v"}), callback))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	report, err := producer.Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.False(t, report.Completed)
+	assert.Nil(t, report.UndeliveredBatches)
+
+	undeliveredBatches := 0
+	for _, count := range report.Undelivered {
+		undeliveredBatches += count.Batches
+	}
+	assert.True(t, undeliveredBatches >= 1)
+	assert.Equal(t, int64(3), int64(undeliveredBatches)+report.InFlightBatches)
+	assert.Len(t, spilled, undeliveredBatches)
+	for _, batch := range spilled {
+		assert.Nil(t, batch.LastError)
+		assert.Len(t, batch.LogGroup.Logs, 1)
+	}
+
+	failed := 0
+	for _, result := range callback.get() {
+		if !result.IsSuccessful() {
+			assert.Equal(t, TimeoutExecption, result.GetErrorCode())
+			failed++
+		}
+	}
+	assert.Equal(t, undeliveredBatches, failed)
+
+	// the batches dropped by Shutdown are failed in the stats
+	stats := producer.Stats()
+	assert.Equal(t, int64(undeliveredBatches), stats.FailedBatches)
+	assert.Equal(t, int64(undeliveredBatches), stats.FailedLogs)
+}
+
+func TestProducerShutdownRetries(t *testing.T) {
+	client := &mockSendClient{
+		errorFunc: func(project, logstore string, n int) error {
+			if n < 2 {
+				return &sls.Error{HTTPCode: 500, Code: sls.SERVER_BUSY, Message: "server busy"}
+			}
+			return nil
+		},
+	}
+	config := GetDefaultProducerConfig()
+	config.BaseRetryBackoffMs = 10
+	producer := newMockProducer(client, config)
+	producer.Start()
+
+	callback := &resultCollector{}
+	require.NoError(t, producer.SendLogWithCallBack("p", "l", "", "", GenerateLog(1, map[string]string{"k": "v"}), callback))
+
+	// the batch is retried during the shutdown as the context allows
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	report, err := producer.Shutdown(ctx)
+	require.NoError(t, err)
+	assert.True(t, report.Completed)
+	assert.Empty(t, report.Undelivered)
+	assert.Len(t, client.sentRequests(), 3)
+	require.Len(t, callback.get(), 1)
+	assert.True(t, callback.get()[0].IsSuccessful())
+}
+
+func TestProducerShutdownTimeoutWithRetries(t *testing.T) {
+	client := &mockSendClient{
+		latency: 100 * time.Millisecond,
+		errorFunc: func(project, logstore string, n int) error {
+			return &sls.Error{HTTPCode: 500, Code: sls.SERVER_BUSY, Message: "server busy"}
+		},
+	}
+	config := GetDefaultProducerConfig()
+	config.MaxIoWorkerCount = 1
+	config.BaseRetryBackoffMs = 10
+	config.ReportUndeliveredBatches = true
+	producer := newMockProducer(client, config)
+	producer.Start()
+
+	callback := &resultCollector{}
+	for i := 0; i < 3; i++ {
+		logstore := fmt.Sprintf("logstore-%d", i)
+		require.NoError(t, producer.SendLogWithCallBack("p", logstore, "", "", GenerateLog(1, map[string]string{"k": "v"}), callback))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 350*time.Millisecond)
+	defer cancel()
+	report, err := producer.Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// the batches retried and handed to the io thread pool after the timeout are reported as well
+	undeliveredBatches := 0
+	for _, count := range report.Undelivered {
+		undeliveredBatches += count.Batches
+	}
+	assert.Equal(t, int64(3), int64(undeliveredBatches)+report.InFlightBatches)
+	assert.Len(t, report.UndeliveredBatches, undeliveredBatches)
+	retried := 0
+	for _, batch := range report.UndeliveredBatches {
+		if batch.LastError != nil {
+			assert.Equal(t, sls.SERVER_BUSY, batch.LastError.Code)
+			retried++
+		}
+	}
+	assert.True(t, retried >= 1)
+	assert.Eventually(t, func() bool { return len(callback.get()) == 3 }, 5*time.Second, 10*time.Millisecond)
+}
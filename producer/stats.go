@@ -0,0 +1,222 @@
+package producer
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+// the number of recent send latencies used to calculate percentiles
+const latencySampleSize = 1024
+
+// ProducerStats is a point-in-time snapshot of the producer status, returned by Producer.Stats.
+// Counters are cumulative since the producer is created.
+type ProducerStats struct {
+	PendingBytes        int64   // size of logs not sent yet or being sent, same as what TotalSizeLnBytes limits
+	PendingLogs         int64   // count of logs not sent yet or being sent
+	OpenBatches         int     // batches accumulating logs, not sealed yet
+	IoWorkerInUse       int64   // batches being sent, or waiting for an io worker
+	MaxIoWorkerCount    int64   // MaxIoWorkerCount of the producer config
+	IoWorkerUtilization float64 // IoWorkerInUse / MaxIoWorkerCount, may exceed 1 when batches wait for io workers
+	RetryQueueSize      int     // batches waiting in the retry queue
+
+	// in fan-out mode, a batch is counted once when all destinations are finished, by the FanoutPolicy
+	SuccessBatches int64
+	FailedBatches  int64 // batches failed finally, after all retries, or dropped by Shutdown before they are sent
+	RetryCount     int64 // failed send attempts that are retried
+	SuccessLogs    int64
+	FailedLogs     int64
+
+	// percentiles of the latency of recent send requests, including failed ones
+	SendLatencyP50 time.Duration
+	SendLatencyP90 time.Duration
+	SendLatencyP99 time.Duration
+
+	// key is project + "|" + logstore, or the name of the FanoutDestination in fan-out mode
+	Destinations map[string]*DestinationStats
+}
+
+// DestinationStats is the status of sending to one logstore, or to one FanoutDestination in fan-out mode.
+type DestinationStats struct {
+	Name            string // name of the FanoutDestination, empty if not in fan-out mode
+	Project         string
+	Logstore        string
+	SuccessBatches  int64
+	FailedBatches   int64
+	RetryCount      int64
+	LastError       *sls.Error // nil if no error happened yet
+	LastErrorTime   time.Time
+	LastSuccessTime time.Time
+}
+
+type statsCollector struct {
+	successBatches int64
+	failedBatches  int64
+	retryCount     int64
+	successLogs    int64
+	failedLogs     int64
+
+	mutex        sync.Mutex
+	latencies    []time.Duration // ring buffer
+	latencyIndex int
+	destinations map[string]*DestinationStats
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{
+		latencies:    make([]time.Duration, 0, latencySampleSize),
+		destinations: make(map[string]*DestinationStats),
+	}
+}
+
+func (c *statsCollector) recordSuccess(producerBatch *ProducerBatch, sendCost time.Duration) {
+	if producerBatch.fanout == nil {
+		c.countFinished(producerBatch, true)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.addLatency(sendCost)
+	d := c.getDestination(producerBatch)
+	d.SuccessBatches++
+	d.LastSuccessTime = time.Now()
+}
+
+func (c *statsCollector) recordFailure(producerBatch *ProducerBatch, err *sls.Error, sendCost time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.addLatency(sendCost)
+	c.addFailure(producerBatch, err)
+}
+
+// recordAbandoned records a batch failed without being sent, eg. dropped by Shutdown
+func (c *statsCollector) recordAbandoned(producerBatch *ProducerBatch, err *sls.Error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.addFailure(producerBatch, err)
+}
+
+// countFinished counts a batch and its logs once, the copies of a batch in fan-out mode are counted by their parent
+func (c *statsCollector) countFinished(producerBatch *ProducerBatch, successful bool) {
+	if successful {
+		atomic.AddInt64(&c.successBatches, 1)
+		atomic.AddInt64(&c.successLogs, int64(producerBatch.getLogCount()))
+	} else {
+		atomic.AddInt64(&c.failedBatches, 1)
+		atomic.AddInt64(&c.failedLogs, int64(producerBatch.getLogCount()))
+	}
+}
+
+// must be called with c.mutex held
+func (c *statsCollector) addFailure(producerBatch *ProducerBatch, err *sls.Error) {
+	if producerBatch.fanout == nil {
+		c.countFinished(producerBatch, false)
+	}
+	d := c.getDestination(producerBatch)
+	d.FailedBatches++
+	d.LastError = err
+	d.LastErrorTime = time.Now()
+}
+
+func (c *statsCollector) recordRetry(producerBatch *ProducerBatch, err *sls.Error, sendCost time.Duration) {
+	atomic.AddInt64(&c.retryCount, 1)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.addLatency(sendCost)
+	d := c.getDestination(producerBatch)
+	d.RetryCount++
+	d.LastError = err
+	d.LastErrorTime = time.Now()
+}
+
+// must be called with c.mutex held
+func (c *statsCollector) addLatency(sendCost time.Duration) {
+	if len(c.latencies) < latencySampleSize {
+		c.latencies = append(c.latencies, sendCost)
+		return
+	}
+	c.latencies[c.latencyIndex] = sendCost
+	c.latencyIndex = (c.latencyIndex + 1) % latencySampleSize
+}
+
+// must be called with c.mutex held
+func (c *statsCollector) getDestination(producerBatch *ProducerBatch) *DestinationStats {
+	key := producerBatch.getProject() + Delimiter + producerBatch.getLogstore()
+	if producerBatch.destination != "" {
+		// the destinations may have the same project and logstore in different regions
+		key = producerBatch.destination
+	}
+	d, ok := c.destinations[key]
+	if !ok {
+		d = &DestinationStats{
+			Name:     producerBatch.destination,
+			Project:  producerBatch.getProject(),
+			Logstore: producerBatch.getLogstore(),
+		}
+		c.destinations[key] = d
+	}
+	return d
+}
+
+func (c *statsCollector) fill(stats *ProducerStats) {
+	stats.SuccessBatches = atomic.LoadInt64(&c.successBatches)
+	stats.FailedBatches = atomic.LoadInt64(&c.failedBatches)
+	stats.RetryCount = atomic.LoadInt64(&c.retryCount)
+	stats.SuccessLogs = atomic.LoadInt64(&c.successLogs)
+	stats.FailedLogs = atomic.LoadInt64(&c.failedLogs)
+
+	c.mutex.Lock()
+	latencies := append([]time.Duration{}, c.latencies...)
+	stats.Destinations = make(map[string]*DestinationStats, len(c.destinations))
+	for key, d := range c.destinations {
+		copied := *d
+		stats.Destinations[key] = &copied
+	}
+	c.mutex.Unlock()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	stats.SendLatencyP50 = percentile(latencies, 0.5)
+	stats.SendLatencyP90 = percentile(latencies, 0.9)
+	stats.SendLatencyP99 = percentile(latencies, 0.99)
+}
+
+// sorted must be sorted in ascending order
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// Stats returns a snapshot of the producer status, it is thread safe and cheap enough to be called by health checks.
+func (producer *Producer) Stats() *ProducerStats {
+	stats := &ProducerStats{
+		PendingBytes:     atomic.LoadInt64(&producer.producerLogGroupSize),
+		PendingLogs:      atomic.LoadInt64(&producer.producerLogCount),
+		IoWorkerInUse:    atomic.LoadInt64(&producer.threadPool.ioworker.taskCount),
+		MaxIoWorkerCount: producer.threadPool.ioworker.maxIoWorker.getLimit(),
+		RetryQueueSize:   producer.threadPool.ioworker.retryQueue.size(),
+	}
+	if stats.MaxIoWorkerCount > 0 {
+		stats.IoWorkerUtilization = float64(stats.IoWorkerInUse) / float64(stats.MaxIoWorkerCount)
+	}
+
+	producer.logAccumulator.lock.Lock()
+	for _, batch := range producer.logAccumulator.logGroupData {
+		if batch != nil {
+			stats.OpenBatches++
+		}
+	}
+	producer.logAccumulator.lock.Unlock()
+
+	producer.stats.fill(stats)
+	return stats
+}
@@ -0,0 +1,64 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProducerStats(t *testing.T) {
+	client := &mockSendClient{
+		errorFunc: func(project, logstore string, n int) error {
+			if logstore == "bad" {
+				return &sls.Error{HTTPCode: 404, Code: sls.LOGSTORE_NOT_EXIST, Message: "logstore not exist"}
+			}
+			return nil
+		},
+		latency: time.Millisecond,
+	}
+	producer := newMockProducer(client, nil)
+
+	require.NoError(t, producer.SendLog("p", "good", "", "", GenerateLog(1, map[string]string{"k": "v"})))
+	require.NoError(t, producer.SendLog("p", "bad", "", "", GenerateLog(1, map[string]string{"k": "v"})))
+	stats := producer.Stats()
+	assert.Equal(t, 2, stats.OpenBatches)
+	assert.Equal(t, int64(2), stats.PendingLogs)
+	assert.Equal(t, 2*(logGroupHeaderSize()+int64(GetLogEncodedSize(GenerateLog(1, map[string]string{"k": "v"})))), stats.PendingBytes)
+	assert.Equal(t, int64(50), stats.MaxIoWorkerCount)
+
+	producer.Start()
+	producer.SafeClose()
+
+	stats = producer.Stats()
+	assert.Equal(t, 0, stats.OpenBatches)
+	assert.Equal(t, int64(0), stats.PendingLogs)
+	assert.Equal(t, int64(0), stats.PendingBytes)
+	assert.Equal(t, int64(0), stats.IoWorkerInUse)
+	assert.Equal(t, int64(1), stats.SuccessBatches)
+	assert.Equal(t, int64(1), stats.FailedBatches)
+	assert.Equal(t, int64(1), stats.SuccessLogs)
+	assert.Equal(t, int64(1), stats.FailedLogs)
+	assert.True(t, stats.SendLatencyP50 >= time.Millisecond)
+	assert.True(t, stats.SendLatencyP99 >= stats.SendLatencyP50)
+
+	require.Contains(t, stats.Destinations, "p|bad")
+	bad := stats.Destinations["p|bad"]
+	assert.Equal(t, int64(1), bad.FailedBatches)
+	assert.Equal(t, sls.LOGSTORE_NOT_EXIST, bad.LastError.Code)
+	good := stats.Destinations["p|good"]
+	assert.Nil(t, good.LastError)
+	assert.Equal(t, int64(1), good.SuccessBatches)
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 0, 100)
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	assert.Equal(t, time.Duration(50), percentile(sorted, 0.5))
+	assert.Equal(t, time.Duration(99), percentile(sorted, 0.99))
+	assert.Equal(t, time.Duration(0), percentile(nil, 0.5))
+}
@@ -0,0 +1,90 @@
+package producer
+
+import (
+	"testing"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendLogWithTags(t *testing.T) {
+	client := &mockSendClient{}
+	config := GetDefaultProducerConfig()
+	config.LogTags = []*sls.LogTag{{Key: proto.String("env"), Value: proto.String("prod")}}
+	config.GeneratePackId = true
+	producer := newMockProducer(client, config)
+	producer.Start()
+
+	tenantA := []*sls.LogTag{{Key: proto.String("tenant"), Value: proto.String("a")}}
+	tenantB := []*sls.LogTag{{Key: proto.String("tenant"), Value: proto.String("b")}}
+	require.NoError(t, producer.SendLogListWithOptions("p", "l", "", "", []*sls.Log{GenerateLog(1, map[string]string{"k": "v"})}, &SendOptions{Tags: tenantA}))
+	require.NoError(t, producer.SendLogListWithOptions("p", "l", "", "", []*sls.Log{GenerateLog(1, map[string]string{"k": "v"})}, &SendOptions{Tags: tenantA}))
+	require.NoError(t, producer.SendLogListWithOptions("p", "l", "", "", []*sls.Log{GenerateLog(1, map[string]string{"k": "v"})}, &SendOptions{Tags: tenantB}))
+	require.NoError(t, producer.SendLog("p", "l", "", "", GenerateLog(1, map[string]string{"k": "v"})))
+	producer.SafeClose()
+
+	requests := client.sentRequests()
+	require.Len(t, requests, 3)
+	logsByTenant := map[string]int{}
+	for _, r := range requests {
+		tags := map[string]string{}
+		for _, tag := range r.req.LogGroup.LogTags {
+			tags[tag.GetKey()] = tag.GetValue()
+		}
+		assert.Equal(t, "prod", tags["env"])
+		assert.NotEmpty(t, tags[PACK_ID_KEY])
+		assert.Equal(t, PACK_ID_KEY, r.req.LogGroup.LogTags[len(r.req.LogGroup.LogTags)-1].GetKey())
+		logsByTenant[tags["tenant"]] = len(r.req.LogGroup.Logs)
+	}
+	assert.Equal(t, map[string]int{"a": 2, "b": 1, "": 1}, logsByTenant)
+	assert.Len(t, config.LogTags, 1)
+}
+
+func TestSendLogListWithOptions(t *testing.T) {
+	client := &mockSendClient{}
+	config := GetDefaultProducerConfig()
+	config.AdjustShargHash = false
+	producer := newMockProducer(client, config)
+	producer.Start()
+
+	tenant := []*sls.LogTag{{Key: proto.String("tenant"), Value: proto.String("a")}}
+	logs := []*sls.Log{GenerateLog(1, map[string]string{"k": "v"})}
+	require.NoError(t, producer.SendLogListWithOptions("p", "l", "", "", logs, &SendOptions{Tags: tenant, Priority: PriorityHigh, ShardHash: "0f"}))
+	require.NoError(t, producer.SendLogListWithOptions("p", "l", "", "", logs, &SendOptions{Tags: tenant, ShardHash: "0f"}))
+	require.NoError(t, producer.SendLogListWithOptions("p", "l", "", "", logs, nil))
+	producer.SafeClose()
+
+	requests := client.sentRequests()
+	require.Len(t, requests, 3)
+	tagged := 0
+	for _, r := range requests {
+		if len(r.req.LogGroup.LogTags) == 0 {
+			assert.Empty(t, r.hashKey)
+			continue
+		}
+		tagged++
+		assert.Equal(t, "a", r.req.LogGroup.LogTags[0].GetValue())
+		require.NotNil(t, r.hashKey)
+		assert.Equal(t, "0f", *r.hashKey)
+	}
+	// tags and priority are combined, logs of different priorities are not merged
+	assert.Equal(t, 2, tagged)
+}
+
+func TestGetKeyStringWithTags(t *testing.T) {
+	accumulator := &LogAccumulator{}
+	tag := func(k, v string) []*sls.LogTag {
+		return []*sls.LogTag{{Key: proto.String(k), Value: proto.String(v)}}
+	}
+	assert.NotEqual(t,
+		accumulator.getKeyString("p", "l", "", "", "", tag("a|1", "b"), PriorityNormal),
+		accumulator.getKeyString("p", "l", "", "", "", tag("a", "1|b"), PriorityNormal))
+	assert.NotEqual(t,
+		accumulator.getKeyString("p", "l", "", "", "", nil, PriorityNormal),
+		accumulator.getKeyString("p", "l", "", "", "", tag("", ""), PriorityNormal))
+	assert.Equal(t,
+		accumulator.getKeyString("p", "l", "", "", "", tag("a", "b"), PriorityNormal),
+		accumulator.getKeyString("p", "l", "", "", "", tag("a", "b"), PriorityNormal))
+}
@@ -1,6 +1,8 @@
 package producer
 
 import (
+	"math/bits"
+
 	"github.com/aliyun/aliyun-log-go-sdk"
 	"github.com/gogo/protobuf/proto"
 )
@@ -37,6 +39,13 @@ func GetLogSizeCalculate(log *sls.Log) int {
 
 }
 
+// GetLogEncodedSize returns the exact size of the log in the LogGroup sent, which the producer
+// counts against TotalSizeLnBytes and MaxBatchSize.
+func GetLogEncodedSize(log *sls.Log) int {
+	size := log.Size()
+	return 1 + (bits.Len64(uint64(size)|1)+6)/7 + size
+}
+
 func GetLogListSize(logList []*sls.Log) int {
 	sizeInBytes := 0
 	for _, log := range logList {